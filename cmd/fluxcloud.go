@@ -1,74 +1,342 @@
 package main
 
 import (
-	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/justinbarrick/fluxcloud/pkg/alerts"
 	"github.com/justinbarrick/fluxcloud/pkg/apis"
+	"github.com/justinbarrick/fluxcloud/pkg/auth"
+	"github.com/justinbarrick/fluxcloud/pkg/certs"
 	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/dedupe"
+	"github.com/justinbarrick/fluxcloud/pkg/deployments"
+	"github.com/justinbarrick/fluxcloud/pkg/digest"
+	"github.com/justinbarrick/fluxcloud/pkg/dora"
 	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/filter"
 	"github.com/justinbarrick/fluxcloud/pkg/formatters"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+	"github.com/justinbarrick/fluxcloud/pkg/queue"
+	"github.com/justinbarrick/fluxcloud/pkg/report"
+	"github.com/justinbarrick/fluxcloud/pkg/routing"
+	"github.com/justinbarrick/fluxcloud/pkg/schedule"
+	"github.com/justinbarrick/fluxcloud/pkg/watchdog"
 )
 
-func initExporter(config config.Config) (exporter []exporters.Exporter) {
+func initExporter(config config.Config) []exporters.Exporter {
 	exporterType := config.Optional("exporter_type", "slack")
 
-	exporterTypes := strings.Split(exporterType, ",")
+	exporter, err := exporters.NewExporters(exporterType, config)
+	if err != nil {
+		logging.Fatal("Could not start fluxcloud", "err", err)
+	}
 
-	for _, v := range exporterTypes {
-		if v == "webhook" {
-			webhook, err := exporters.NewWebhook(config)
-			if err != nil {
-				log.Fatal(err)
-			}
-			exporter = append(exporter, webhook)
-		}
+	for _, e := range exporter {
+		logging.Info("Using exporter", "exporter", e.Name())
+	}
 
-		if v == "matrix" {
-			matrix, err := exporters.NewMatrix(config)
-			if err != nil {
-				log.Fatal(err)
-			}
-			exporter = append(exporter, matrix)
-		}
+	return exporter
+}
 
-		if v == "msteams" {
-			msteams, err := exporters.NewMSTeams(config)
-			if err != nil {
-				log.Fatal(err)
-			}
-			exporter = append(exporter, msteams)
-		}
+// Return the file set by setting, or name in the state directory of the
+// delivery queue if the queue is enabled. State is kept out of QUEUE_DIR itself
+// because every JSON file there is loaded as a delivery.
+func stateFile(config config.Config, setting string, name string) string {
+	if file := config.Optional(setting, ""); file != "" {
+		return file
+	}
 
-		if v == "slack" {
-			slack, err := exporters.NewSlack(config)
-			if err != nil {
-				log.Fatal(err)
-			}
-			exporter = append(exporter, slack)
-		}
+	queueDir := config.Optional("queue_dir", "")
+	if queueDir == "" {
+		return ""
 	}
 
-	for _, e := range exporter {
-		log.Printf("Using %s exporter", e.Name())
+	stateDir := filepath.Join(queueDir, "state")
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		logging.Fatal("Could not start fluxcloud", "err", err)
 	}
 
-	return exporter
+	return filepath.Join(stateDir, name)
 }
 
-func main() {
-	log.SetFlags(0)
+// Send the digests that are still being collected when fluxcloud is asked to
+// stop, then exit. Queued digests are sent once fluxcloud is back up.
+func flushOnShutdown(aggregator *digest.Aggregator) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	<-signals
+
+	logging.Info("Shutting down, sending digests", "events", aggregator.Len())
+	aggregator.Flush()
+	os.Exit(0)
+}
 
+func main() {
 	config := &config.DefaultConfig{}
+	if err := logging.Configure(config); err != nil {
+		logging.Fatal("Invalid logging configuration", "err", err)
+	}
 
 	formatter, err := formatters.NewDefaultFormatter(config)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("Could not start fluxcloud", "err", err)
 	}
 
 	apiConfig := apis.NewAPIConfig(formatter, initExporter(config), config)
 
+	for _, e := range apiConfig.Exporter {
+		apiConfig.Formatters[e.Name()], err = formatter.ForConfig(exporters.InstanceConfig(e, config))
+		if err != nil {
+			logging.Fatal("Could not start fluxcloud", "err", err)
+		}
+
+		apiConfig.Filters[e.Name()], err = filter.NewFilter(exporters.InstanceConfig(e, config), exporters.Type(e)+"_")
+		if err != nil {
+			logging.Fatal("Could not start fluxcloud", "err", err)
+		}
+	}
+
+	apiConfig.Auth, err = auth.Load(config)
+	if err != nil {
+		logging.Fatal("Could not start fluxcloud", "err", err)
+	}
+
+	apiConfig.Filter, err = filter.NewFilter(config, "")
+	if err != nil {
+		logging.Fatal("Could not start fluxcloud", "err", err)
+	}
+
+	configured := map[string]bool{}
+	specs := []string{}
+	for _, e := range apiConfig.Exporter {
+		configured[exporters.Spec(e)] = true
+		specs = append(specs, exporters.Spec(e))
+	}
+
+	if routesFile := config.Optional("routes_file", ""); routesFile != "" {
+		apiConfig.Router, err = routing.LoadRouter(routesFile)
+		if err != nil {
+			logging.Fatal("Could not start fluxcloud", "err", err)
+		}
+
+		if err := apiConfig.Router.CheckExporters(specs); err != nil {
+			logging.Fatal("Invalid setting", "setting", "ROUTES_FILE", "value", routesFile, "err", err)
+		}
+	}
+
+	if queueDir := config.Optional("queue_dir", ""); queueDir != "" {
+		options, err := queue.NewOptions(config)
+		if err != nil {
+			logging.Fatal("Could not start fluxcloud", "err", err)
+		}
+
+		apiConfig.Queue, err = queue.NewQueue(queueDir, options)
+		if err != nil {
+			logging.Fatal("Could not start fluxcloud", "err", err)
+		}
+
+		logging.Info("Queueing events", "dir", queueDir, "pending", apiConfig.Queue.Len())
+	}
+
+	if window := config.Optional("dedupe_window", ""); window != "" {
+		duration, err := time.ParseDuration(window)
+		if err != nil {
+			logging.Fatal("Invalid setting", "setting", "DEDUPE_WINDOW", "err", err)
+		}
+
+		dedupeFile := stateFile(config, "dedupe_file", "dedupe.json")
+
+		apiConfig.Dedupe, err = dedupe.NewDeduplicator(duration, dedupeFile)
+		if err != nil {
+			logging.Fatal("Could not start fluxcloud", "err", err)
+		}
+	}
+
+	digestWindow, err := time.ParseDuration(config.Optional("digest_window", "0s"))
+	if err != nil {
+		logging.Fatal("Invalid setting", "setting", "DIGEST_WINDOW", "err", err)
+	}
+
+	digestQuietPeriod, err := time.ParseDuration(config.Optional("digest_quiet_period", "0s"))
+	if err != nil {
+		logging.Fatal("Invalid setting", "setting", "DIGEST_QUIET_PERIOD", "err", err)
+	}
+
+	var reportSchedule *schedule.Schedule
+	if expression := config.Optional("report_schedule", ""); expression != "" {
+		reportSchedule, err = schedule.Parse(expression)
+		if err != nil {
+			logging.Fatal("Could not start fluxcloud", "err", err)
+		}
+
+		reportFile := stateFile(config, "report_file", "report.json")
+
+		apiConfig.Reports, err = report.NewRecorder(reportFile, time.Now())
+		if err != nil {
+			logging.Fatal("Could not start fluxcloud", "err", err)
+		}
+
+		for _, name := range strings.Split(config.Optional("report_exporters", ""), ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+
+			if !configured[name] {
+				logging.Fatal("Invalid setting", "setting", "REPORT_EXPORTERS", "value", name, "err", "not an exporter in EXPORTER_TYPE")
+			}
+
+			apiConfig.ReportExporters = append(apiConfig.ReportExporters, name)
+		}
+	}
+
+	if config.Optional("sync_error_alerts", "false") == "true" {
+		threshold, err := strconv.Atoi(config.Optional("sync_error_threshold", "1"))
+		if err != nil {
+			logging.Fatal("Invalid setting", "setting", "SYNC_ERROR_THRESHOLD", "err", err)
+		}
+
+		alertsFile := stateFile(config, "sync_error_file", "sync-errors.json")
+
+		apiConfig.Alerts, err = alerts.NewTracker(threshold, alertsFile)
+		if err != nil {
+			logging.Fatal("Could not start fluxcloud", "err", err)
+		}
+	}
+
+	if config.Optional("deployment_tracking", "false") == "true" {
+		if digestWindow > 0 || digestQuietPeriod > 0 {
+			logging.Fatal("Invalid setting", "setting", "DEPLOYMENT_TRACKING", "err", "cannot be used with DIGEST_WINDOW or DIGEST_QUIET_PERIOD")
+		}
+
+		ttl, err := time.ParseDuration(config.Optional("deployment_ttl", "1h"))
+		if err != nil {
+			logging.Fatal("Invalid setting", "setting", "DEPLOYMENT_TTL", "err", err)
+		}
+
+		deploymentsFile := stateFile(config, "deployment_file", "deployments.json")
+
+		apiConfig.Deployments, err = deployments.NewTracker(ttl, deploymentsFile)
+		if err != nil {
+			logging.Fatal("Could not start fluxcloud", "err", err)
+		}
+	}
+
+	if config.Optional("dora_metrics", "false") == "true" {
+		retention, err := time.ParseDuration(config.Optional("dora_retention", "720h"))
+		if err != nil {
+			logging.Fatal("Invalid setting", "setting", "DORA_RETENTION", "err", err)
+		}
+
+		doraFile := stateFile(config, "dora_file", "dora.json")
+
+		apiConfig.DORA, err = dora.NewTracker(retention, doraFile)
+		if err != nil {
+			logging.Fatal("Could not start fluxcloud", "err", err)
+		}
+	}
+
+	var watchdogInterval time.Duration
+	if threshold := config.Optional("watchdog_threshold", ""); threshold != "" {
+		watchdogThreshold, err := time.ParseDuration(threshold)
+		if err != nil {
+			logging.Fatal("Invalid setting", "setting", "WATCHDOG_THRESHOLD", "err", err)
+		}
+
+		watchdogInterval, err = time.ParseDuration(config.Optional("watchdog_interval", "1m"))
+		if err != nil || watchdogInterval <= 0 {
+			logging.Fatal("Invalid setting", "setting", "WATCHDOG_INTERVAL", "value", config.Optional("watchdog_interval", "1m"))
+		}
+
+		watchdogSyncThreshold, err := time.ParseDuration(config.Optional("watchdog_sync_threshold", "0s"))
+		if err != nil {
+			logging.Fatal("Invalid setting", "setting", "WATCHDOG_SYNC_THRESHOLD", "err", err)
+		}
+
+		watchdogFile := stateFile(config, "watchdog_file", "watchdog.json")
+
+		apiConfig.Watchdog, err = watchdog.NewWatchdog(watchdogThreshold, watchdogSyncThreshold, watchdogFile, time.Now())
+		if err != nil {
+			logging.Fatal("Could not start fluxcloud", "err", err)
+		}
+	}
+
+	var tlsReloadInterval time.Duration
+	certFile, keyFile := config.Optional("tls_cert_file", ""), config.Optional("tls_key_file", "")
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			logging.Fatal("Invalid setting", "setting", "TLS_CERT_FILE", "err", "TLS_CERT_FILE and TLS_KEY_FILE must both be set")
+		}
+
+		tlsReloadInterval, err = time.ParseDuration(config.Optional("tls_reload_interval", "1m"))
+		if err != nil || tlsReloadInterval <= 0 {
+			logging.Fatal("Invalid setting", "setting", "TLS_RELOAD_INTERVAL", "value", config.Optional("tls_reload_interval", "1m"))
+		}
+
+		apiConfig.TLS, err = certs.NewReloader(certFile, keyFile, config.Optional("tls_client_ca_file", ""))
+		if err != nil {
+			logging.Fatal("Could not start fluxcloud", "err", err)
+		}
+	}
+
+	// the aggregator sends digests with the rest of apiConfig, and may send
+	// the ones it loads at once
+	if digestWindow > 0 || digestQuietPeriod > 0 {
+		digestFile := stateFile(config, "digest_file", "digest.json")
+
+		apiConfig.Digest, err = apis.NewAggregator(apiConfig, digestWindow, digestQuietPeriod, digestFile)
+		if err != nil {
+			logging.Fatal("Could not start fluxcloud", "err", err)
+		}
+	}
+
+	if config.Optional("startup_check", "false") == "true" {
+		startupCheckInterval, err := time.ParseDuration(config.Optional("startup_check_interval", "1m"))
+		if err != nil || startupCheckInterval <= 0 {
+			logging.Fatal("Invalid setting", "setting", "STARTUP_CHECK_INTERVAL", "value", config.Optional("startup_check_interval", "1m"))
+		}
+
+		if err := apis.CheckExporters(apiConfig); err != nil {
+			logging.Error("Startup check failed", "err", err)
+		}
+
+		go apis.RecheckExporters(apiConfig, startupCheckInterval, nil)
+	}
+
+	if apiConfig.Queue != nil {
+		go apis.ProcessQueue(apiConfig, nil)
+	}
+
+	if apiConfig.TLS != nil {
+		go apiConfig.TLS.Watch(tlsReloadInterval, nil)
+	}
+
+	if reportSchedule != nil {
+		go apis.RunReports(apiConfig, reportSchedule, nil)
+	}
+
+	if apiConfig.Watchdog != nil {
+		go apis.RunWatchdog(apiConfig, watchdogInterval, nil)
+	}
+
+	if apiConfig.Digest != nil {
+		go flushOnShutdown(apiConfig.Digest)
+	}
+
 	apis.HandleWebsocket(apiConfig)
 	apis.HandleV6(apiConfig)
-	log.Fatal(apiConfig.Listen(config.Optional("listen_address", ":3031")))
+	apis.HandleDeadLetters(apiConfig)
+	if err := apis.HandleMetrics(apiConfig); err != nil {
+		logging.Fatal("Could not start fluxcloud", "err", err)
+	}
+	apis.HandleHealth(apiConfig)
+	if err := apis.HandleDORA(apiConfig); err != nil {
+		logging.Fatal("Could not start fluxcloud", "err", err)
+	}
+	logging.Fatal("Could not listen", "err", apiConfig.Listen(config.Optional("listen_address", ":3031")))
 }
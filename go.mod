@@ -35,6 +35,7 @@ require (
 	github.com/spf13/pflag v1.0.1
 	github.com/stretchr/testify v1.2.2
 	github.com/weaveworks/flux v0.0.0-20190411145155-fea56bc3feee
+	github.com/whilp/git-urls v1.0.0 // indirect
 	go.opencensus.io v0.20.2
 	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
 	golang.org/x/net v0.0.0-20190311183353-d8887717615a
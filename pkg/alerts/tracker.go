@@ -0,0 +1,180 @@
+package alerts
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
+	"github.com/weaveworks/flux"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+// A resource that is failing to sync.
+type Failure struct {
+	Cluster string          `json:"cluster,omitempty"`
+	ID      flux.ResourceID `json:"id"`
+	Path    string          `json:"path"`
+	Error   string          `json:"error"`
+
+	// The number of consecutive syncs that the resource failed.
+	Failures  int       `json:"failures"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+
+	// Whether an alert was sent for the failure.
+	Alerted bool `json:"alerted"`
+}
+
+// An alert about resources that started failing, or that were failing and
+// have been resolved.
+type Alert struct {
+	Resolved bool
+	Failures []Failure
+}
+
+// Tracks the resources that fail to sync across sync events, so that each
+// failure is alerted once when it starts and once when it is resolved. If a
+// file is set the failures are saved to it so that they survive restarts.
+type Tracker struct {
+	threshold int
+	file      string
+	lock      sync.Mutex
+	failures  map[string]*Failure
+}
+
+// Create a tracker that alerts once a resource has failed threshold
+// consecutive syncs, loading the failures saved in file if it is set.
+func NewTracker(threshold int, file string) (*Tracker, error) {
+	if threshold < 1 {
+		threshold = 1
+	}
+
+	t := &Tracker{
+		threshold: threshold,
+		file:      file,
+		failures:  map[string]*Failure{},
+	}
+
+	if err := utils.LoadJSON(file, &t.failures, "sync error state"); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Update the failures of a cluster from its sync event, returning the alerts
+// to send: the failures that reached the threshold and the alerted failures
+// that the event no longer reports. Other events are ignored.
+func (t *Tracker) Track(cluster string, event fluxevent.Event, now time.Time) ([]Alert, error) {
+	if event.Type != fluxevent.EventSync {
+		return nil, nil
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	firing := []Failure{}
+	resolved := []Failure{}
+	failing := map[string]bool{}
+
+	for _, resourceError := range utils.GetErrors(event.Metadata) {
+		key := utils.ClusterKey(cluster, resourceError.ID.String())
+		failing[key] = true
+
+		failure, ok := t.failures[key]
+		if !ok {
+			failure = &Failure{
+				Cluster:   cluster,
+				ID:        resourceError.ID,
+				FirstSeen: now,
+			}
+			t.failures[key] = failure
+		}
+
+		failure.Path = resourceError.Path
+		failure.Error = resourceError.Error
+		failure.Failures++
+		failure.LastSeen = now
+
+		if !failure.Alerted && failure.Failures >= t.threshold {
+			failure.Alerted = true
+			firing = append(firing, *failure)
+		}
+	}
+
+	for key, failure := range t.failures {
+		// every sync reports all of its cluster's failures
+		if failing[key] || failure.Cluster != cluster {
+			continue
+		}
+
+		if failure.Alerted {
+			resolved = append(resolved, *failure)
+		}
+		delete(t.failures, key)
+	}
+
+	alerts := []Alert{}
+	if len(firing) > 0 {
+		alerts = append(alerts, Alert{Failures: firing})
+	}
+
+	if len(resolved) > 0 {
+		sort.Slice(resolved, func(i, j int) bool {
+			return resolved[i].ID.String() < resolved[j].ID.String()
+		})
+		alerts = append(alerts, Alert{Resolved: true, Failures: resolved})
+	}
+
+	return alerts, t.save()
+}
+
+// Return the resources that are currently failing.
+func (t *Tracker) Failures() []Failure {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	failures := []Failure{}
+	for _, failure := range t.failures {
+		failures = append(failures, *failure)
+	}
+
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].ID.String() < failures[j].ID.String()
+	})
+
+	return failures
+}
+
+// Return an event describing an alert, used to filter and route it like the
+// events it came from.
+func (a Alert) Event() fluxevent.Event {
+	event := fluxevent.Event{
+		Type:     fluxevent.EventSync,
+		LogLevel: fluxevent.LogLevelError,
+	}
+
+	metadata := &fluxevent.SyncEventMetadata{}
+	for _, failure := range a.Failures {
+		event.ServiceIDs = append(event.ServiceIDs, failure.ID)
+		if !a.Resolved {
+			metadata.Errors = append(metadata.Errors, fluxevent.ResourceError{
+				ID:    failure.ID,
+				Path:  failure.Path,
+				Error: failure.Error,
+			})
+		}
+	}
+
+	if a.Resolved {
+		event.LogLevel = fluxevent.LogLevelInfo
+	}
+
+	event.Metadata = metadata
+	return event
+}
+
+func (t *Tracker) save() error {
+	return utils.SaveJSON(t.file, t.failures)
+}
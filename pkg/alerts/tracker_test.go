@@ -0,0 +1,162 @@
+package alerts
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/flux"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+func TestTrackerAlertsOnce(t *testing.T) {
+	tracker, err := NewTracker(1, "")
+	require.Nil(t, err)
+
+	now := time.Now()
+
+	alerts, err := tracker.Track("production", test_utils.NewFluxSyncErrorEvent(), now)
+	require.Nil(t, err)
+	require.Len(t, alerts, 1)
+	assert.False(t, alerts[0].Resolved)
+	require.Len(t, alerts[0].Failures, 2)
+	assert.Equal(t, "manifests/test.yaml", alerts[0].Failures[0].Path)
+
+	alerts, err = tracker.Track("production", test_utils.NewFluxSyncErrorEvent(), now.Add(time.Minute))
+	require.Nil(t, err)
+	assert.Len(t, alerts, 0)
+
+	failures := tracker.Failures()
+	require.Len(t, failures, 2)
+	assert.Equal(t, 2, failures[0].Failures)
+	assert.Equal(t, now, failures[0].FirstSeen)
+}
+
+func TestTrackerResolved(t *testing.T) {
+	tracker, err := NewTracker(1, "")
+	require.Nil(t, err)
+
+	now := time.Now()
+
+	_, err = tracker.Track("production", test_utils.NewFluxSyncErrorEvent(), now)
+	require.Nil(t, err)
+
+	alerts, err := tracker.Track("production", test_utils.NewFluxSyncEvent(), now.Add(time.Minute))
+	require.Nil(t, err)
+	require.Len(t, alerts, 1)
+	assert.True(t, alerts[0].Resolved)
+	require.Len(t, alerts[0].Failures, 2)
+	assert.Equal(t, flux.MustParseResourceID("default:persistentvolumeclaim/lol"), alerts[0].Failures[0].ID)
+	assert.Len(t, tracker.Failures(), 0)
+}
+
+func TestTrackerPerCluster(t *testing.T) {
+	tracker, err := NewTracker(1, "")
+	require.Nil(t, err)
+
+	now := time.Now()
+
+	alerts, err := tracker.Track("production", test_utils.NewFluxSyncErrorEvent(), now)
+	require.Nil(t, err)
+	require.Len(t, alerts, 1)
+
+	// the same failures in another cluster are alerted on their own
+	alerts, err = tracker.Track("staging", test_utils.NewFluxSyncErrorEvent(), now)
+	require.Nil(t, err)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "staging", alerts[0].Failures[0].Cluster)
+
+	// and a clean sync of one cluster does not resolve the other's
+	alerts, err = tracker.Track("staging", test_utils.NewFluxSyncEvent(), now.Add(time.Minute))
+	require.Nil(t, err)
+	require.Len(t, alerts, 1)
+	assert.True(t, alerts[0].Resolved)
+	assert.Equal(t, "staging", alerts[0].Failures[0].Cluster)
+
+	failures := tracker.Failures()
+	require.Len(t, failures, 2)
+	assert.Equal(t, "production", failures[0].Cluster)
+}
+
+func TestTrackerIgnoresOtherEvents(t *testing.T) {
+	tracker, err := NewTracker(1, "")
+	require.Nil(t, err)
+
+	_, err = tracker.Track("production", test_utils.NewFluxSyncErrorEvent(), time.Now())
+	require.Nil(t, err)
+
+	alerts, err := tracker.Track("production", test_utils.NewFluxCommitEvent(), time.Now())
+	require.Nil(t, err)
+	assert.Len(t, alerts, 0)
+	assert.Len(t, tracker.Failures(), 2)
+}
+
+func TestTrackerThreshold(t *testing.T) {
+	tracker, err := NewTracker(3, "")
+	require.Nil(t, err)
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		alerts, err := tracker.Track("production", test_utils.NewFluxSyncErrorEvent(), now)
+		require.Nil(t, err)
+		assert.Len(t, alerts, 0)
+	}
+
+	// resolved before reaching the threshold, nothing to resolve
+	alerts, err := tracker.Track("production", test_utils.NewFluxSyncEvent(), now)
+	require.Nil(t, err)
+	assert.Len(t, alerts, 0)
+
+	for i := 0; i < 3; i++ {
+		alerts, err = tracker.Track("production", test_utils.NewFluxSyncErrorEvent(), now)
+		require.Nil(t, err)
+	}
+	require.Len(t, alerts, 1)
+	assert.Equal(t, 3, alerts[0].Failures[0].Failures)
+}
+
+func TestTrackerPersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alerts")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "sync-errors.json")
+
+	tracker, err := NewTracker(1, file)
+	require.Nil(t, err)
+	_, err = tracker.Track("production", test_utils.NewFluxSyncErrorEvent(), time.Now())
+	require.Nil(t, err)
+
+	tracker, err = NewTracker(1, file)
+	require.Nil(t, err)
+
+	alerts, err := tracker.Track("production", test_utils.NewFluxSyncErrorEvent(), time.Now())
+	require.Nil(t, err)
+	assert.Len(t, alerts, 0)
+
+	alerts, err = tracker.Track("production", test_utils.NewFluxSyncEvent(), time.Now())
+	require.Nil(t, err)
+	require.Len(t, alerts, 1)
+	assert.True(t, alerts[0].Resolved)
+}
+
+func TestAlertEvent(t *testing.T) {
+	id := flux.MustParseResourceID("default:deployment/test")
+	alert := Alert{Failures: []Failure{{ID: id, Path: "test.yaml", Error: "boom"}}}
+
+	event := alert.Event()
+	assert.Equal(t, fluxevent.EventSync, event.Type)
+	assert.Equal(t, fluxevent.LogLevelError, event.LogLevel)
+	assert.Equal(t, []flux.ResourceID{id}, event.ServiceIDs)
+	assert.Len(t, event.Metadata.(*fluxevent.SyncEventMetadata).Errors, 1)
+
+	alert.Resolved = true
+	event = alert.Event()
+	assert.Equal(t, fluxevent.LogLevelInfo, event.LogLevel)
+	assert.Len(t, event.Metadata.(*fluxevent.SyncEventMetadata).Errors, 0)
+}
@@ -0,0 +1,73 @@
+package apis
+
+import (
+	"context"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/alerts"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/filter"
+	"github.com/justinbarrick/fluxcloud/pkg/formatters"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+// Track the sync errors in an event, returning the event with its errors
+// removed since they are sent as alerts instead, whether the event should
+// still be announced and the alerts to send. Sync events that only repeat
+// errors are not announced.
+func trackErrors(config APIConfig, cluster string, event fluxevent.Event) (fluxevent.Event, bool, []alerts.Alert) {
+	if config.Alerts == nil {
+		return event, true, nil
+	}
+
+	alertsToSend, err := config.Alerts.Track(cluster, event, time.Now())
+	if err != nil {
+		logging.Error("Could not save sync error state", "err", err)
+	}
+
+	metadata, ok := event.Metadata.(*fluxevent.SyncEventMetadata)
+	if !ok || len(metadata.Errors) == 0 {
+		return event, true, alertsToSend
+	}
+
+	withoutErrors := *metadata
+	withoutErrors.Errors = nil
+	event.Metadata = &withoutErrors
+
+	return event, len(utils.GetCommits(event.Metadata)) > 0, alertsToSend
+}
+
+// Send alerts through every exporter that the filters and routing rules let
+// send them, queueing them if the delivery queue is enabled. The event type
+// and ONLY_ERRORS filters do not apply, see filter.AllowsAlert.
+func sendAlerts(ctx context.Context, config APIConfig, alertsToSend []alerts.Alert) {
+	for _, alert := range alertsToSend {
+		alert := alert
+		sendAlert(ctx, config, alert.Event(), "sync error alert", (*filter.Filter).AllowsAlert, func(formatter formatters.Formatter, exporter exporters.Exporter) msg.Message {
+			return formatter.FormatAlert(alert, exporter)
+		})
+	}
+}
+
+// Send an alert described by event through every exporter that the routing
+// rules, and the filters according to allows, let send it. Alerts skip
+// deduplication and digests, and failures are only logged.
+func sendAlert(ctx context.Context, config APIConfig, event fluxevent.Event, description string, allows func(*filter.Filter, fluxevent.Event) bool, format func(formatters.Formatter, exporters.Exporter) msg.Message) {
+	// alerts are already only sent once
+	config.Dedupe = nil
+
+	formatAlert := func(event fluxevent.Event, exporter exporters.Exporter) msg.Message {
+		return config.formatRouted(event, exporter, allows, func(formatter formatters.Formatter) msg.Message {
+			return format(formatter, exporter)
+		})
+	}
+
+	for _, result := range announce(ctx, config, event, formatAlert) {
+		if result.Status == ExporterFailed {
+			logging.Error("Could not send "+description, "exporter", result.Exporter, "err", result.Error)
+		}
+	}
+}
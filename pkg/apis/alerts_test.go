@@ -0,0 +1,74 @@
+package apis
+
+import (
+	"testing"
+
+	"github.com/justinbarrick/fluxcloud/pkg/alerts"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/filter"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+func TestHandleV6SyncErrorAlerts(t *testing.T) {
+	fakeExporter := &exporters.FakeExporter{}
+	apiConfig := newTestAPI(t, nil, fakeExporter)
+
+	var err error
+	apiConfig.Alerts, err = alerts.NewTracker(1, "")
+	require.NoError(t, err)
+	HandleV6(apiConfig)
+
+	post := func(event fluxevent.Event) string {
+		status, results := postEvent(t, apiConfig, event)
+		assert.Equal(t, 200, status)
+		return results[0].Status
+	}
+
+	// the first failing sync is announced without its errors, which are alerted
+	assert.Equal(t, ExporterSent, post(test_utils.NewFluxSyncErrorEvent()))
+	require.Len(t, fakeExporter.Sent, 2)
+	assert.NotContains(t, fakeExporter.Sent[0].Body, "Errors:")
+	assert.Equal(t, "alert", fakeExporter.Sent[1].Type)
+
+	// repeated failures without new commits are not announced
+	repeat := test_utils.NewFluxSyncErrorEvent()
+	repeat.Metadata.(*fluxevent.SyncEventMetadata).Commits = nil
+	assert.Equal(t, ExporterSkipped, post(repeat))
+	assert.Len(t, fakeExporter.Sent, 2)
+
+	// a sync without the errors resolves them
+	assert.Equal(t, ExporterSent, post(test_utils.NewFluxSyncEvent()))
+	require.Len(t, fakeExporter.Sent, 4)
+	assert.Equal(t, "resolved", fakeExporter.Sent[3].Type)
+}
+
+func TestHandleV6SyncErrorAlertsIgnoreEventFilters(t *testing.T) {
+	fakeExporter := &exporters.FakeExporter{}
+	apiConfig := newTestAPI(t, map[string]string{
+		"exclude_event_types": "sync",
+		"only_errors":         "true",
+		"include_namespaces":  "default",
+	}, fakeExporter)
+
+	var err error
+	apiConfig.Alerts, err = alerts.NewTracker(1, "")
+	require.NoError(t, err)
+	apiConfig.Filter, err = filter.NewFilter(apiConfig.Config, "")
+	require.NoError(t, err)
+	HandleV6(apiConfig)
+
+	// sync events are filtered, but the alerts about them are not
+	status, _ := postEvent(t, apiConfig, test_utils.NewFluxSyncErrorEvent())
+	assert.Equal(t, 200, status)
+	require.Len(t, fakeExporter.Sent, 1)
+	assert.Equal(t, "alert", fakeExporter.Sent[0].Type)
+
+	// resolved alerts have no errors, ONLY_ERRORS does not drop them
+	status, _ = postEvent(t, apiConfig, test_utils.NewFluxSyncEvent())
+	assert.Equal(t, 200, status)
+	require.Len(t, fakeExporter.Sent, 2)
+	assert.Equal(t, "resolved", fakeExporter.Sent[1].Type)
+}
@@ -1,15 +1,30 @@
 package apis
 
 import (
+	"encoding/json"
+	"github.com/justinbarrick/fluxcloud/pkg/alerts"
+	"github.com/justinbarrick/fluxcloud/pkg/auth"
+	"github.com/justinbarrick/fluxcloud/pkg/certs"
 	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/dedupe"
+	"github.com/justinbarrick/fluxcloud/pkg/deployments"
+	"github.com/justinbarrick/fluxcloud/pkg/digest"
+	"github.com/justinbarrick/fluxcloud/pkg/dora"
 	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/filter"
 	"github.com/justinbarrick/fluxcloud/pkg/formatters"
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/queue"
+	"github.com/justinbarrick/fluxcloud/pkg/report"
+	"github.com/justinbarrick/fluxcloud/pkg/routing"
+	"github.com/justinbarrick/fluxcloud/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
+	fluxevent "github.com/weaveworks/flux/event"
 	"go.opencensus.io/exporter/jaeger"
 	"go.opencensus.io/plugin/ochttp"
 	"go.opencensus.io/trace"
 	"net/http"
 	"os"
-	"time"
 )
 
 // All of the configuration necessary to run a fluxcloud API
@@ -19,23 +34,153 @@ type APIConfig struct {
 	Exporter  []exporters.Exporter
 	Formatter formatters.Formatter
 	Config    config.Config
+	Sessions  *Sessions
+
+	// If set, events are queued and sent in the background by ProcessQueue
+	// instead of being sent while Flux waits.
+	Queue *queue.Queue
+
+	// Formatters for exporters that do not use the default Formatter, keyed
+	// by exporter name.
+	Formatters map[string]formatters.Formatter
+
+	// If set, decides which exporters send each event and where they send it.
+	Router *routing.Router
+
+	// If set, drops events before they are sent to any exporter.
+	Filter *filter.Filter
+
+	// Filters for each exporter, keyed by exporter name.
+	Filters map[string]*filter.Filter
+
+	// If set, suppresses copies of events that were already sent.
+	Dedupe *dedupe.Deduplicator
+
+	// If set, events are collected and sent as digests, see NewAggregator.
+	Digest *digest.Aggregator
+
+	// If set, events are recorded for scheduled reports, see RunReports.
+	Reports *report.Recorder
+
+	// The exporters that send reports, named as in EXPORTER_TYPE. If empty,
+	// all exporters send reports.
+	ReportExporters []string
+
+	// If set, sync errors are alerted once when they start and when they are
+	// resolved instead of with every sync.
+	Alerts *alerts.Tracker
+
+	// If set, alerts are sent when a cluster stops syncing, see RunWatchdog.
+	Watchdog *watchdog.Watchdog
+
+	// If set, commit, sync and release events are correlated into
+	// deployments that are announced as one notification that changes with
+	// each event.
+	Deployments *deployments.Tracker
+
+	// The Prometheus metrics served on /metrics, see HandleMetrics.
+	Metrics *prometheus.Registry
+
+	// If set, DORA metrics are computed from the events, see HandleDORA.
+	DORA *dora.Tracker
+
+	// If set, events and websocket connections are only accepted from Flux
+	// daemons that send a valid token.
+	Auth *auth.Authenticator
+
+	// The name of the cluster that requests without a cluster token come
+	// from, see clusterName.
+	ClusterName string
+
+	// If set, Listen serves HTTPS with its certificate, and requires client
+	// certificates if it has a client CA bundle.
+	TLS *certs.Reloader
+
+	apiMetrics *apiMetrics
+	health     *healthState
 }
 
 // Initialize API configuration
 func NewAPIConfig(f formatters.Formatter, e []exporters.Exporter, c config.Config) APIConfig {
+	registry := prometheus.NewRegistry()
+	metrics := newAPIMetrics(registry)
+
 	return APIConfig{
 		Server: http.NewServeMux(),
+		// exporters are given their own deadlines through the request context
 		Client: &http.Client{
-			Timeout:   120 * time.Second,
-			Transport: &ochttp.Transport{},
+			Transport: &instrumentedTransport{
+				next:    &ochttp.Transport{},
+				metrics: metrics,
+			},
 		},
-		Formatter: f,
-		Exporter:  e,
-		Config:    c,
+		Formatter:   f,
+		Exporter:    e,
+		Config:      c,
+		Sessions:    NewSessions(),
+		ClusterName: c.Optional("cluster_name", DefaultClusterName),
+		Formatters:  map[string]formatters.Formatter{},
+		Filters:     map[string]*filter.Filter{},
+		Metrics:     registry,
+		apiMetrics:  metrics,
+		health:      newHealthState(),
+	}
+}
+
+// Return the formatter to use for an exporter.
+func (a *APIConfig) FormatterFor(exporter exporters.Exporter) formatters.Formatter {
+	if formatter, ok := a.Formatters[exporter.Name()]; ok {
+		return formatter
+	}
+
+	return a.Formatter
+}
+
+// Apply the filters and routing rules to an event, returning whether an
+// exporter should send it and the destinations it was routed to.
+func (a *APIConfig) Route(event fluxevent.Event, exporter exporters.Exporter) (bool, []string) {
+	return a.route(event, exporter, (*filter.Filter).Allows)
+}
+
+// Apply the routing rules to an event if the global and exporter filters let
+// it through according to allows.
+func (a *APIConfig) route(event fluxevent.Event, exporter exporters.Exporter, allows func(*filter.Filter, fluxevent.Event) bool) (bool, []string) {
+	if !allows(a.Filter, event) || !allows(a.Filters[exporter.Name()], event) {
+		return false, nil
 	}
+
+	return a.Router.Route(event, exporters.Spec(exporter))
+}
+
+// Filter an event, format it for an exporter and apply the routing rules. If
+// the exporter should not send the event the message has no title.
+func (a *APIConfig) FormatEvent(event fluxevent.Event, exporter exporters.Exporter) msg.Message {
+	return a.formatRouted(event, exporter, (*filter.Filter).Allows, func(formatter formatters.Formatter) msg.Message {
+		return formatter.FormatEvent(event, exporter)
+	})
+}
+
+// Format a message for an exporter with format if the routing rules, and the
+// filters according to allows, let the exporter send event.
+func (a *APIConfig) formatRouted(event fluxevent.Event, exporter exporters.Exporter, allows func(*filter.Filter, fluxevent.Event) bool, format func(formatters.Formatter) msg.Message) msg.Message {
+	routed, destinations := a.route(event, exporter, allows)
+	if !routed {
+		a.apiMetrics.formatted(exporter.Name(), "filtered")
+		return msg.Message{}
+	}
+
+	message := format(a.FormatterFor(exporter))
+	if message.Title == "" {
+		a.apiMetrics.formatted(exporter.Name(), "skipped")
+	} else {
+		a.apiMetrics.formatted(exporter.Name(), "sent")
+	}
+
+	message.Destinations = destinations
+	return message
 }
 
-// Listen on addr
+// Listen on addr, serving HTTPS if TLS is set.
 func (a *APIConfig) Listen(addr string) error {
 	if os.Getenv("JAEGER_ENDPOINT") != "" {
 		exporter, err := jaeger.NewExporter(jaeger.Options{
@@ -58,5 +203,35 @@ func (a *APIConfig) Listen(addr string) error {
 		Handler: &ochttp.Handler{Handler: a.Server, IsPublicEndpoint: false},
 	}
 
+	if a.TLS != nil {
+		server.TLSConfig = a.TLS.TLSConfig()
+		return server.ListenAndServeTLS("", "")
+	}
+
 	return server.ListenAndServe()
 }
+
+// Return the configured exporter that was created with a name, such as
+// "slack:ops", or failing that the one whose Name() it is, or nil.
+func (a *APIConfig) exporterByName(name string) exporters.Exporter {
+	for _, exporter := range a.Exporter {
+		if exporters.Spec(exporter) == name {
+			return exporter
+		}
+	}
+
+	for _, exporter := range a.Exporter {
+		if exporter.Name() == name {
+			return exporter
+		}
+	}
+
+	return nil
+}
+
+// Write a JSON response.
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
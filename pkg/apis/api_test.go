@@ -0,0 +1,53 @@
+package apis
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/formatters"
+	"github.com/stretchr/testify/require"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+// Create an APIConfig for request tests that formats events with the default
+// formatter, configured with github_url and settings, and sends them through
+// the exporters. Handlers copy the APIConfig, so they must be registered after
+// the test has set it up.
+func newTestAPI(t *testing.T, settings map[string]string, exporter ...exporters.Exporter) APIConfig {
+	sharedConfig := config.NewFakeConfig()
+	sharedConfig.Set("github_url", "https://github.com")
+	for key, value := range settings {
+		sharedConfig.Set(key, value)
+	}
+
+	formatter, err := formatters.NewDefaultFormatter(sharedConfig)
+	require.NoError(t, err)
+
+	return NewAPIConfig(formatter, exporter, sharedConfig)
+}
+
+// Post a Flux event to /v6/events with headers given as name, value pairs,
+// returning the status code and what each exporter did with the event.
+func postEvent(t *testing.T, apiConfig APIConfig, event fluxevent.Event, header ...string) (int, []ExporterResult) {
+	data, err := json.Marshal(event)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "http://127.0.0.1:3030/v6/events", bytes.NewBuffer(data))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+
+	recorder := httptest.NewRecorder()
+	apiConfig.Server.ServeHTTP(recorder, req)
+
+	response := EventResponse{}
+	if recorder.Header().Get("Content-Type") == "application/json" {
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+	}
+
+	return recorder.Code, response.Results
+}
@@ -0,0 +1,62 @@
+package apis
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+)
+
+type clusterContextKey struct{}
+
+// Wrap a handler so that it only serves requests that config.Auth
+// authenticates. Requests made with a cluster's token are attributed to that
+// cluster, see clusterName.
+func authenticated(config APIConfig, endpoint string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cluster, ok, reason := config.Auth.Authenticate(r)
+		if !ok {
+			logging.Warn("Rejected unauthenticated request", "endpoint", endpoint, "remote_addr", r.RemoteAddr, "reason", reason)
+			config.apiMetrics.unauthorized(endpoint, reason)
+
+			w.Header().Set("WWW-Authenticate", `Bearer realm="fluxcloud"`)
+			http.Error(w, fmt.Sprintf("Unauthorized: %s token", reason), 401)
+			return
+		}
+
+		if cluster != "" {
+			r = r.WithContext(withCluster(r.Context(), cluster))
+		}
+
+		handler(w, r)
+	}
+}
+
+// Wrap a handler so that it only serves clients that presented a certificate
+// signed by the TLS client CA bundle, if one is configured. Probes and metrics
+// are not wrapped so that they can be reached without a certificate.
+func verified(config APIConfig, endpoint string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !config.TLS.Verified(r) {
+			logging.Warn("Rejected request without a verified client certificate", "endpoint", endpoint, "remote_addr", r.RemoteAddr)
+			config.apiMetrics.unauthorized(endpoint, "certificate")
+
+			http.Error(w, "Unauthorized: client certificate required", 401)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// Attach the name of the cluster that an event came from to a context.
+func withCluster(ctx context.Context, cluster string) context.Context {
+	return context.WithValue(ctx, clusterContextKey{}, cluster)
+}
+
+// Return the name of the cluster attached to a context, if any.
+func contextCluster(ctx context.Context) string {
+	cluster, _ := ctx.Value(clusterContextKey{}).(string)
+	return cluster
+}
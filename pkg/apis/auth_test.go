@@ -0,0 +1,218 @@
+package apis
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/justinbarrick/fluxcloud/pkg/auth"
+	"github.com/justinbarrick/fluxcloud/pkg/certs"
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/justinbarrick/fluxcloud/pkg/watchdog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticatedEvents(t *testing.T) {
+	fakeExporter := &exporters.FakeExporter{}
+	apiConfig := newTestAPI(t, nil, fakeExporter)
+	apiConfig.Auth = auth.NewAuthenticator("shared-token", map[string]string{"production": "production-token"})
+
+	var err error
+	apiConfig.Watchdog, err = watchdog.NewWatchdog(time.Hour, 0, "", time.Now())
+	require.NoError(t, err)
+	HandleV6(apiConfig)
+	require.NoError(t, HandleMetrics(apiConfig))
+
+	post := func(authorization string) int {
+		status, _ := postEvent(t, apiConfig, test_utils.NewFluxSyncEvent(), "Authorization", authorization)
+		return status
+	}
+
+	assert.Equal(t, 401, post(""))
+	assert.Equal(t, 401, post("Scope-Probe token=wrong"))
+	assert.Len(t, fakeExporter.Sent, 0)
+
+	assert.Equal(t, 200, post("Scope-Probe token=shared-token"))
+	assert.Equal(t, 200, post("Scope-Probe token=production-token"))
+	assert.Len(t, fakeExporter.Sent, 2)
+
+	clusters := []string{}
+	for _, cluster := range apiConfig.Watchdog.Clusters() {
+		clusters = append(clusters, cluster.Name)
+	}
+	assert.ElementsMatch(t, []string{"default", "production"}, clusters)
+
+	sentClusters := []string{}
+	for _, message := range fakeExporter.Sent {
+		sentClusters = append(sentClusters, message.Cluster)
+	}
+	assert.ElementsMatch(t, []string{"default", "production"}, sentClusters)
+
+	req := httptest.NewRequest("GET", "http://127.0.0.1:3030/metrics", nil)
+	response := httptest.NewRecorder()
+	apiConfig.Server.ServeHTTP(response, req)
+
+	metrics, _ := ioutil.ReadAll(response.Body)
+	assert.Contains(t, string(metrics), `fluxcloud_unauthorized_requests_total{endpoint="/v6/events",reason="missing"} 1`)
+	assert.Contains(t, string(metrics), `fluxcloud_unauthorized_requests_total{endpoint="/v6/events",reason="invalid"} 1`)
+}
+
+func TestAuthenticatedWebsocket(t *testing.T) {
+	apiConfig := NewAPIConfig(nil, nil, config.NewFakeConfig())
+	apiConfig.Auth = auth.NewAuthenticator("", map[string]string{"production": "production-token"})
+	HandleWebsocket(apiConfig)
+
+	apiServer := httptest.NewServer(apiConfig.Server)
+	defer apiServer.Close()
+
+	url := strings.Replace(apiServer.URL, "http", "ws", 1) + "/v10/daemon"
+
+	_, response, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NotNil(t, err)
+	require.NotNil(t, response)
+	assert.Equal(t, 401, response.StatusCode)
+
+	header := http.Header{}
+	header.Set("Authorization", "Scope-Probe token=production-token")
+
+	c, _, err := websocket.DefaultDialer.Dial(url, header)
+	require.NoError(t, err)
+	defer c.Close()
+
+	var sessions []Session
+	for i := 0; i < 50 && len(sessions) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		sessions = apiConfig.Sessions.List()
+	}
+
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "production", sessions[0].Cluster)
+}
+
+func TestAuthenticatedAdminEndpoints(t *testing.T) {
+	apiConfig := NewAPIConfig(nil, nil, config.NewFakeConfig())
+	apiConfig.Auth = auth.NewAuthenticator("shared-token", nil)
+	HandleWebsocket(apiConfig)
+	HandleDeadLetters(apiConfig)
+	require.NoError(t, HandleDORA(apiConfig))
+
+	request := func(method, path, authorization string) int {
+		req := httptest.NewRequest(method, "http://127.0.0.1:3030"+path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		response := httptest.NewRecorder()
+		apiConfig.Server.ServeHTTP(response, req)
+		return response.Code
+	}
+
+	for _, endpoint := range []struct{ method, path string }{
+		{"GET", "/v6/sessions"},
+		{"GET", "/v6/dora"},
+		{"GET", "/v6/dead-letters"},
+		{"DELETE", "/v6/dead-letters"},
+		{"POST", "/v6/dead-letters/abc/replay"},
+	} {
+		assert.Equal(t, 401, request(endpoint.method, endpoint.path, ""), endpoint.path)
+	}
+
+	assert.Equal(t, 200, request("GET", "/v6/sessions", "Bearer shared-token"))
+}
+
+// Write a self-signed certificate and its key to dir, returning the
+// certificate and the files.
+func writeTestCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fluxcloud"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return cert, certFile, keyFile
+}
+
+func TestVerifiedClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fluxcloud-certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cert, certFile, keyFile := writeTestCert(t, dir)
+
+	apiConfig := newTestAPI(t, nil, &exporters.FakeExporter{})
+	apiConfig.TLS, err = certs.NewReloader(certFile, keyFile, certFile)
+	require.NoError(t, err)
+	HandleV6(apiConfig)
+	HandleWebsocket(apiConfig)
+	require.NoError(t, HandleMetrics(apiConfig))
+	HandleHealth(apiConfig)
+	HandleDeadLetters(apiConfig)
+	require.NoError(t, HandleDORA(apiConfig))
+
+	request := func(method, path string, state *tls.ConnectionState) int {
+		data, _ := json.Marshal(test_utils.NewFluxSyncEvent())
+		req := httptest.NewRequest(method, "https://127.0.0.1:3030"+path, bytes.NewBuffer(data))
+		req.TLS = state
+
+		response := httptest.NewRecorder()
+		apiConfig.Server.ServeHTTP(response, req)
+		return response.Code
+	}
+
+	unverified := &tls.ConnectionState{}
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+	// probes and scrapes do not need a client certificate
+	assert.Equal(t, 200, request("GET", "/healthz", unverified))
+	assert.Equal(t, 200, request("GET", "/readyz", unverified))
+	assert.Equal(t, 200, request("GET", "/metrics", unverified))
+
+	assert.Equal(t, 401, request("POST", "/v6/events", unverified))
+	assert.Equal(t, 401, request("GET", "/v10/daemon", unverified))
+	assert.Equal(t, 200, request("POST", "/v6/events", verified))
+
+	// the admin endpoints are only served to verified clients too
+	for _, path := range []string{"/v6/sessions", "/v6/dora", "/v6/dead-letters", "/v6/dead-letters/1"} {
+		assert.Equal(t, 401, request("GET", path, unverified), path)
+	}
+	assert.Equal(t, 200, request("GET", "/v6/sessions", verified))
+}
@@ -0,0 +1,146 @@
+package apis
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/queue"
+)
+
+const deadLettersPath = "/v6/dead-letters"
+
+// The response to replaying a dead letter.
+type ReplayResponse struct {
+	Exporter string `json:"exporter"`
+	Delivery string `json:"delivery"`
+}
+
+// Handle listing, inspecting, replaying and purging dead letters:
+//
+//	GET    /v6/dead-letters                 list all dead letters
+//	DELETE /v6/dead-letters                 purge all dead letters
+//	GET    /v6/dead-letters/<id>            inspect a dead letter
+//	DELETE /v6/dead-letters/<id>            purge a dead letter
+//	POST   /v6/dead-letters/<id>/replay     queue a dead letter again, optionally
+//	                                        to ?exporter=<spec>, such as slack:ops
+func HandleDeadLetters(config APIConfig) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		logging.Debug("Request", "url", r.URL)
+
+		if config.Queue == nil {
+			http.Error(w, "the delivery queue is not enabled", 404)
+			return
+		}
+
+		dead := config.Queue.DeadLetters()
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, deadLettersPath), "/"), "/")
+
+		switch {
+		case parts[0] == "" && r.Method == "GET":
+			writeJSON(w, 200, dead.List())
+		case parts[0] == "" && r.Method == "DELETE":
+			count, err := dead.Purge()
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			writeJSON(w, 200, map[string]int{"purged": count})
+		case len(parts) == 1 && r.Method == "GET":
+			delivery, ok := dead.Get(parts[0])
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			writeJSON(w, 200, delivery)
+		case len(parts) == 1 && r.Method == "DELETE":
+			removed, err := dead.Remove(parts[0])
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			if !removed {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(204)
+		case len(parts) == 2 && parts[1] == "replay" && r.Method == "POST":
+			replay(config, w, r, dead, parts[0])
+		default:
+			http.Error(w, "not found", 404)
+		}
+	}
+
+	config.Server.HandleFunc(deadLettersPath, verified(config, deadLettersPath, authenticated(config, deadLettersPath, handler)))
+	config.Server.HandleFunc(deadLettersPath+"/", verified(config, deadLettersPath, authenticated(config, deadLettersPath, handler)))
+}
+
+// Queue a dead letter again. When it is replayed to a different exporter a
+// plain event is formatted and routed again for that exporter. Digests,
+// reports, alerts and deployments cannot be rendered again from their event,
+// so they are sent as they were rendered but to that exporter's configured
+// destination since the routed destinations belong to the original exporter.
+func replay(config APIConfig, w http.ResponseWriter, r *http.Request, dead *queue.DeadLetters, id string) {
+	delivery, ok := dead.Get(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	exporterName := r.URL.Query().Get("exporter")
+	if exporterName == "" {
+		exporterName = delivery.Exporter
+	}
+
+	exporter := config.exporterByName(exporterName)
+	if exporter == nil {
+		http.Error(w, fmt.Sprintf("exporter %s is not configured", exporterName), 400)
+		return
+	}
+
+	// deliveries are queued under the exporter's name
+	exporterName = exporter.Name()
+
+	message := delivery.Message
+	if exporterName != delivery.Exporter {
+		message = replayMessage(config, delivery.Message, exporter)
+		if message.Title == "" {
+			http.Error(w, fmt.Sprintf("exporter %s does not send this event", exporterName), 400)
+			return
+		}
+	}
+
+	queued, err := config.Queue.Enqueue(exporterName, message)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	if _, err := dead.Remove(id); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	logging.Info("Replaying dead letter", "delivery", id, "exporter", exporterName, "queued", queued.ID)
+	writeJSON(w, 200, ReplayResponse{
+		Exporter: exporterName,
+		Delivery: queued.ID,
+	})
+}
+
+// Prepare a dead letter's message for a different exporter.
+func replayMessage(config APIConfig, message msg.Message, exporter exporters.Exporter) msg.Message {
+	if !message.IsEvent() {
+		message.Destinations = nil
+		return message
+	}
+
+	formatted := config.FormatEvent(message.Event, exporter)
+	if formatted.Cluster == "" {
+		formatted.Cluster = message.Cluster
+	}
+	return formatted
+}
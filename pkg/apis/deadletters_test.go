@@ -0,0 +1,229 @@
+package apis
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/formatters"
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/queue"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDeadLettersTest(t *testing.T, extra ...exporters.Exporter) (APIConfig, string) {
+	dir, err := ioutil.TempDir("", "fluxcloud-queue")
+	require.NoError(t, err)
+
+	config := config.NewFakeConfig()
+	config.Set("github_url", "https://github.com")
+
+	formatter, _ := formatters.NewDefaultFormatter(config)
+
+	apiConfig := NewAPIConfig(formatter, append([]exporters.Exporter{
+		&exporters.FakeExporter{ExporterName: "Slack"},
+		&exporters.FakeExporter{ExporterName: "Matrix"},
+	}, extra...), config)
+
+	apiConfig.Queue, err = queue.NewQueue(dir, queue.Options{})
+	require.NoError(t, err)
+
+	HandleDeadLetters(apiConfig)
+
+	event := test_utils.NewFluxSyncEvent()
+	message := formatter.FormatEvent(event, apiConfig.Exporter[0])
+	require.NoError(t, apiConfig.Queue.DeadLetters().Add(queue.Delivery{
+		ID:        "1",
+		Exporter:  "Slack",
+		Message:   message,
+		LastError: "slack is down",
+	}, time.Now()))
+
+	return apiConfig, dir
+}
+
+func request(apiConfig APIConfig, method string, url string) *http.Response {
+	req, _ := http.NewRequest(method, "http://127.0.0.1:3030"+url, nil)
+	recorder := httptest.NewRecorder()
+	apiConfig.Server.ServeHTTP(recorder, req)
+	return recorder.Result()
+}
+
+func TestDeadLettersList(t *testing.T) {
+	apiConfig, dir := newDeadLettersTest(t)
+	defer os.RemoveAll(dir)
+
+	resp := request(apiConfig, "GET", "/v6/dead-letters")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	deliveries := []queue.Delivery{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&deliveries))
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, "Slack", deliveries[0].Exporter)
+	assert.Equal(t, "slack is down", deliveries[0].LastError)
+	assert.Equal(t, "Applied flux changes to cluster", deliveries[0].Message.Title)
+	assert.Equal(t, "sync", deliveries[0].Message.Event.Type)
+
+	resp = request(apiConfig, "GET", "/v6/dead-letters/1")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	resp = request(apiConfig, "GET", "/v6/dead-letters/2")
+	assert.Equal(t, 404, resp.StatusCode)
+}
+
+func TestDeadLettersReplay(t *testing.T) {
+	apiConfig, dir := newDeadLettersTest(t)
+	defer os.RemoveAll(dir)
+
+	resp := request(apiConfig, "POST", "/v6/dead-letters/1/replay")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	replayed := ReplayResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&replayed))
+	assert.Equal(t, "Slack", replayed.Exporter)
+
+	delivery, ok := apiConfig.Queue.Get(replayed.Delivery)
+	assert.True(t, ok)
+	assert.Equal(t, "Slack", delivery.Exporter)
+	assert.Equal(t, 0, apiConfig.Queue.DeadLetters().Len())
+
+	resp = request(apiConfig, "POST", "/v6/dead-letters/1/replay")
+	assert.Equal(t, 404, resp.StatusCode)
+}
+
+func TestDeadLettersReplayOtherExporter(t *testing.T) {
+	apiConfig, dir := newDeadLettersTest(t)
+	defer os.RemoveAll(dir)
+
+	resp := request(apiConfig, "POST", "/v6/dead-letters/1/replay?exporter=IRC")
+	assert.Equal(t, 400, resp.StatusCode)
+	assert.Equal(t, 1, apiConfig.Queue.DeadLetters().Len())
+
+	resp = request(apiConfig, "POST", "/v6/dead-letters/1/replay?exporter=Matrix")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	replayed := ReplayResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&replayed))
+	assert.Equal(t, "Matrix", replayed.Exporter)
+
+	delivery, ok := apiConfig.Queue.Get(replayed.Delivery)
+	assert.True(t, ok)
+	assert.Equal(t, "Matrix", delivery.Exporter)
+	assert.Equal(t, "Applied flux changes to cluster", delivery.Message.Title)
+}
+
+func TestDeadLettersReplayBySpec(t *testing.T) {
+	exporterConfig := config.NewFakeConfig()
+	exporterConfig.Set("ops_slack_url", "https://ops/")
+	exporterConfig.Set("ops_slack_channel", "#ops")
+	ops, err := exporters.New("slack:ops", exporterConfig)
+	require.NoError(t, err)
+
+	apiConfig, dir := newDeadLettersTest(t, ops)
+	defer os.RemoveAll(dir)
+
+	resp := request(apiConfig, "POST", "/v6/dead-letters/1/replay?exporter=slack:ops")
+	require.Equal(t, 200, resp.StatusCode)
+
+	replayed := ReplayResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&replayed))
+	assert.Equal(t, "Slack (ops)", replayed.Exporter)
+
+	delivery, ok := apiConfig.Queue.Get(replayed.Delivery)
+	require.True(t, ok)
+	assert.Equal(t, "Slack (ops)", delivery.Exporter)
+}
+
+func TestDeadLettersReplayFormatsEventAgain(t *testing.T) {
+	apiConfig, dir := newDeadLettersTest(t)
+	defer os.RemoveAll(dir)
+
+	matrixConfig := config.NewFakeConfig()
+	matrixConfig.Set("github_url", "https://github.com")
+	matrixConfig.Set("title_template", "Matrix: {{ .VCSLink }}")
+	formatter, err := formatters.NewDefaultFormatter(matrixConfig)
+	require.NoError(t, err)
+	apiConfig.Formatters["Matrix"] = formatter
+
+	resp := request(apiConfig, "POST", "/v6/dead-letters/1/replay?exporter=Matrix")
+	require.Equal(t, 200, resp.StatusCode)
+
+	replayed := ReplayResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&replayed))
+
+	delivery, ok := apiConfig.Queue.Get(replayed.Delivery)
+	require.True(t, ok)
+	assert.Equal(t, "Matrix: https://github.com", delivery.Message.Title)
+	assert.Equal(t, "sync", delivery.Message.Type)
+}
+
+func TestDeadLettersReplayKeepsRenderedMessage(t *testing.T) {
+	apiConfig, dir := newDeadLettersTest(t)
+	defer os.RemoveAll(dir)
+
+	// digests cannot be formatted again from their event
+	digest := msg.Message{
+		Title:        "Applied flux changes to cluster (2 events)",
+		Body:         "digest body",
+		Type:         "digest",
+		Cluster:      "production",
+		Destinations: []string{"#deploys"},
+		Event:        test_utils.NewFluxSyncEvent(),
+	}
+	require.NoError(t, apiConfig.Queue.DeadLetters().Add(queue.Delivery{ID: "2", Exporter: "Slack", Message: digest}, time.Now()))
+
+	resp := request(apiConfig, "POST", "/v6/dead-letters/2/replay?exporter=Matrix")
+	require.Equal(t, 200, resp.StatusCode)
+
+	replayed := ReplayResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&replayed))
+
+	delivery, ok := apiConfig.Queue.Get(replayed.Delivery)
+	require.True(t, ok)
+	assert.Equal(t, digest.Title, delivery.Message.Title)
+	assert.Equal(t, digest.Body, delivery.Message.Body)
+	assert.Equal(t, "digest", delivery.Message.Type)
+	assert.Equal(t, "production", delivery.Message.Cluster)
+
+	// Slack channels mean nothing to Matrix
+	assert.Nil(t, delivery.Message.Destinations)
+}
+
+func TestDeadLettersPurge(t *testing.T) {
+	apiConfig, dir := newDeadLettersTest(t)
+	defer os.RemoveAll(dir)
+
+	dead := apiConfig.Queue.DeadLetters()
+	require.NoError(t, dead.Add(queue.Delivery{ID: "2", Exporter: "Slack", Message: dead.List()[0].Message}, time.Now()))
+	require.NoError(t, dead.Add(queue.Delivery{ID: "3", Exporter: "Slack", Message: dead.List()[0].Message}, time.Now()))
+
+	resp := request(apiConfig, "DELETE", "/v6/dead-letters/1")
+	assert.Equal(t, 204, resp.StatusCode)
+
+	resp = request(apiConfig, "DELETE", "/v6/dead-letters/1")
+	assert.Equal(t, 404, resp.StatusCode)
+
+	resp = request(apiConfig, "DELETE", "/v6/dead-letters")
+	assert.Equal(t, 200, resp.StatusCode)
+
+	purged := map[string]int{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&purged))
+	assert.Equal(t, 2, purged["purged"])
+	assert.Equal(t, 0, dead.Len())
+}
+
+func TestDeadLettersQueueDisabled(t *testing.T) {
+	apiConfig := NewAPIConfig(nil, nil, config.NewFakeConfig())
+	HandleDeadLetters(apiConfig)
+
+	resp := request(apiConfig, "GET", "/v6/dead-letters")
+	assert.Equal(t, 404, resp.StatusCode)
+}
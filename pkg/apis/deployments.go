@@ -0,0 +1,86 @@
+package apis
+
+import (
+	"context"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/deployments"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/filter"
+	"github.com/justinbarrick/fluxcloud/pkg/formatters"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+// The order in which results are kept when an event is announced several
+// times, the most important last.
+var resultPriority = map[string]int{
+	ExporterSkipped:   0,
+	ExporterDuplicate: 1,
+	ExporterBatched:   2,
+	ExporterQueued:    3,
+	ExporterSent:      4,
+	ExporterFailed:    5,
+}
+
+// Return the deployments that an event from a cluster moved forward.
+func trackDeployments(config APIConfig, cluster string, event fluxevent.Event) []deployments.Deployment {
+	if config.Deployments == nil {
+		return nil
+	}
+
+	tracked, err := config.Deployments.Track(cluster, event, time.Now())
+	if err != nil {
+		logging.Error("Could not save deployment state", "err", err)
+	}
+
+	return tracked
+}
+
+// Announce the deployments that an event moved forward instead of the event,
+// each filtered and routed by its resources. The results say what each
+// exporter did with the event.
+func announceDeployments(ctx context.Context, config APIConfig, tracked []deployments.Deployment) []ExporterResult {
+	var results []ExporterResult
+
+	for _, deployment := range tracked {
+		deployment := deployment
+		format := func(event fluxevent.Event, exporter exporters.Exporter) msg.Message {
+			return config.formatRouted(event, exporter, (*filter.Filter).Allows, func(formatter formatters.Formatter) msg.Message {
+				return formatter.FormatDeployment(deployment, exporter)
+			})
+		}
+
+		results = mergeResults(results, announce(ctx, config, deployment.Event(), format))
+	}
+
+	return results
+}
+
+// Returns true if every exporter skipped a message.
+func allSkipped(results []ExporterResult) bool {
+	for _, result := range results {
+		if result.Status != ExporterSkipped {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Combine the results of announcing several messages through the same
+// exporters, keeping the most important result for each exporter.
+func mergeResults(results []ExporterResult, more []ExporterResult) []ExporterResult {
+	if results == nil {
+		return more
+	}
+
+	for i, result := range more {
+		if resultPriority[result.Status] > resultPriority[results[i].Status] {
+			results[i] = result
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,132 @@
+package apis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/alerts"
+	"github.com/justinbarrick/fluxcloud/pkg/deployments"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+func TestHandleV6Deployments(t *testing.T) {
+	fakeExporter := &exporters.FakeExporter{}
+	apiConfig := newTestAPI(t, nil, fakeExporter)
+
+	var err error
+	apiConfig.Deployments, err = deployments.NewTracker(time.Hour, "")
+	require.NoError(t, err)
+	HandleV6(apiConfig)
+
+	post := func(event fluxevent.Event) string {
+		status, results := postEvent(t, apiConfig, event)
+		assert.Equal(t, 200, status)
+		return results[0].Status
+	}
+
+	commit := test_utils.NewFluxCommitEvent()
+	revision := commit.Metadata.(*fluxevent.CommitEventMetadata).Revision
+
+	sync := test_utils.NewFluxSyncEvent()
+	sync.Metadata.(*fluxevent.SyncEventMetadata).Commits[0].Revision = revision
+
+	release := test_utils.NewFluxAutoReleaseEvent()
+	release.Metadata.(*fluxevent.AutoReleaseEventMetadata).Revision = revision
+
+	assert.Equal(t, ExporterSent, post(commit))
+	assert.Equal(t, ExporterSent, post(sync))
+	assert.Equal(t, ExporterSent, post(release))
+
+	require.Len(t, fakeExporter.Sent, 3)
+	for i, state := range []string{"committed", "synced", "released"} {
+		assert.Equal(t, "deployment", fakeExporter.Sent[i].Type)
+		assert.Equal(t, "deployment-default/"+revision, fakeExporter.Sent[i].NotificationKey)
+		assert.Equal(t, "Deployment of d644e1a: "+state, fakeExporter.Sent[i].Title)
+	}
+
+	// syncs without commits are not deployments and are formatted as usual
+	noop := test_utils.NewFluxSyncEvent()
+	noop.Metadata.(*fluxevent.SyncEventMetadata).Commits = nil
+	assert.Equal(t, ExporterSent, post(noop))
+	require.Len(t, fakeExporter.Sent, 4)
+	assert.Equal(t, "sync", fakeExporter.Sent[3].Type)
+}
+
+func TestHandleV6DeploymentsNotFormatted(t *testing.T) {
+	fakeExporter := &exporters.FakeExporter{}
+	apiConfig := newTestAPI(t, map[string]string{
+		"deployment_title_template": `{{ if eq .State "released" }}Released{{ end }}`,
+	}, fakeExporter)
+
+	var err error
+	apiConfig.Deployments, err = deployments.NewTracker(time.Hour, "")
+	require.NoError(t, err)
+	HandleV6(apiConfig)
+
+	status, results := postEvent(t, apiConfig, test_utils.NewFluxSyncEvent())
+	assert.Equal(t, 200, status)
+	assert.Equal(t, ExporterSent, results[0].Status)
+
+	// the deployment's template skips synced deployments, the event is sent
+	require.Len(t, fakeExporter.Sent, 1)
+	assert.Equal(t, "sync", fakeExporter.Sent[0].Type)
+}
+
+func TestHandleV6DeploymentsWithSyncErrorAlerts(t *testing.T) {
+	fakeExporter := &exporters.FakeExporter{}
+	apiConfig := newTestAPI(t, nil, fakeExporter)
+
+	var err error
+	apiConfig.Deployments, err = deployments.NewTracker(time.Hour, "")
+	require.NoError(t, err)
+	apiConfig.Alerts, err = alerts.NewTracker(1, "")
+	require.NoError(t, err)
+	HandleV6(apiConfig)
+
+	commit := test_utils.NewFluxCommitEvent()
+	revision := commit.Metadata.(*fluxevent.CommitEventMetadata).Revision
+
+	sync := test_utils.NewFluxSyncErrorEvent()
+	sync.Metadata.(*fluxevent.SyncEventMetadata).Commits[0].Revision = revision
+
+	for _, event := range []fluxevent.Event{commit, sync} {
+		status, _ := postEvent(t, apiConfig, event)
+		assert.Equal(t, 200, status)
+	}
+
+	deploymentMessages := []string{}
+	alertMessages := 0
+	for _, message := range fakeExporter.Sent {
+		switch message.Type {
+		case "deployment":
+			deploymentMessages = append(deploymentMessages, message.Title)
+		case "alert":
+			alertMessages++
+		}
+	}
+
+	// the sync's errors are alerted separately but still fail the deployment
+	assert.Equal(t, []string{"Deployment of d644e1a: committed", "Deployment of d644e1a: failed"}, deploymentMessages)
+	assert.Equal(t, 1, alertMessages)
+}
+
+func TestMergeResults(t *testing.T) {
+	results := mergeResults(nil, []ExporterResult{
+		{Exporter: "one", Status: ExporterSent},
+		{Exporter: "two", Status: ExporterSkipped},
+	})
+
+	results = mergeResults(results, []ExporterResult{
+		{Exporter: "one", Status: ExporterFailed, Error: "oops"},
+		{Exporter: "two", Status: ExporterSent},
+	})
+
+	assert.Equal(t, []ExporterResult{
+		{Exporter: "one", Status: ExporterFailed, Error: "oops"},
+		{Exporter: "two", Status: ExporterSent},
+	}, results)
+}
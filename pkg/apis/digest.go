@@ -0,0 +1,115 @@
+package apis
+
+import (
+	"context"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/dedupe"
+	"github.com/justinbarrick/fluxcloud/pkg/digest"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+// Create an aggregator that sends each batch of events as one digest through
+// its exporter, queueing it if the delivery queue is enabled, and saves the
+// batches to file if it is set. It must be created after the rest of config,
+// since batches loaded from file may be sent at once.
+func NewAggregator(config APIConfig, window time.Duration, quiet time.Duration, file string) (*digest.Aggregator, error) {
+	return digest.NewAggregator(window, quiet, file, func(key digest.Key, ids []string, events []fluxevent.Event) {
+		sendDigest(config, key, ids, events)
+	})
+}
+
+// Add an event from a cluster to the digest of every exporter and destination
+// it is routed to. The event is only marked as sent once its digest is.
+func batch(config APIConfig, cluster string, event fluxevent.Event) []ExporterResult {
+	results := []ExporterResult{}
+	key := dedupe.Key(cluster, event)
+
+	for _, exporter := range config.Exporter {
+		result := ExporterResult{
+			Exporter: exporter.Name(),
+		}
+
+		routed, destinations := config.Route(event, exporter)
+		if !routed {
+			result.Status = ExporterSkipped
+			results = append(results, result)
+			continue
+		}
+
+		destinations, ok := config.Dedupe.Filter(key, exporter.Name(), destinations, time.Now())
+		if !ok {
+			result.Status = ExporterDuplicate
+			results = append(results, result)
+			continue
+		}
+
+		if len(destinations) == 0 {
+			destinations = []string{""}
+		}
+
+		result.Status = ExporterDuplicate
+		for _, destination := range destinations {
+			// copies of the event that arrive before its digest is sent
+			if config.Digest.Add(digest.Key{
+				Cluster:     cluster,
+				Exporter:    exporter.Name(),
+				Destination: destination,
+			}, key, event) {
+				result.Status = ExporterBatched
+			}
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// Format a batch of events as a digest and send it, marking the events with
+// the deduplication keys ids as sent once it is sent or queued, or releasing
+// them if it could not be.
+func sendDigest(config APIConfig, key digest.Key, ids []string, events []fluxevent.Event) {
+	sent := deliverDigest(config, key, events)
+
+	for _, id := range ids {
+		if !sent {
+			config.Dedupe.Release(id, key.Exporter, []string{key.Destination})
+		} else if err := config.Dedupe.Mark(id, key.Exporter, []string{key.Destination}, time.Now()); err != nil {
+			logging.Error("Could not save deduplication state", "err", err)
+		}
+	}
+}
+
+// Format a batch of events as a digest and send or queue it, returns false if
+// it was not.
+func deliverDigest(config APIConfig, key digest.Key, events []fluxevent.Event) bool {
+	exporter := config.exporterByName(key.Exporter)
+	if exporter == nil {
+		logging.Warn("Dropping digest for unknown exporter", "exporter", key.Exporter)
+		return false
+	}
+
+	message := config.FormatterFor(exporter).FormatDigest(key.Cluster, events, exporter)
+	if message.Title == "" {
+		return false
+	}
+
+	if key.Destination != "" {
+		message.Destinations = []string{key.Destination}
+	}
+
+	logging.Info("Sending digest", "events", len(events), "cluster", key.Cluster, "exporter", exporter.Name())
+
+	if config.Queue != nil {
+		if _, err := config.Queue.Enqueue(exporter.Name(), message); err != nil {
+			logging.Error("Could not queue digest", "exporter", exporter.Name(), "err", err)
+			return false
+		}
+	} else if err := deliver(context.Background(), config, exporter, message); err != nil {
+		return false
+	}
+
+	return true
+}
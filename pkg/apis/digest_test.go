@@ -0,0 +1,109 @@
+package apis
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/dedupe"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/routing"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+func TestHandleV6Digest(t *testing.T) {
+	fakeExporter := &exporters.FakeExporter{}
+	apiConfig := newTestAPI(t, nil, fakeExporter)
+
+	var err error
+	apiConfig.Router, err = routing.NewRouter([]routing.Route{
+		{Exporters: []string{"Fake"}, Destinations: []string{"#one", "#two"}},
+	})
+	require.NoError(t, err)
+	apiConfig.Digest, err = NewAggregator(apiConfig, time.Hour, 0, "")
+	require.NoError(t, err)
+	HandleV6(apiConfig)
+
+	for _, event := range []fluxevent.Event{test_utils.NewFluxSyncEvent(), test_utils.NewFluxSyncErrorEvent()} {
+		status, results := postEvent(t, apiConfig, event)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, []ExporterResult{{Exporter: "Fake", Status: ExporterBatched}}, results)
+	}
+
+	assert.Len(t, fakeExporter.Sent, 0)
+	assert.Equal(t, 4, apiConfig.Digest.Len())
+
+	apiConfig.Digest.Flush()
+
+	require.Len(t, fakeExporter.Sent, 2)
+	destinations := []string{}
+	for _, message := range fakeExporter.Sent {
+		assert.Equal(t, "Applied flux changes to cluster default (2 events)", message.Title)
+		assert.Contains(t, message.Body, "create invalid resource")
+		destinations = append(destinations, message.Destinations...)
+	}
+	assert.ElementsMatch(t, []string{"#one", "#two"}, destinations)
+}
+
+func TestHandleV6DigestDedupe(t *testing.T) {
+	fakeExporter := &exporters.FakeExporter{SendError: errors.New("boom")}
+	apiConfig := newTestAPI(t, nil, fakeExporter)
+
+	var err error
+	apiConfig.Dedupe, err = dedupe.NewDeduplicator(time.Minute, "")
+	require.NoError(t, err)
+	apiConfig.Digest, err = NewAggregator(apiConfig, time.Hour, 0, "")
+	require.NoError(t, err)
+	HandleV6(apiConfig)
+
+	post := func() string {
+		status, results := postEvent(t, apiConfig, test_utils.NewFluxSyncEvent())
+		assert.Equal(t, 200, status)
+		require.Len(t, results, 1)
+		return results[0].Status
+	}
+
+	// copies that arrive while the event waits for its digest are dropped
+	assert.Equal(t, ExporterBatched, post())
+	assert.Equal(t, ExporterDuplicate, post())
+	assert.Equal(t, 1, apiConfig.Digest.Len())
+
+	// the digest was not sent, so the event is not marked as sent
+	apiConfig.Digest.Flush()
+	assert.Len(t, fakeExporter.Sent, 0)
+	assert.Equal(t, ExporterBatched, post())
+
+	fakeExporter.SendError = nil
+	apiConfig.Digest.Flush()
+	require.Len(t, fakeExporter.Sent, 1)
+	assert.Equal(t, ExporterDuplicate, post())
+}
+
+func TestDigestPerCluster(t *testing.T) {
+	fakeExporter := &exporters.FakeExporter{}
+	apiConfig := newTestAPI(t, nil, fakeExporter)
+
+	var err error
+	apiConfig.Digest, err = NewAggregator(apiConfig, time.Hour, 0, "")
+	require.NoError(t, err)
+
+	batch(apiConfig, "production", test_utils.NewFluxSyncEvent())
+	batch(apiConfig, "staging", test_utils.NewFluxSyncEvent())
+	assert.Equal(t, 2, apiConfig.Digest.Len())
+
+	apiConfig.Digest.Flush()
+
+	require.Len(t, fakeExporter.Sent, 2)
+	titles := []string{}
+	for _, message := range fakeExporter.Sent {
+		assert.Contains(t, message.Title, message.Cluster)
+		titles = append(titles, message.Title)
+	}
+	assert.ElementsMatch(t, []string{
+		"Applied flux changes to cluster production (1 events)",
+		"Applied flux changes to cluster staging (1 events)",
+	}, titles)
+}
@@ -0,0 +1,81 @@
+package apis
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/dedupe"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+// The period reported by /v6/dora if the tracker keeps deployments forever.
+const defaultDORAWindow = 30 * 24 * time.Hour
+
+// The name that events recorded for the DORA metrics are marked with in the
+// deduplicator, alongside the exporters that sent them.
+const doraRecorder = "DORA metrics"
+
+// Serve the DORA metrics of config.DORA as JSON on /v6/dora and register them
+// with config.Metrics. The window query parameter sets the period to report,
+// such as 168h, and defaults to the tracker's retention.
+func HandleDORA(config APIConfig) error {
+	if config.DORA != nil {
+		if err := config.Metrics.Register(config.DORA); err != nil {
+			return err
+		}
+	}
+
+	config.Server.HandleFunc("/v6/dora", verified(config, "/v6/dora", authenticated(config, "/v6/dora", func(w http.ResponseWriter, r *http.Request) {
+		logging.Debug("Request", "url", r.URL)
+
+		if config.DORA == nil {
+			http.Error(w, "DORA metrics are not enabled", 404)
+			return
+		}
+
+		window := config.DORA.Retention()
+		if window <= 0 {
+			window = defaultDORAWindow
+		}
+
+		if value := r.URL.Query().Get("window"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 {
+				http.Error(w, fmt.Sprintf("invalid window: %s", value), 400)
+				return
+			}
+			window = parsed
+		}
+
+		end := time.Now()
+		writeJSON(w, 200, config.DORA.Report(end.Add(-window), end))
+	})))
+
+	return nil
+}
+
+// Record an event from a cluster for the DORA metrics. Copies of an event
+// that Flux sends again, such as after an exporter failed, are only recorded
+// once within the deduplication window.
+func recordDORA(config APIConfig, cluster string, event fluxevent.Event) {
+	if config.DORA == nil {
+		return
+	}
+
+	now := time.Now()
+	key := dedupe.Key(cluster, event)
+	if _, ok := config.Dedupe.Filter(key, doraRecorder, nil, now); !ok {
+		logging.Info("DORA metrics already recorded event", "key", key)
+		return
+	}
+
+	if err := config.DORA.Record(cluster, event, now); err != nil {
+		logging.Error("Could not save DORA state", "err", err)
+	}
+
+	if err := config.Dedupe.Mark(key, doraRecorder, nil, now); err != nil {
+		logging.Error("Could not save deduplication state", "err", err)
+	}
+}
@@ -0,0 +1,125 @@
+package apis
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/dedupe"
+	"github.com/justinbarrick/fluxcloud/pkg/dora"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/formatters"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+func TestHandleDORA(t *testing.T) {
+	sharedConfig := config.NewFakeConfig()
+	sharedConfig.Set("github_url", "https://github.com")
+
+	formatter, _ := formatters.NewDefaultFormatter(sharedConfig)
+
+	tracker, err := dora.NewTracker(24*time.Hour, "")
+	require.NoError(t, err)
+
+	apiConfig := NewAPIConfig(formatter, []exporters.Exporter{&exporters.FakeExporter{}}, sharedConfig)
+	apiConfig.DORA = tracker
+	HandleV6(apiConfig)
+	require.NoError(t, HandleDORA(apiConfig))
+	require.NoError(t, HandleMetrics(apiConfig))
+
+	serve := func(method, url string, event *fluxevent.Event) *httptest.ResponseRecorder {
+		var body []byte
+		if event != nil {
+			body, _ = json.Marshal(event)
+		}
+
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		recorder := httptest.NewRecorder()
+		apiConfig.Server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	now := time.Now().UTC()
+
+	commit := test_utils.NewFluxCommitEvent()
+	commit.EndedAt = now.Add(-10 * time.Minute)
+
+	sync := test_utils.NewFluxSyncEvent()
+	sync.EndedAt = now
+	sync.Metadata.(*fluxevent.SyncEventMetadata).Commits[0].Revision = commit.Metadata.(*fluxevent.CommitEventMetadata).Revision
+
+	assert.Equal(t, 200, serve("POST", "http://127.0.0.1:3030/v6/events", &commit).Code)
+	assert.Equal(t, 200, serve("POST", "http://127.0.0.1:3030/v6/events", &sync).Code)
+
+	response := serve("GET", "http://127.0.0.1:3030/v6/dora?window=1h", nil)
+	assert.Equal(t, 200, response.Code)
+
+	report := dora.Report{}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&report))
+	require.Len(t, report.Namespaces, 1)
+	assert.Equal(t, 1, report.Namespaces[0].Deployments)
+	require.NotNil(t, report.Namespaces[0].MedianLeadTimeSeconds)
+	assert.Equal(t, 600.0, *report.Namespaces[0].MedianLeadTimeSeconds)
+	assert.Equal(t, time.Hour, report.End.Sub(report.Start))
+
+	assert.Equal(t, 400, serve("GET", "http://127.0.0.1:3030/v6/dora?window=soon", nil).Code)
+
+	metrics, _ := ioutil.ReadAll(serve("GET", "http://127.0.0.1:3030/metrics", nil).Body)
+	assert.Contains(t, string(metrics), `fluxcloud_dora_deployments_total{cluster="default",namespace="default"} 1`)
+	assert.Contains(t, string(metrics), `fluxcloud_dora_lead_time_seconds_count{cluster="default",namespace="default"} 1`)
+}
+
+func TestHandleDORADedupe(t *testing.T) {
+	sharedConfig := config.NewFakeConfig()
+	sharedConfig.Set("github_url", "https://github.com")
+
+	formatter, _ := formatters.NewDefaultFormatter(sharedConfig)
+
+	tracker, err := dora.NewTracker(24*time.Hour, "")
+	require.NoError(t, err)
+
+	deduplicator, err := dedupe.NewDeduplicator(time.Minute, "")
+	require.NoError(t, err)
+
+	fakeExporter := &exporters.FakeExporter{SendError: errors.New("boom")}
+	apiConfig := NewAPIConfig(formatter, []exporters.Exporter{fakeExporter}, sharedConfig)
+	apiConfig.DORA = tracker
+	apiConfig.Dedupe = deduplicator
+	HandleV6(apiConfig)
+
+	// Flux sends the event again after the exporter failed, it is one
+	// deployment
+	sync := test_utils.NewFluxSyncEvent()
+	sync.EndedAt = time.Now()
+	for i := 0; i < 2; i++ {
+		data, _ := json.Marshal(sync)
+		req, _ := http.NewRequest("POST", "http://127.0.0.1:3030/v6/events", bytes.NewBuffer(data))
+		recorder := httptest.NewRecorder()
+		apiConfig.Server.ServeHTTP(recorder, req)
+		assert.Equal(t, 500, recorder.Code)
+	}
+
+	now := time.Now()
+	report := tracker.Report(now.Add(-time.Hour), now)
+	require.Len(t, report.Namespaces, 1)
+	assert.Equal(t, 1, report.Namespaces[0].Deployments)
+}
+
+func TestHandleDORADisabled(t *testing.T) {
+	apiConfig := NewAPIConfig(nil, nil, config.NewFakeConfig())
+	require.NoError(t, HandleDORA(apiConfig))
+
+	req, _ := http.NewRequest("GET", "http://127.0.0.1:3030/v6/dora", nil)
+	recorder := httptest.NewRecorder()
+	apiConfig.Server.ServeHTTP(recorder, req)
+	assert.Equal(t, 404, recorder.Code)
+}
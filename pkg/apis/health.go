@@ -0,0 +1,235 @@
+package apis
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+)
+
+// The result of a check or of sending a message.
+type CheckResult struct {
+	Ok    bool      `json:"ok"`
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// The health of an exporter: the result of its startup check, if it was
+// checked, and of the last message it sent.
+type ExporterHealth struct {
+	Name         string       `json:"name"`
+	StartupCheck *CheckResult `json:"startupCheck,omitempty"`
+	LastSend     *CheckResult `json:"lastSend,omitempty"`
+}
+
+// Return whether an exporter passed its startup check, or sent a message
+// since it failed it.
+func (e ExporterHealth) ready() bool {
+	if e.StartupCheck == nil || e.StartupCheck.Ok {
+		return true
+	}
+
+	return e.LastSend != nil && e.LastSend.Ok && e.LastSend.Time.After(e.StartupCheck.Time)
+}
+
+// The health of fluxcloud, served on /healthz and /readyz.
+type Health struct {
+	// Whether fluxcloud can accept events: the queue can be written and no
+	// exporter failed its startup check without sending a message since.
+	Ready bool `json:"ready"`
+
+	Listener  CheckResult      `json:"listener"`
+	Queue     *CheckResult     `json:"queue,omitempty"`
+	Exporters []ExporterHealth `json:"exporters"`
+}
+
+// The results of the startup checks and of the last message sent by each
+// exporter, keyed by exporter name. A nil healthState records nothing.
+type healthState struct {
+	lock          sync.Mutex
+	startupChecks map[string]CheckResult
+	lastSends     map[string]CheckResult
+}
+
+func newHealthState() *healthState {
+	return &healthState{
+		startupChecks: map[string]CheckResult{},
+		lastSends:     map[string]CheckResult{},
+	}
+}
+
+func newCheckResult(err error, now time.Time) CheckResult {
+	result := CheckResult{Ok: err == nil, Time: now}
+	if err != nil {
+		result.Error = logging.Redact(err.Error())
+	}
+
+	return result
+}
+
+// Record the result of an exporter sending a message.
+func (h *healthState) sent(exporter string, err error, now time.Time) {
+	if h == nil {
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.lastSends[exporter] = newCheckResult(err, now)
+}
+
+// Record the result of an exporter's startup check.
+func (h *healthState) checked(exporter string, err error, now time.Time) {
+	if h == nil {
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.startupChecks[exporter] = newCheckResult(err, now)
+}
+
+// Return whether an exporter failed its last startup check.
+func (h *healthState) failedCheck(exporter string) bool {
+	if h == nil {
+		return false
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	result, ok := h.startupChecks[exporter]
+	return ok && !result.Ok
+}
+
+// Return the results recorded for an exporter.
+func (h *healthState) exporter(name string) ExporterHealth {
+	health := ExporterHealth{Name: name}
+	if h == nil {
+		return health
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if result, ok := h.startupChecks[name]; ok {
+		health.StartupCheck = &result
+	}
+
+	if result, ok := h.lastSends[name]; ok {
+		health.LastSend = &result
+	}
+
+	return health
+}
+
+// Validate the configuration of each exporter against its upstream, such as
+// whether its URL is valid or a Matrix room can be reached. The results are
+// reported by /readyz, and an error is returned if any exporter failed.
+func CheckExporters(config APIConfig) error {
+	failed := 0
+
+	for _, exporter := range config.Exporter {
+		if err := checkExporter(config, exporter); err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d exporters failed their startup check", failed)
+	}
+
+	return nil
+}
+
+// Check the exporters that failed their startup check again every interval,
+// until stop is closed, so that an upstream that was down when fluxcloud
+// started does not keep it unready.
+func RecheckExporters(config APIConfig, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		for _, exporter := range config.Exporter {
+			if !config.health.failedCheck(exporter.Name()) {
+				continue
+			}
+
+			if checkExporter(config, exporter) == nil {
+				logging.Info("Exporter passed its startup check", "exporter", exporter.Name())
+			}
+		}
+	}
+}
+
+// Validate the configuration of an exporter and record the result.
+func checkExporter(config APIConfig, exporter exporters.Exporter) error {
+	ctx, cancel := context.WithTimeout(withExporter(context.Background(), exporter.Name()), exporters.Timeout(exporter))
+	err := exporters.Check(ctx, config.Client, exporter)
+	cancel()
+
+	config.health.checked(exporter.Name(), err, time.Now())
+	if err != nil {
+		logging.Error("Exporter failed its startup check", "exporter", exporter.Name(), "err", err)
+	}
+
+	return err
+}
+
+// Check the health of fluxcloud at now.
+func CheckHealth(config APIConfig, now time.Time) Health {
+	health := Health{
+		Ready:     true,
+		Listener:  CheckResult{Ok: true, Time: now},
+		Exporters: []ExporterHealth{},
+	}
+
+	if config.Queue != nil {
+		result := newCheckResult(config.Queue.Check(), now)
+		health.Queue = &result
+		health.Ready = result.Ok
+	}
+
+	for _, exporter := range config.Exporter {
+		exporterHealth := config.health.exporter(exporter.Name())
+		if !exporterHealth.ready() {
+			health.Ready = false
+		}
+
+		health.Exporters = append(health.Exporters, exporterHealth)
+	}
+
+	return health
+}
+
+// Serve the health of fluxcloud on /healthz and /readyz. /healthz responds
+// while fluxcloud is listening, and /readyz responds with a 503 if it is not
+// ready.
+func HandleHealth(config APIConfig) {
+	config.Server.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, 200, CheckHealth(config, time.Now()))
+	})
+
+	config.Server.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		health := CheckHealth(config, time.Now())
+
+		status := 200
+		if !health.Ready {
+			status = 503
+		}
+
+		writeJSON(w, status, health)
+	})
+}
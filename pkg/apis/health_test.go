@@ -0,0 +1,185 @@
+package apis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/formatters"
+	"github.com/justinbarrick/fluxcloud/pkg/queue"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleHealth(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+	}))
+	defer upstream.Close()
+
+	sharedConfig := config.NewFakeConfig()
+	sharedConfig.Set("github_url", "https://github.com")
+	sharedConfig.Set("webhook_url", upstream.URL)
+
+	formatter, _ := formatters.NewDefaultFormatter(sharedConfig)
+
+	webhook, err := exporters.New("webhook", sharedConfig)
+	require.NoError(t, err)
+
+	apiConfig := NewAPIConfig(formatter, []exporters.Exporter{webhook}, sharedConfig)
+	HandleV6(apiConfig)
+	HandleHealth(apiConfig)
+
+	serve := func(method, url string, body []byte) (int, Health) {
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		recorder := httptest.NewRecorder()
+		apiConfig.Server.ServeHTTP(recorder, req)
+
+		health := Health{}
+		json.NewDecoder(recorder.Body).Decode(&health)
+		return recorder.Code, health
+	}
+
+	code, health := serve("GET", "http://127.0.0.1:3030/readyz", nil)
+	assert.Equal(t, 200, code)
+	assert.True(t, health.Ready)
+	assert.True(t, health.Listener.Ok)
+	assert.Nil(t, health.Queue)
+	require.Len(t, health.Exporters, 1)
+	assert.Equal(t, "Webhook", health.Exporters[0].Name)
+	assert.Nil(t, health.Exporters[0].LastSend)
+
+	data, _ := json.Marshal(test_utils.NewFluxSyncEvent())
+	code, _ = serve("POST", "http://127.0.0.1:3030/v6/events", data)
+	assert.Equal(t, 500, code)
+
+	// failing to send is reported, but does not make fluxcloud unready
+	code, health = serve("GET", "http://127.0.0.1:3030/readyz", nil)
+	assert.Equal(t, 200, code)
+	require.NotNil(t, health.Exporters[0].LastSend)
+	assert.False(t, health.Exporters[0].LastSend.Ok)
+	assert.Contains(t, health.Exporters[0].LastSend.Error, "status: 400")
+}
+
+func TestHandleHealthStartupCheck(t *testing.T) {
+	sharedConfig := config.NewFakeConfig()
+	sharedConfig.Set("webhook_url", "not-a-url")
+
+	webhook, err := exporters.New("webhook", sharedConfig)
+	require.NoError(t, err)
+
+	apiConfig := NewAPIConfig(nil, []exporters.Exporter{webhook, &exporters.FakeExporter{}}, sharedConfig)
+	HandleHealth(apiConfig)
+
+	assert.NotNil(t, CheckExporters(apiConfig))
+
+	for path, status := range map[string]int{"/healthz": 200, "/readyz": 503} {
+		req, _ := http.NewRequest("GET", "http://127.0.0.1:3030"+path, nil)
+		recorder := httptest.NewRecorder()
+		apiConfig.Server.ServeHTTP(recorder, req)
+		assert.Equal(t, status, recorder.Code, path)
+
+		health := Health{}
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(&health))
+		assert.False(t, health.Ready)
+		require.Len(t, health.Exporters, 2)
+		require.NotNil(t, health.Exporters[0].StartupCheck)
+		assert.False(t, health.Exporters[0].StartupCheck.Ok)
+		assert.True(t, health.Exporters[1].StartupCheck.Ok)
+	}
+}
+
+// An exporter whose startup check returns err.
+type checkedExporter struct {
+	exporters.FakeExporter
+	err error
+}
+
+func (c *checkedExporter) Check(context.Context, *http.Client) error {
+	return c.err
+}
+
+func TestCheckHealthRedactsErrors(t *testing.T) {
+	exporter := &checkedExporter{err: errors.New(`Post "https://matrix.org/send/m.room.message?access_token=SECRETTOKEN123": dial tcp: timeout`)}
+
+	apiConfig := NewAPIConfig(nil, []exporters.Exporter{exporter}, config.NewFakeConfig())
+	assert.NotNil(t, CheckExporters(apiConfig))
+	apiConfig.health.sent(exporter.Name(), exporter.err, time.Now())
+
+	// the health endpoints are not authenticated
+	health := CheckHealth(apiConfig, time.Now())
+	require.Len(t, health.Exporters, 1)
+	assert.NotContains(t, health.Exporters[0].StartupCheck.Error, "SECRETTOKEN123")
+	assert.NotContains(t, health.Exporters[0].LastSend.Error, "SECRETTOKEN123")
+}
+
+func TestRecheckExporters(t *testing.T) {
+	exporter := &checkedExporter{err: errors.New("upstream is down")}
+
+	apiConfig := NewAPIConfig(nil, []exporters.Exporter{exporter}, config.NewFakeConfig())
+	assert.NotNil(t, CheckExporters(apiConfig))
+	assert.False(t, CheckHealth(apiConfig, time.Now()).Ready)
+
+	exporter.err = nil
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go RecheckExporters(apiConfig, 10*time.Millisecond, stop)
+
+	ready := false
+	for i := 0; i < 50 && !ready; i++ {
+		time.Sleep(10 * time.Millisecond)
+		ready = CheckHealth(apiConfig, time.Now()).Ready
+	}
+
+	assert.True(t, ready)
+}
+
+func TestCheckHealthSentSinceStartupCheck(t *testing.T) {
+	exporter := &checkedExporter{err: errors.New("upstream is down")}
+
+	apiConfig := NewAPIConfig(nil, []exporters.Exporter{exporter}, config.NewFakeConfig())
+	assert.NotNil(t, CheckExporters(apiConfig))
+
+	now := time.Now()
+	apiConfig.health.sent(exporter.Name(), errors.New("boom"), now.Add(time.Second))
+	assert.False(t, CheckHealth(apiConfig, now).Ready)
+
+	// a successful send since the failed check shows the exporter works
+	apiConfig.health.sent(exporter.Name(), nil, now.Add(2*time.Second))
+	assert.True(t, CheckHealth(apiConfig, now).Ready)
+}
+
+func TestCheckHealthQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fluxcloud-queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	q, err := queue.NewQueue(dir, queue.Options{})
+	require.NoError(t, err)
+
+	apiConfig := NewAPIConfig(nil, nil, config.NewFakeConfig())
+	apiConfig.Queue = q
+
+	health := CheckHealth(apiConfig, time.Now())
+	assert.True(t, health.Ready)
+	require.NotNil(t, health.Queue)
+	assert.True(t, health.Queue.Ok)
+
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "dead")))
+
+	health = CheckHealth(apiConfig, time.Now())
+	assert.False(t, health.Ready)
+	assert.False(t, health.Queue.Ok)
+}
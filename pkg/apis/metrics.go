@@ -0,0 +1,192 @@
+package apis
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The Prometheus metrics about fluxcloud itself. A nil apiMetrics records
+// nothing, so that an APIConfig does not need them.
+type apiMetrics struct {
+	eventsReceived       *prometheus.CounterVec
+	formatterResults     *prometheus.CounterVec
+	exporterRequests     *prometheus.CounterVec
+	exporterLatency      *prometheus.HistogramVec
+	exporterFailures     *prometheus.CounterVec
+	websocketConnections prometheus.Counter
+	unauthorizedRequests *prometheus.CounterVec
+}
+
+type exporterContextKey struct{}
+
+// Create the metrics about fluxcloud and register them with registry.
+func newAPIMetrics(registry prometheus.Registerer) *apiMetrics {
+	m := &apiMetrics{
+		eventsReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fluxcloud_events_received_total",
+			Help: "Flux events received, by event type.",
+		}, []string{"type"}),
+		formatterResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fluxcloud_formatter_results_total",
+			Help: "Messages formatted for exporters, by exporter and whether the formatter produced a message to send, skipped it, or the event was filtered out.",
+		}, []string{"exporter", "result"}),
+		exporterRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fluxcloud_exporter_requests_total",
+			Help: "HTTP requests made by exporters including retries, by exporter and status code.",
+		}, []string{"exporter", "code"}),
+		exporterLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "fluxcloud_exporter_request_duration_seconds",
+			Help:    "Latency of HTTP requests made by exporters, by exporter and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"exporter", "code"}),
+		exporterFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fluxcloud_exporter_failures_total",
+			Help: "Messages that exporters failed to send after retrying, by exporter and status code.",
+		}, []string{"exporter", "code"}),
+		websocketConnections: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "fluxcloud_websocket_connections_total",
+			Help: "Websocket connections from Flux daemons.",
+		}),
+		unauthorizedRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fluxcloud_unauthorized_requests_total",
+			Help: "Requests rejected because their token was missing or invalid or their client certificate was not verified, by endpoint and reason.",
+		}, []string{"endpoint", "reason"}),
+	}
+
+	registry.MustRegister(m.eventsReceived, m.formatterResults, m.exporterRequests,
+		m.exporterLatency, m.exporterFailures, m.websocketConnections, m.unauthorizedRequests)
+
+	return m
+}
+
+// Serve the metrics registered with config.Metrics for Prometheus on
+// /metrics, along with the depth of the delivery queue and the number of
+// connected daemons.
+func HandleMetrics(config APIConfig) error {
+	if config.Queue != nil {
+		queue := config.Queue
+		err := config.Metrics.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "fluxcloud_queue_depth",
+			Help: "Deliveries waiting in the delivery queue.",
+		}, func() float64 {
+			return float64(queue.Len())
+		}))
+		if err != nil {
+			return err
+		}
+
+		err = config.Metrics.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "fluxcloud_dead_letters",
+			Help: "Deliveries in the dead letters.",
+		}, func() float64 {
+			return float64(queue.DeadLetters().Len())
+		}))
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.Sessions != nil {
+		sessions := config.Sessions
+		err := config.Metrics.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "fluxcloud_websocket_connections",
+			Help: "Flux daemons connected over the websocket.",
+		}, func() float64 {
+			return float64(len(sessions.List()))
+		}))
+		if err != nil {
+			return err
+		}
+	}
+
+	config.Server.Handle("/metrics", promhttp.HandlerFor(config.Metrics, promhttp.HandlerOpts{}))
+	return nil
+}
+
+// Count a received event.
+func (m *apiMetrics) eventReceived(eventType string) {
+	if m == nil {
+		return
+	}
+
+	m.eventsReceived.WithLabelValues(eventType).Inc()
+}
+
+// Count the result of formatting a message for an exporter.
+func (m *apiMetrics) formatted(exporter string, result string) {
+	if m == nil {
+		return
+	}
+
+	m.formatterResults.WithLabelValues(exporter, result).Inc()
+}
+
+// Count a message that an exporter failed to send.
+func (m *apiMetrics) exporterFailed(exporter string, err error) {
+	if m == nil {
+		return
+	}
+
+	code := "error"
+	if httpErr, ok := err.(*exporters.HTTPError); ok {
+		code = strconv.Itoa(httpErr.StatusCode)
+	}
+
+	m.exporterFailures.WithLabelValues(exporter, code).Inc()
+}
+
+// Count a websocket connection.
+func (m *apiMetrics) websocketConnected() {
+	if m == nil {
+		return
+	}
+
+	m.websocketConnections.Inc()
+}
+
+// Count a request that was rejected because it was not authenticated.
+func (m *apiMetrics) unauthorized(endpoint string, reason string) {
+	if m == nil {
+		return
+	}
+
+	m.unauthorizedRequests.WithLabelValues(endpoint, reason).Inc()
+}
+
+// Return a context that attributes the HTTP requests made with it to an
+// exporter in the metrics.
+func withExporter(ctx context.Context, exporter string) context.Context {
+	return context.WithValue(ctx, exporterContextKey{}, exporter)
+}
+
+// An HTTP transport that counts and times the requests made by exporters.
+type instrumentedTransport struct {
+	next    http.RoundTripper
+	metrics *apiMetrics
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	exporter, ok := req.Context().Value(exporterContextKey{}).(string)
+	if !ok || t.metrics == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	start := time.Now()
+	res, err := t.next.RoundTrip(req)
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(res.StatusCode)
+	}
+
+	t.metrics.exporterRequests.WithLabelValues(exporter, code).Inc()
+	t.metrics.exporterLatency.WithLabelValues(exporter, code).Observe(time.Since(start).Seconds())
+
+	return res, err
+}
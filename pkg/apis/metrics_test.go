@@ -0,0 +1,91 @@
+package apis
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/formatters"
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/queue"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleMetrics(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+	}))
+	defer upstream.Close()
+
+	sharedConfig := config.NewFakeConfig()
+	sharedConfig.Set("github_url", "https://github.com")
+	sharedConfig.Set("webhook_url", upstream.URL)
+
+	formatter, _ := formatters.NewDefaultFormatter(sharedConfig)
+
+	webhook, err := exporters.New("webhook", sharedConfig)
+	require.NoError(t, err)
+
+	apiConfig := NewAPIConfig(formatter, []exporters.Exporter{webhook}, sharedConfig)
+	HandleV6(apiConfig)
+	require.NoError(t, HandleMetrics(apiConfig))
+
+	serve := func(method, url string, body []byte) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		recorder := httptest.NewRecorder()
+		apiConfig.Server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	data, _ := json.Marshal(test_utils.NewFluxSyncEvent())
+	assert.Equal(t, 500, serve("POST", "http://127.0.0.1:3030/v6/events", data).Code)
+	assert.Equal(t, 400, serve("POST", "http://127.0.0.1:3030/v6/events", []byte("{")).Code)
+
+	response := serve("GET", "http://127.0.0.1:3030/metrics", nil)
+	assert.Equal(t, 200, response.Code)
+
+	metrics, _ := ioutil.ReadAll(response.Body)
+	for _, line := range []string{
+		`fluxcloud_events_received_total{type="sync"} 1`,
+		`fluxcloud_events_received_total{type="invalid"} 1`,
+		`fluxcloud_formatter_results_total{exporter="Webhook",result="sent"} 1`,
+		`fluxcloud_exporter_requests_total{code="400",exporter="Webhook"} 1`,
+		`fluxcloud_exporter_request_duration_seconds_count{code="400",exporter="Webhook"} 1`,
+		`fluxcloud_exporter_failures_total{code="400",exporter="Webhook"} 1`,
+		`fluxcloud_websocket_connections 0`,
+	} {
+		assert.Contains(t, string(metrics), line)
+	}
+}
+
+func TestHandleMetricsQueueDepth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fluxcloud-queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	q, err := queue.NewQueue(dir, queue.Options{})
+	require.NoError(t, err)
+
+	apiConfig := NewAPIConfig(nil, nil, config.NewFakeConfig())
+	apiConfig.Queue = q
+	require.NoError(t, HandleMetrics(apiConfig))
+
+	_, err = q.Enqueue("Fake", msg.Message{Title: "queued"})
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "http://127.0.0.1:3030/metrics", nil)
+	recorder := httptest.NewRecorder()
+	apiConfig.Server.ServeHTTP(recorder, req)
+
+	metrics, _ := ioutil.ReadAll(recorder.Body)
+	assert.Contains(t, string(metrics), "fluxcloud_queue_depth 1")
+	assert.Contains(t, string(metrics), "fluxcloud_dead_letters 0")
+}
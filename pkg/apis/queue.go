@@ -0,0 +1,113 @@
+package apis
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+	"github.com/justinbarrick/fluxcloud/pkg/queue"
+)
+
+// How often the queue is checked for deliveries that are due for a retry.
+var queuePollInterval = time.Second
+
+// Send queued deliveries in the background until stop is closed. Each
+// exporter sends at most the queue's concurrency of deliveries at once, oldest
+// first, and none while its upstream asked to wait with Retry-After. Failed
+// deliveries are retried with backoff until they succeed or get too old, or
+// moved to the dead letters at once if the upstream rejected them.
+func ProcessQueue(config APIConfig, stop <-chan struct{}) {
+	var lock sync.Mutex
+	inFlight := map[string]bool{}
+	sending := map[string]int{}
+	pausedUntil := map[string]time.Time{}
+	finished := make(chan struct{}, 1)
+
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		for _, delivery := range config.Queue.Due(now) {
+			lock.Lock()
+			if inFlight[delivery.ID] || sending[delivery.Exporter] >= config.Queue.Concurrency() || pausedUntil[delivery.Exporter].After(now) {
+				lock.Unlock()
+				continue
+			}
+			inFlight[delivery.ID] = true
+			sending[delivery.Exporter]++
+			lock.Unlock()
+
+			go func(delivery queue.Delivery) {
+				wait := attemptDelivery(config, delivery)
+
+				lock.Lock()
+				delete(inFlight, delivery.ID)
+				sending[delivery.Exporter]--
+				if wait > 0 {
+					pausedUntil[delivery.Exporter] = time.Now().Add(wait)
+				}
+				lock.Unlock()
+
+				select {
+				case finished <- struct{}{}:
+				default:
+				}
+			}(delivery)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-finished:
+		case <-config.Queue.Wake():
+		}
+	}
+}
+
+// Implemented by errors that know when the upstream can be tried again.
+type retryAfter interface {
+	RetryAfter() time.Duration
+}
+
+// Send a queued delivery, returning how long its upstream asked to wait
+// before sending to it again if it did.
+func attemptDelivery(config APIConfig, delivery queue.Delivery) time.Duration {
+	exporter := config.exporterByName(delivery.Exporter)
+	if exporter == nil {
+		logging.Warn("Dropping queued delivery, exporter is not configured", "delivery", delivery.ID, "exporter", delivery.Exporter)
+		if err := config.Queue.Done(delivery.ID); err != nil {
+			logging.Error("Could not remove delivery from queue", "delivery", delivery.ID, "err", err)
+		}
+		return 0
+	}
+
+	sendErr := deliver(context.Background(), config, exporter, delivery.Message)
+	if sendErr == nil {
+		if err := config.Queue.Done(delivery.ID); err != nil {
+			logging.Error("Could not remove delivery from queue", "delivery", delivery.ID, "err", err)
+		}
+		return 0
+	}
+
+	var wait time.Duration
+	if r, ok := sendErr.(retryAfter); ok {
+		wait = r.RetryAfter()
+	}
+
+	delivery, retry, err := config.Queue.Retry(delivery.ID, sendErr, time.Now())
+	if err != nil {
+		logging.Error("Could not update queued delivery", "delivery", delivery.ID, "err", err)
+		return wait
+	}
+
+	if retry {
+		logging.Warn("Retrying delivery", "delivery", delivery.ID, "exporter", delivery.Exporter, "next_attempt", delivery.NextAttempt.Format(time.RFC3339), "attempts", delivery.Attempts)
+	} else {
+		logging.Error("Moved delivery to the dead letters", "delivery", delivery.ID, "exporter", delivery.Exporter, "attempts", delivery.Attempts, "err", delivery.LastError)
+	}
+
+	return wait
+}
@@ -0,0 +1,224 @@
+package apis
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/formatters"
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/queue"
+	"github.com/justinbarrick/fluxcloud/pkg/routing"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessQueueRetries(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "fluxcloud-queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := config.NewFakeConfig()
+	config.Set("github_url", "https://github.com")
+	config.Set("webhook_url", ts.URL)
+
+	webhook, err := exporters.New("webhook", config)
+	require.NoError(t, err)
+
+	formatter, _ := formatters.NewDefaultFormatter(config)
+
+	apiConfig := NewAPIConfig(formatter, []exporters.Exporter{webhook}, config)
+	apiConfig.Queue, err = queue.NewQueue(dir, queue.Options{
+		MaxAge:         time.Minute,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	HandleV6(apiConfig)
+
+	queuePollInterval = 10 * time.Millisecond
+	stop := make(chan struct{})
+	defer close(stop)
+
+	data, _ := json.Marshal(test_utils.NewFluxSyncEvent())
+	req, _ := http.NewRequest("POST", "http://127.0.0.1:3030/v6/events", bytes.NewBuffer(data))
+
+	recorder := httptest.NewRecorder()
+	apiConfig.Server.ServeHTTP(recorder, req)
+	resp := recorder.Result()
+	assert.Equal(t, 200, resp.StatusCode)
+
+	response := EventResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+	assert.Equal(t, []ExporterResult{{Exporter: "Webhook", Status: ExporterQueued}}, response.Results)
+	assert.Equal(t, 1, apiConfig.Queue.Len())
+
+	go ProcessQueue(apiConfig, stop)
+
+	for i := 0; i < 100 && apiConfig.Queue.Len() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Equal(t, 0, apiConfig.Queue.Len())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestProcessQueueConcurrency(t *testing.T) {
+	var active, maxActive, requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			seen := atomic.LoadInt32(&maxActive)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxActive, seen, current) {
+				break
+			}
+		}
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(5 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "fluxcloud-queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := config.NewFakeConfig()
+	config.Set("webhook_url", ts.URL)
+	config.Set("webhook_rate_limit", "0")
+
+	webhook, err := exporters.New("webhook", config)
+	require.NoError(t, err)
+
+	formatter, _ := formatters.NewDefaultFormatter(config)
+
+	apiConfig := NewAPIConfig(formatter, []exporters.Exporter{webhook}, config)
+	apiConfig.Queue, err = queue.NewQueue(dir, queue.Options{MaxAge: time.Minute, Concurrency: 2})
+	require.NoError(t, err)
+
+	// a backlog left by an outage is not sent all at once
+	for i := 0; i < 10; i++ {
+		_, err := apiConfig.Queue.Enqueue("Webhook", msg.Message{Title: "title", Event: test_utils.NewFluxSyncEvent()})
+		require.NoError(t, err)
+	}
+
+	queuePollInterval = 10 * time.Millisecond
+	stop := make(chan struct{})
+	defer close(stop)
+	go ProcessQueue(apiConfig, stop)
+
+	for i := 0; i < 200 && apiConfig.Queue.Len() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Equal(t, 0, apiConfig.Queue.Len())
+	assert.Equal(t, int32(10), atomic.LoadInt32(&requests))
+	assert.True(t, atomic.LoadInt32(&maxActive) <= 2)
+}
+
+func TestProcessQueuePermanentError(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "fluxcloud-queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := config.NewFakeConfig()
+	config.Set("webhook_url", ts.URL)
+
+	webhook, err := exporters.New("webhook", config)
+	require.NoError(t, err)
+
+	formatter, _ := formatters.NewDefaultFormatter(config)
+
+	apiConfig := NewAPIConfig(formatter, []exporters.Exporter{webhook}, config)
+	apiConfig.Queue, err = queue.NewQueue(dir, queue.Options{
+		MaxAge:         time.Hour,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	delivery, err := apiConfig.Queue.Enqueue("Webhook", msg.Message{Title: "title", Event: test_utils.NewFluxSyncEvent()})
+	require.NoError(t, err)
+
+	queuePollInterval = 10 * time.Millisecond
+	stop := make(chan struct{})
+	defer close(stop)
+	go ProcessQueue(apiConfig, stop)
+
+	for i := 0; i < 100 && apiConfig.Queue.Len() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Equal(t, 0, apiConfig.Queue.Len())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	_, ok := apiConfig.Queue.DeadLetters().Get(delivery.ID)
+	assert.True(t, ok)
+}
+
+func TestEnqueueByDestination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fluxcloud-queue")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := config.NewFakeConfig()
+	config.Set("github_url", "https://github.com")
+
+	formatter, _ := formatters.NewDefaultFormatter(config)
+
+	router, err := routing.NewRouter([]routing.Route{
+		{
+			Match:        routing.Match{Namespaces: []string{"default"}},
+			Exporters:    []string{"Fake"},
+			Destinations: []string{"#ops", "#dev"},
+		},
+	})
+	require.NoError(t, err)
+
+	apiConfig := NewAPIConfig(formatter, []exporters.Exporter{&exporters.FakeExporter{}}, config)
+	apiConfig.Router = router
+	apiConfig.Queue, err = queue.NewQueue(dir, queue.Options{MaxAge: time.Minute})
+	require.NoError(t, err)
+
+	HandleV6(apiConfig)
+
+	data, _ := json.Marshal(test_utils.NewFluxSyncEvent())
+	req, _ := http.NewRequest("POST", "http://127.0.0.1:3030/v6/events", bytes.NewBuffer(data))
+
+	recorder := httptest.NewRecorder()
+	apiConfig.Server.ServeHTTP(recorder, req)
+	assert.Equal(t, 200, recorder.Code)
+
+	// each destination is retried on its own
+	destinations := []string{}
+	for _, delivery := range apiConfig.Queue.Due(time.Now()) {
+		require.Len(t, delivery.Message.Destinations, 1)
+		destinations = append(destinations, delivery.Message.Destinations[0])
+	}
+	assert.ElementsMatch(t, []string{"#ops", "#dev"}, destinations)
+}
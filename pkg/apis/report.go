@@ -0,0 +1,107 @@
+package apis
+
+import (
+	"context"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/dedupe"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+	"github.com/justinbarrick/fluxcloud/pkg/report"
+	"github.com/justinbarrick/fluxcloud/pkg/schedule"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+// The name that events recorded for reports are marked with in the
+// deduplicator, alongside the exporters that sent them.
+const reportRecorder = "reports"
+
+// Send a deployment report of the events recorded in config.Reports each time
+// the schedule runs, until stop is closed.
+func RunReports(config APIConfig, s *schedule.Schedule, stop <-chan struct{}) {
+	for {
+		next := s.Next(time.Now())
+		if next.IsZero() {
+			logging.Warn("The report schedule never runs, not sending reports")
+			return
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		report, err := config.Reports.Take(next)
+		if err != nil {
+			logging.Error("Could not save report state", "err", err)
+		}
+
+		sendReport(config, report)
+	}
+}
+
+// Record an event from a cluster for the next report. Copies of an event that
+// Flux sends again, such as after an exporter failed, are only recorded once
+// within the deduplication window.
+func recordEvent(config APIConfig, cluster string, event fluxevent.Event) {
+	if config.Reports == nil {
+		return
+	}
+
+	now := time.Now()
+	key := dedupe.Key(cluster, event)
+	if _, ok := config.Dedupe.Filter(key, reportRecorder, nil, now); !ok {
+		logging.Info("Report already recorded event", "key", key)
+		return
+	}
+
+	if err := config.Reports.Add(event); err != nil {
+		logging.Error("Could not save report state", "err", err)
+	}
+
+	if err := config.Dedupe.Mark(key, reportRecorder, nil, now); err != nil {
+		logging.Error("Could not save deduplication state", "err", err)
+	}
+}
+
+// Send a report through each of the report exporters.
+func sendReport(config APIConfig, report report.Report) {
+	logging.Info("Sending report", "events", report.Events, "since", report.Start)
+
+	for _, exporter := range config.reportExporters() {
+		message := config.FormatterFor(exporter).FormatReport(report, exporter)
+		if message.Title == "" {
+			continue
+		}
+
+		if config.Queue != nil {
+			if _, err := config.Queue.Enqueue(exporter.Name(), message); err != nil {
+				logging.Error("Could not queue report", "exporter", exporter.Name(), "err", err)
+			}
+			continue
+		}
+
+		deliver(context.Background(), config, exporter, message)
+	}
+}
+
+// Return the exporters that send reports, named by ReportExporters or all
+// exporters if it is empty.
+func (a *APIConfig) reportExporters() []exporters.Exporter {
+	if len(a.ReportExporters) == 0 {
+		return a.Exporter
+	}
+
+	selected := []exporters.Exporter{}
+	for _, exporter := range a.Exporter {
+		for _, name := range a.ReportExporters {
+			if name == exporters.Spec(exporter) {
+				selected = append(selected, exporter)
+				break
+			}
+		}
+	}
+
+	return selected
+}
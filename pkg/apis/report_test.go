@@ -0,0 +1,90 @@
+package apis
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/dedupe"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/formatters"
+	"github.com/justinbarrick/fluxcloud/pkg/report"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReports(t *testing.T) {
+	managers := &exporters.FakeExporter{ExporterName: "Managers"}
+	engineers := &exporters.FakeExporter{ExporterName: "Engineers"}
+
+	sharedConfig := config.NewFakeConfig()
+	sharedConfig.Set("github_url", "https://github.com")
+
+	formatter, _ := formatters.NewDefaultFormatter(sharedConfig)
+
+	start := time.Now()
+	recorder, err := report.NewRecorder("", start)
+	require.NoError(t, err)
+
+	apiConfig := NewAPIConfig(formatter, []exporters.Exporter{managers, engineers}, sharedConfig)
+	apiConfig.Reports = recorder
+	apiConfig.ReportExporters = []string{"Managers"}
+	HandleV6(apiConfig)
+
+	data, _ := json.Marshal(test_utils.NewFluxAutoReleaseEvent())
+	req, _ := http.NewRequest("POST", "http://127.0.0.1:3030/v6/events", bytes.NewBuffer(data))
+	response := httptest.NewRecorder()
+	apiConfig.Server.ServeHTTP(response, req)
+	assert.Equal(t, 200, response.Code)
+
+	end := start.Add(time.Hour)
+	summary, err := apiConfig.Reports.Take(end)
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Events)
+
+	sendReport(apiConfig, summary)
+
+	require.Len(t, managers.Sent, 2)
+	assert.Contains(t, managers.Sent[1].Title, "Flux deployment report")
+	assert.Contains(t, managers.Sent[1].Body, "Releases: 1")
+	assert.Len(t, engineers.Sent, 1)
+}
+
+func TestReportsDedupe(t *testing.T) {
+	sharedConfig := config.NewFakeConfig()
+	sharedConfig.Set("github_url", "https://github.com")
+
+	formatter, _ := formatters.NewDefaultFormatter(sharedConfig)
+
+	recorder, err := report.NewRecorder("", time.Now())
+	require.NoError(t, err)
+
+	deduplicator, err := dedupe.NewDeduplicator(time.Minute, "")
+	require.NoError(t, err)
+
+	fakeExporter := &exporters.FakeExporter{SendError: errors.New("boom")}
+	apiConfig := NewAPIConfig(formatter, []exporters.Exporter{fakeExporter}, sharedConfig)
+	apiConfig.Reports = recorder
+	apiConfig.Dedupe = deduplicator
+	HandleV6(apiConfig)
+
+	// Flux sends the event again after the exporter failed, it is one sync
+	data, _ := json.Marshal(test_utils.NewFluxSyncEvent())
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "http://127.0.0.1:3030/v6/events", bytes.NewBuffer(data))
+		response := httptest.NewRecorder()
+		apiConfig.Server.ServeHTTP(response, req)
+		assert.Equal(t, 500, response.Code)
+	}
+
+	summary, err := recorder.Take(time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Events)
+	assert.Equal(t, 1, summary.Syncs)
+}
@@ -0,0 +1,107 @@
+package apis
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/weaveworks/flux/api"
+)
+
+// A Flux daemon connected to fluxcloud over the websocket.
+type Session struct {
+	ID          string    `json:"id"`
+	Cluster     string    `json:"cluster"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	UserAgent   string    `json:"userAgent"`
+	Version     string    `json:"version"`
+	ConnectedAt time.Time `json:"connectedAt"`
+	LastPing    time.Time `json:"lastPing"`
+
+	// The daemon's API, which can be used to call back into the cluster.
+	Daemon api.UpstreamServer `json:"-"`
+}
+
+// Tracks the Flux daemons that are currently connected.
+type Sessions struct {
+	lock     sync.Mutex
+	counter  int
+	sessions map[string]*Session
+}
+
+// Create an empty session registry.
+func NewSessions() *Sessions {
+	return &Sessions{
+		sessions: map[string]*Session{},
+	}
+}
+
+// Register a new session for a websocket request from a cluster.
+func (s *Sessions) Add(r *http.Request, cluster string, daemon api.UpstreamServer) Session {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.counter++
+
+	session := &Session{
+		ID:          fmt.Sprintf("%d", s.counter),
+		Cluster:     cluster,
+		RemoteAddr:  r.RemoteAddr,
+		UserAgent:   r.UserAgent(),
+		ConnectedAt: time.Now(),
+		Daemon:      daemon,
+	}
+	s.sessions[session.ID] = session
+
+	return *session
+}
+
+// Modify a session in place.
+func (s *Sessions) Update(id string, update func(*Session)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if session, ok := s.sessions[id]; ok {
+		update(session)
+	}
+}
+
+// Forget about a session once its daemon has disconnected.
+func (s *Sessions) Remove(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.sessions, id)
+}
+
+// Return a session by ID.
+func (s *Sessions) Get(id string) (Session, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+
+	return *session, true
+}
+
+// Return all of the connected sessions, oldest first.
+func (s *Sessions) List() []Session {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sessions := []Session{}
+	for _, session := range s.sessions {
+		sessions = append(sessions, *session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ConnectedAt.Before(sessions[j].ConnectedAt)
+	})
+
+	return sessions
+}
@@ -2,51 +2,306 @@ package apis
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/justinbarrick/fluxcloud/pkg/dedupe"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
 	"github.com/justinbarrick/fluxcloud/pkg/utils"
+	fluxevent "github.com/weaveworks/flux/event"
 )
 
+const (
+	ExporterSent    = "sent"
+	ExporterSkipped = "skipped"
+	ExporterFailed  = "failed"
+	ExporterQueued  = "queued"
+
+	// The exporter already sent the event within the deduplication window.
+	ExporterDuplicate = "duplicate"
+
+	// The event was added to a digest that will be sent later.
+	ExporterBatched = "batched"
+)
+
+// The outcome of sending an event through one exporter.
+type ExporterResult struct {
+	Exporter string `json:"exporter"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// The response to a Flux event, listing what each exporter did with it.
+type EventResponse struct {
+	Results []ExporterResult `json:"results"`
+}
+
 // Handle Flux events
-func HandleV6(config APIConfig) (err error) {
-	config.Server.HandleFunc("/v6/events", func(w http.ResponseWriter, r *http.Request) {
-		log.Print("Request for:", r.URL)
+func HandleV6(config APIConfig) {
+	config.Server.HandleFunc("/v6/events", verified(config, "/v6/events", authenticated(config, "/v6/events", func(w http.ResponseWriter, r *http.Request) {
+		logging.Debug("Request", "url", r.URL)
 
 		eventStr, err := ioutil.ReadAll(r.Body)
-		log.Print(string(eventStr))
+		logging.Debug("Received event", "event", eventStr)
 
 		event, err := utils.ParseFluxEvent(bytes.NewBuffer(eventStr))
 		if err != nil {
-			log.Print(err.Error())
+			config.apiMetrics.eventReceived("invalid")
+			logging.Warn("Could not parse event", "err", err)
 			http.Error(w, err.Error(), 400)
 			return
 		}
 
-		var sendError bool
-		for _, exporter := range config.Exporter {
-			message := config.Formatter.FormatEvent(event, exporter)
-			if message.Title == "" {
-				w.WriteHeader(200)
-				return
-			}
+		cluster := clusterName(config, r)
+		ctx := withCluster(r.Context(), cluster)
 
-			err = exporter.Send(r.Context(), config.Client, message)
-			if err != nil {
-				log.Printf("Exporter %v got an error: %v", exporter.Name(), err.Error())
-				sendError = true
-				continue
+		config.apiMetrics.eventReceived(event.Type)
+		recordEvent(config, cluster, event)
+		recordDORA(config, cluster, event)
+		recovered := watchEvent(config, cluster, event)
+
+		// deployments are tracked before errors are stripped so that a sync
+		// that failed fails its deployment
+		tracked := trackDeployments(config, cluster, event)
+
+		event, announced, alerts := trackErrors(config, cluster, event)
+
+		response := EventResponse{}
+		if !announced {
+			response.Results = skipAll(config)
+		} else if config.Digest != nil {
+			response.Results = batch(config, cluster, event)
+		} else if len(tracked) > 0 {
+			response.Results = announceDeployments(ctx, config, tracked)
+
+			// deployments that no exporter could format are announced as the event
+			if allSkipped(response.Results) {
+				response.Results = announce(ctx, config, event, config.FormatEvent)
 			}
+		} else {
+			response.Results = announce(ctx, config, event, config.FormatEvent)
 		}
-		// catching error after all the exporters have ran
+
+		sendAlerts(ctx, config, alerts)
+		sendWatchdogAlerts(ctx, config, recovered)
+
 		// if any exporter failed we will return 500 on the /v6/events endpoint
-		if sendError {
-			http.Error(w, err.Error(), 500)
-			return
+		status := 200
+		errs := []string{}
+		for _, result := range response.Results {
+			if result.Status == ExporterFailed {
+				status = 500
+				errs = append(errs, fmt.Sprintf("%s: %s", result.Exporter, result.Error))
+			}
 		}
-		w.WriteHeader(200)
-	})
+
+		if len(errs) > 0 {
+			logging.Error("Exporters failed", "failed", len(errs), "err", strings.Join(errs, "; "))
+		}
+
+		writeJSON(w, status, response)
+	})))
+}
+
+// Formats an event for an exporter, returning a message without a title if
+// the exporter should not send it.
+type formatFunc func(event fluxevent.Event, exporter exporters.Exporter) msg.Message
+
+// Send an event through every exporter, or queue it if the delivery queue is
+// enabled. Messages are labelled with the cluster attached to ctx.
+func announce(ctx context.Context, config APIConfig, event fluxevent.Event, format formatFunc) []ExporterResult {
+	if cluster := contextCluster(ctx); cluster != "" {
+		format = withClusterName(format, cluster)
+	}
+
+	if config.Queue != nil {
+		return enqueue(ctx, config, event, format)
+	}
+
+	return dispatch(ctx, config, event, format)
+}
+
+// Wrap a formatFunc so that its messages are labelled with a cluster.
+func withClusterName(format formatFunc, cluster string) formatFunc {
+	return func(event fluxevent.Event, exporter exporters.Exporter) msg.Message {
+		message := format(event, exporter)
+		if message.Cluster == "" {
+			message.Cluster = cluster
+		}
+		return message
+	}
+}
+
+// Send an event through every exporter concurrently, each exporter decides
+// for itself whether to send the event and has its own deadline. Results are
+// returned in the same order as the exporters.
+func dispatch(ctx context.Context, config APIConfig, event fluxevent.Event, format formatFunc) []ExporterResult {
+	results := make([]ExporterResult, len(config.Exporter))
+
+	var wg sync.WaitGroup
+	for i, exporter := range config.Exporter {
+		wg.Add(1)
+		go func(i int, exporter exporters.Exporter) {
+			defer wg.Done()
+			results[i] = send(ctx, config, exporter, event, format)
+		}(i, exporter)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func send(ctx context.Context, config APIConfig, exporter exporters.Exporter, event fluxevent.Event, format formatFunc) ExporterResult {
+	result := ExporterResult{
+		Exporter: exporter.Name(),
+	}
+
+	message := format(event, exporter)
+	if message.Title == "" {
+		result.Status = ExporterSkipped
+		return result
+	}
+
+	key := dedupe.Key(contextCluster(ctx), event)
+	message, ok := deduplicate(config, key, exporter, message)
+	if !ok {
+		result.Status = ExporterDuplicate
+		return result
+	}
+
+	if err := deliver(ctx, config, exporter, message); err != nil {
+		releaseSent(config, key, exporter, message)
+		result.Status = ExporterFailed
+		result.Error = logging.Redact(err.Error())
+		return result
+	}
+
+	markSent(config, key, exporter, message)
+	result.Status = ExporterSent
+	return result
+}
+
+// Queue an event for every exporter that wants to send it, the queue sends
+// them in the background.
+func enqueue(ctx context.Context, config APIConfig, event fluxevent.Event, format formatFunc) []ExporterResult {
+	results := []ExporterResult{}
+
+	for _, exporter := range config.Exporter {
+		result := ExporterResult{
+			Exporter: exporter.Name(),
+		}
+
+		key := dedupe.Key(contextCluster(ctx), event)
+		message := format(event, exporter)
+		ok := message.Title != ""
+		if ok {
+			message, ok = deduplicate(config, key, exporter, message)
+		}
+
+		if message.Title == "" {
+			result.Status = ExporterSkipped
+		} else if !ok {
+			result.Status = ExporterDuplicate
+		} else if err := queueByDestination(config, key, exporter, message); err != nil {
+			releaseSent(config, key, exporter, message)
+			logging.Error("Could not queue event", "exporter", exporter.Name(), "err", err)
+			result.Status = ExporterFailed
+			result.Error = logging.Redact(err.Error())
+		} else {
+			result.Status = ExporterQueued
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// Queue a message once for each destination it was routed to, so that a
+// destination that fails is retried without sending to the others again.
+// Queued deliveries are retried until they are sent, so each counts as
+// announced once it is queued.
+func queueByDestination(config APIConfig, key string, exporter exporters.Exporter, message msg.Message) error {
+	messages := []msg.Message{message}
+	if len(message.Destinations) > 1 {
+		messages = []msg.Message{}
+		for _, destination := range message.Destinations {
+			single := message
+			single.Destinations = []string{destination}
+			messages = append(messages, single)
+		}
+	}
+
+	for _, message := range messages {
+		if _, err := config.Queue.Enqueue(exporter.Name(), message); err != nil {
+			return err
+		}
+
+		markSent(config, key, exporter, message)
+	}
+
+	return nil
+}
+
+// Report that every exporter skipped an event.
+func skipAll(config APIConfig) []ExporterResult {
+	results := []ExporterResult{}
+	for _, exporter := range config.Exporter {
+		results = append(results, ExporterResult{
+			Exporter: exporter.Name(),
+			Status:   ExporterSkipped,
+		})
+	}
+	return results
+}
+
+// Drop the destinations that an exporter already sent an event to within the
+// deduplication window, returns false if there are none left. The remaining
+// destinations are reserved until they are passed to markSent or releaseSent.
+func deduplicate(config APIConfig, key string, exporter exporters.Exporter, message msg.Message) (msg.Message, bool) {
+	destinations, ok := config.Dedupe.Filter(key, exporter.Name(), message.Destinations, time.Now())
+	if !ok {
+		logging.Info("Exporter already sent event", "exporter", exporter.Name(), "key", key)
+	}
+
+	message.Destinations = destinations
+	return message, ok
+}
+
+// Record that an exporter sent an event so that copies of it are suppressed.
+func markSent(config APIConfig, key string, exporter exporters.Exporter, message msg.Message) {
+	if err := config.Dedupe.Mark(key, exporter.Name(), message.Destinations, time.Now()); err != nil {
+		logging.Error("Could not save deduplication state", "err", err)
+	}
+}
+
+// Release the destinations reserved by deduplicate when an exporter could not
+// send an event, so that Flux sending it again is not suppressed. Destinations
+// that were already marked as sent stay sent.
+func releaseSent(config APIConfig, key string, exporter exporters.Exporter, message msg.Message) {
+	config.Dedupe.Release(key, exporter.Name(), message.Destinations)
+}
+
+// Send a formatted message through an exporter with the exporter's deadline.
+func deliver(ctx context.Context, config APIConfig, exporter exporters.Exporter, message msg.Message) error {
+	ctx, cancel := context.WithTimeout(withExporter(ctx, exporter.Name()), exporters.Timeout(exporter))
+	defer cancel()
+
+	err := exporter.Send(ctx, config.Client, message)
+	config.health.sent(exporter.Name(), err, time.Now())
+
+	if err != nil {
+		logging.Error("Exporter got an error", "exporter", exporter.Name(), "err", err)
+		config.apiMetrics.exporterFailed(exporter.Name(), err)
+		return err
+	}
 
 	return nil
 }
@@ -1,43 +1,254 @@
 package apis
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
+	"github.com/justinbarrick/fluxcloud/pkg/auth"
 	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/dedupe"
 	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/filter"
 	"github.com/justinbarrick/fluxcloud/pkg/formatters"
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/routing"
 	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestHandleV6(t *testing.T) {
 	fakeExporter := &exporters.FakeExporter{}
-	config := config.NewFakeConfig()
-	config.Set("github_url", "https://github.com")
+	apiConfig := newTestAPI(t, nil, fakeExporter)
+	HandleV6(apiConfig)
+
+	event := test_utils.NewFluxSyncEvent()
+	status, _ := postEvent(t, apiConfig, event)
+	assert.Equal(t, 200, status)
+
+	formatted := apiConfig.Formatter.FormatEvent(event, fakeExporter)
+	assert.Equal(t, formatted.Title, fakeExporter.Sent[0].Title, formatted.Title)
+	assert.Equal(t, formatted.Body, fakeExporter.Sent[0].Body, formatted.Body)
+}
+
+func TestHandleV6PerExporter(t *testing.T) {
+	skipped := &exporters.FakeExporter{ExporterName: "Skipped"}
+	failed := &exporters.FakeExporter{ExporterName: "Failed", SendError: errors.New("boom: https://hooks.slack.com/services/T000/B000/XXXX")}
+	sent := &exporters.FakeExporter{ExporterName: "Sent"}
+
+	apiConfig := newTestAPI(t, nil, skipped, failed, sent)
+
+	skipConfig := config.NewFakeConfig()
+	skipConfig.Set("title_template", `{{ if eq .EventType "commit" }}Commit{{ end }}`)
+	skipFormatter, err := apiConfig.Formatter.(*formatters.DefaultFormatter).ForConfig(skipConfig)
+	require.NoError(t, err)
+	apiConfig.Formatters["Skipped"] = skipFormatter
+
+	HandleV6(apiConfig)
+
+	status, results := postEvent(t, apiConfig, test_utils.NewFluxSyncEvent())
+	assert.Equal(t, 500, status)
+	assert.Equal(t, []ExporterResult{
+		{Exporter: "Skipped", Status: ExporterSkipped},
+		{Exporter: "Failed", Status: ExporterFailed, Error: "boom: https://hooks.slack.com/services/REDACTED"},
+		{Exporter: "Sent", Status: ExporterSent},
+	}, results)
+
+	assert.Len(t, skipped.Sent, 0)
+	assert.Len(t, sent.Sent, 1)
+}
+
+func TestHandleV6ExporterTimeout(t *testing.T) {
+	hang := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer hung.Close()
+	defer close(hang)
+
+	apiConfig := newTestAPI(t, map[string]string{
+		"hung_webhook_url":     hung.URL,
+		"hung_webhook_timeout": "50ms",
+	})
+
+	hungExporter, err := exporters.New("webhook:hung", apiConfig.Config)
+	require.NoError(t, err)
+
+	sent := &exporters.FakeExporter{}
+	apiConfig.Exporter = []exporters.Exporter{hungExporter, sent}
+	HandleV6(apiConfig)
+
+	start := time.Now()
+	status, results := postEvent(t, apiConfig, test_utils.NewFluxSyncEvent())
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, 500, status)
+
+	require.Len(t, results, 2)
+	assert.Equal(t, "Webhook (hung)", results[0].Exporter)
+	assert.Equal(t, ExporterFailed, results[0].Status)
+	assert.Contains(t, results[0].Error, "deadline exceeded")
+	assert.Equal(t, ExporterResult{Exporter: "Fake", Status: ExporterSent}, results[1])
+	assert.Len(t, sent.Sent, 1)
+}
+
+func TestHandleV6Routing(t *testing.T) {
+	ops := &exporters.FakeExporter{ExporterName: "Ops"}
+	dev := &exporters.FakeExporter{ExporterName: "Dev"}
+
+	apiConfig := newTestAPI(t, nil, ops, dev)
+
+	var err error
+	apiConfig.Router, err = routing.NewRouter([]routing.Route{
+		{
+			Match:        routing.Match{Namespaces: []string{"default"}},
+			Exporters:    []string{"Ops"},
+			Destinations: []string{"#ops"},
+		},
+	})
+	require.NoError(t, err)
+	HandleV6(apiConfig)
+
+	status, results := postEvent(t, apiConfig, test_utils.NewFluxSyncEvent())
+	assert.Equal(t, 200, status)
+	assert.Equal(t, []ExporterResult{
+		{Exporter: "Ops", Status: ExporterSent},
+		{Exporter: "Dev", Status: ExporterSkipped},
+	}, results)
+
+	require.Len(t, ops.Sent, 1)
+	assert.Equal(t, []string{"#ops"}, ops.Sent[0].Destinations)
+	assert.Len(t, dev.Sent, 0)
+}
+
+func TestHandleV6Filters(t *testing.T) {
+	commits := &exporters.FakeExporter{ExporterName: "Commits"}
+	syncs := &exporters.FakeExporter{ExporterName: "Syncs"}
+
+	apiConfig := newTestAPI(t, nil, commits, syncs)
+	apiConfig.Filter = &filter.Filter{ExcludeEventTypes: []string{"autorelease"}}
+	apiConfig.Filters["Syncs"] = &filter.Filter{IncludeEventTypes: []string{"sync"}}
+	HandleV6(apiConfig)
+
+	_, results := postEvent(t, apiConfig, test_utils.NewFluxCommitEvent())
+	assert.Equal(t, []ExporterResult{
+		{Exporter: "Commits", Status: ExporterSent},
+		{Exporter: "Syncs", Status: ExporterSkipped},
+	}, results)
 
-	formatter, _ := formatters.NewDefaultFormatter(config)
+	_, results = postEvent(t, apiConfig, test_utils.NewFluxAutoReleaseEvent())
+	assert.Equal(t, []ExporterResult{
+		{Exporter: "Commits", Status: ExporterSkipped},
+		{Exporter: "Syncs", Status: ExporterSkipped},
+	}, results)
 
-	apiConfig := APIConfig{
-		Server:    http.NewServeMux(),
-		Exporter:  []exporters.Exporter{fakeExporter},
-		Formatter: formatter,
+	assert.Len(t, commits.Sent, 1)
+	assert.Len(t, syncs.Sent, 0)
+}
+
+func TestHandleV6Dedupe(t *testing.T) {
+	fakeExporter := &exporters.FakeExporter{}
+	apiConfig := newTestAPI(t, nil, fakeExporter)
+
+	var err error
+	apiConfig.Dedupe, err = dedupe.NewDeduplicator(time.Minute, "")
+	require.NoError(t, err)
+	HandleV6(apiConfig)
+
+	statuses := []string{}
+	for i := 0; i < 2; i++ {
+		status, results := postEvent(t, apiConfig, test_utils.NewFluxSyncEvent())
+		assert.Equal(t, 200, status)
+		require.Len(t, results, 1)
+		statuses = append(statuses, results[0].Status)
 	}
 
+	assert.Equal(t, []string{ExporterSent, ExporterDuplicate}, statuses)
+	assert.Len(t, fakeExporter.Sent, 1)
+}
+
+// An exporter whose sends block until release is closed.
+type blockingExporter struct {
+	exporters.FakeExporter
+	sending chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingExporter) Send(ctx context.Context, client *http.Client, message msg.Message) error {
+	b.sending <- struct{}{}
+	<-b.release
+	return b.FakeExporter.Send(ctx, client, message)
+}
+
+func TestHandleV6DedupeWhileSending(t *testing.T) {
+	blocking := &blockingExporter{sending: make(chan struct{}, 2), release: make(chan struct{})}
+
+	apiConfig := newTestAPI(t, nil, blocking)
+
+	var err error
+	apiConfig.Dedupe, err = dedupe.NewDeduplicator(time.Minute, "")
+	require.NoError(t, err)
 	HandleV6(apiConfig)
 
-	event := test_utils.NewFluxSyncEvent()
-	data, _ := json.Marshal(event)
-	req, _ := http.NewRequest("POST", "http://127.0.0.1:3030/v6/events", bytes.NewBuffer(data))
+	post := func() string {
+		_, results := postEvent(t, apiConfig, test_utils.NewFluxSyncEvent())
+		require.Len(t, results, 1)
+		return results[0].Status
+	}
 
-	recorder := httptest.NewRecorder()
-	apiConfig.Server.ServeHTTP(recorder, req)
-	resp := recorder.Result()
-	assert.Equal(t, 200, resp.StatusCode)
+	first := make(chan string)
+	go func() {
+		first <- post()
+	}()
 
-	formatted := formatter.FormatEvent(event, fakeExporter)
-	assert.Equal(t, formatted.Title, fakeExporter.Sent[0].Title, formatted.Title)
-	assert.Equal(t, formatted.Body, fakeExporter.Sent[0].Body, formatted.Body)
+	// Flux sends the event again while the first copy is being sent
+	<-blocking.sending
+	assert.Equal(t, ExporterDuplicate, post())
+
+	close(blocking.release)
+	assert.Equal(t, ExporterSent, <-first)
+	assert.Len(t, blocking.Sent, 1)
+}
+
+func TestHandleV6DedupePerCluster(t *testing.T) {
+	fakeExporter := &exporters.FakeExporter{}
+	apiConfig := newTestAPI(t, nil, fakeExporter)
+	apiConfig.Auth = auth.NewAuthenticator("", map[string]string{
+		"production": "production-token",
+		"staging":    "staging-token",
+	})
+
+	var err error
+	apiConfig.Dedupe, err = dedupe.NewDeduplicator(time.Minute, "")
+	require.NoError(t, err)
+	HandleV6(apiConfig)
+
+	// the same event from two clusters is two notifications
+	for _, token := range []string{"production-token", "staging-token", "staging-token"} {
+		status, _ := postEvent(t, apiConfig, test_utils.NewFluxSyncEvent(), "Authorization", "Scope-Probe token="+token)
+		assert.Equal(t, 200, status)
+	}
+
+	require.Len(t, fakeExporter.Sent, 2)
+	assert.Equal(t, "production", fakeExporter.Sent[0].Cluster)
+	assert.Equal(t, "staging", fakeExporter.Sent[1].Cluster)
+}
+
+func TestHandleV6DedupeAfterFailure(t *testing.T) {
+	fakeExporter := &exporters.FakeExporter{SendError: errors.New("boom")}
+	apiConfig := newTestAPI(t, nil, fakeExporter)
+
+	var err error
+	apiConfig.Dedupe, err = dedupe.NewDeduplicator(time.Minute, "")
+	require.NoError(t, err)
+	HandleV6(apiConfig)
+
+	// a failed send is not recorded, so Flux retrying the event sends it
+	for _, expected := range []int{500, 200} {
+		status, _ := postEvent(t, apiConfig, test_utils.NewFluxSyncEvent())
+		assert.Equal(t, expected, status)
+		fakeExporter.SendError = nil
+	}
 }
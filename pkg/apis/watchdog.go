@@ -0,0 +1,85 @@
+package apis
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/filter"
+	"github.com/justinbarrick/fluxcloud/pkg/formatters"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/watchdog"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+// The name of the cluster that requests without a cluster token come from,
+// unless CLUSTER_NAME is set.
+const DefaultClusterName = "default"
+
+// Check every interval for clusters that went quiet and alert about them,
+// until stop is closed.
+func RunWatchdog(config APIConfig, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		alertsToSend, err := config.Watchdog.Check(time.Now())
+		if err != nil {
+			logging.Error("Could not save watchdog state", "err", err)
+		}
+
+		sendWatchdogAlerts(context.Background(), config, alertsToSend)
+	}
+}
+
+// Record an event from a cluster, returning the alerts for clusters that
+// recovered.
+func watchEvent(config APIConfig, cluster string, event fluxevent.Event) []watchdog.Alert {
+	if config.Watchdog == nil {
+		return nil
+	}
+
+	alertsToSend, err := config.Watchdog.Event(cluster, event, time.Now())
+	if err != nil {
+		logging.Error("Could not save watchdog state", "err", err)
+	}
+
+	return alertsToSend
+}
+
+// Send watchdog alerts through every exporter that the routing rules let send
+// them, queueing them if the delivery queue is enabled. They are not filtered:
+// they are about clusters rather than events, and a filter meant for events
+// should not hide that a cluster stopped syncing.
+func sendWatchdogAlerts(ctx context.Context, config APIConfig, alertsToSend []watchdog.Alert) {
+	for _, alert := range alertsToSend {
+		alert := alert
+		sendAlert(withCluster(ctx, alert.Cluster.Name), config, alert.Event(), "watchdog alert", unfiltered, func(formatter formatters.Formatter, exporter exporters.Exporter) msg.Message {
+			return formatter.FormatWatchdog(alert, exporter)
+		})
+	}
+}
+
+// Let every event through the filters.
+func unfiltered(*filter.Filter, fluxevent.Event) bool {
+	return true
+}
+
+// Return the name of the cluster that sent a request: the cluster that its
+// token belongs to, or the configured cluster name. Daemons get a new address
+// when their pod restarts, so clusters are never named after it.
+func clusterName(config APIConfig, r *http.Request) string {
+	if cluster := contextCluster(r.Context()); cluster != "" {
+		return cluster
+	}
+
+	return config.ClusterName
+}
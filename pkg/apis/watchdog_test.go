@@ -0,0 +1,127 @@
+package apis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/auth"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/filter"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/justinbarrick/fluxcloud/pkg/watchdog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchdog(t *testing.T) {
+	fakeExporter := &exporters.FakeExporter{}
+	apiConfig := newTestAPI(t, nil, fakeExporter)
+	apiConfig.Auth = auth.NewAuthenticator("", map[string]string{
+		"production": "production-token",
+		"staging":    "staging-token",
+	})
+	var err error
+	apiConfig.Watchdog, err = watchdog.NewWatchdog(time.Millisecond, 0, "", time.Now())
+	require.NoError(t, err)
+	HandleV6(apiConfig)
+
+	post := func(token string, remoteAddr string) {
+		data, _ := json.Marshal(test_utils.NewFluxSyncEvent())
+		req := httptest.NewRequest("POST", "http://127.0.0.1:3030/v6/events", bytes.NewBuffer(data))
+		req.Header.Set("Authorization", "Scope-Probe token="+token)
+		req.RemoteAddr = remoteAddr
+		response := httptest.NewRecorder()
+		apiConfig.Server.ServeHTTP(response, req)
+		assert.Equal(t, 200, response.Code)
+	}
+
+	post("production-token", "10.0.0.1:1234")
+	require.Len(t, fakeExporter.Sent, 1)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		RunWatchdog(apiConfig, 5*time.Millisecond, stop)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	<-done
+
+	// the cluster is only alerted once while it is quiet
+	require.Len(t, fakeExporter.Sent, 2)
+	assert.Equal(t, "stalled", fakeExporter.Sent[1].Type)
+	assert.Contains(t, fakeExporter.Sent[1].Title, "production")
+
+	// the daemon's pod restarted with a new address, its token still names
+	// the same cluster
+	post("production-token", "10.0.0.2:1234")
+	require.Len(t, fakeExporter.Sent, 4)
+	assert.Equal(t, "recovered", fakeExporter.Sent[3].Type)
+	require.Len(t, apiConfig.Watchdog.Clusters(), 1)
+	assert.Equal(t, "production", apiConfig.Watchdog.Clusters()[0].Name)
+
+	// another cluster from the same address is watched on its own
+	post("staging-token", "10.0.0.2:1234")
+	names := []string{}
+	for _, cluster := range apiConfig.Watchdog.Clusters() {
+		names = append(names, cluster.Name)
+	}
+	assert.ElementsMatch(t, []string{"production", "staging"}, names)
+}
+
+func TestWatchdogClusterName(t *testing.T) {
+	fakeExporter := &exporters.FakeExporter{}
+	apiConfig := newTestAPI(t, map[string]string{"cluster_name": "staging"}, fakeExporter)
+
+	var err error
+	apiConfig.Watchdog, err = watchdog.NewWatchdog(time.Hour, 0, "", time.Now())
+	require.NoError(t, err)
+	HandleV6(apiConfig)
+
+	// without cluster tokens every daemon is the configured cluster
+	for _, remoteAddr := range []string{"10.0.0.1:1234", "10.0.0.2:1234"} {
+		data, _ := json.Marshal(test_utils.NewFluxSyncEvent())
+		req := httptest.NewRequest("POST", "http://127.0.0.1:3030/v6/events", bytes.NewBuffer(data))
+		req.RemoteAddr = remoteAddr
+		response := httptest.NewRecorder()
+		apiConfig.Server.ServeHTTP(response, req)
+		assert.Equal(t, 200, response.Code)
+	}
+
+	require.Len(t, apiConfig.Watchdog.Clusters(), 1)
+	assert.Equal(t, "staging", apiConfig.Watchdog.Clusters()[0].Name)
+}
+
+func TestWatchdogIgnoresFilters(t *testing.T) {
+	fakeExporter := &exporters.FakeExporter{}
+	apiConfig := newTestAPI(t, map[string]string{
+		"exclude_event_types": "sync",
+		"include_namespaces":  "team-a",
+	}, fakeExporter)
+
+	var err error
+	apiConfig.Filter, err = filter.NewFilter(apiConfig.Config, "")
+	require.NoError(t, err)
+	require.NotNil(t, apiConfig.Filter)
+
+	now := time.Now()
+	apiConfig.Watchdog, err = watchdog.NewWatchdog(time.Minute, 0, "", now)
+	require.NoError(t, err)
+
+	_, err = apiConfig.Watchdog.Heartbeat("staging", now)
+	require.NoError(t, err)
+
+	alertsToSend, err := apiConfig.Watchdog.Check(now.Add(time.Hour))
+	require.NoError(t, err)
+
+	// the filters are meant for events, they do not hide a stalled cluster
+	sendWatchdogAlerts(context.Background(), apiConfig, alertsToSend)
+	require.Len(t, fakeExporter.Sent, 1)
+	assert.Equal(t, "stalled", fakeExporter.Sent[0].Type)
+}
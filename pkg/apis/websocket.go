@@ -1,45 +1,199 @@
 package apis
 
 import (
-	"github.com/gorilla/websocket"
-	"log"
+	"io"
 	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+	"github.com/weaveworks/flux/remote/rpc"
 )
 
+// How often connected daemons are pinged over RPC.
+var pingInterval = 30 * time.Second
+
+const (
+	// Time allowed to write a frame to the daemon.
+	writeWait = 10 * time.Second
+
+	// Time allowed between frames before the connection is considered dead,
+	// Flux pings its upstream well within this window.
+	readWait = 60 * time.Second
+)
+
+// Flux does not send an Origin header, so the default origin check only turns
+// away browsers on other sites.
 var upgrader = websocket.Upgrader{}
 
-// Handle Flux WebSocket connections
-func HandleWebsocket(config APIConfig) error {
-	config.Server.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		log.Print("Request for:", r.URL)
+// Handle Flux WebSocket connections.
+//
+// Flux dials its upstream and then serves its API as JSON-RPC over the
+// websocket, so fluxcloud acts as the RPC client: it asks the daemon for its
+// version when it connects and keeps pinging it for as long as the connection
+// is open. Events are still posted by the daemon to /v6/events.
+func HandleWebsocket(config APIConfig) {
+	sessions := config.Sessions
+	if sessions == nil {
+		sessions = NewSessions()
+	}
+
+	config.Server.HandleFunc("/", verified(config, "websocket", authenticated(config, "websocket", func(w http.ResponseWriter, r *http.Request) {
+		logging.Debug("Request", "url", r.URL)
 		c, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			log.Print("upgrade:", err)
+			logging.Warn("Could not upgrade websocket", "err", err)
 			return
 		}
+
+		config.apiMetrics.websocketConnected()
+		ws := newWebsocketConn(c)
+		session := sessions.Add(r, clusterName(config, r), rpc.NewClientV11(ws))
 		defer func() {
-			log.Println("client disconnected")
-			c.Close()
+			if config.Watchdog != nil {
+				if err := config.Watchdog.Disconnect(session.Cluster, time.Now()); err != nil {
+					logging.Error("Could not save watchdog state", "err", err)
+				}
+			}
+			sessions.Remove(session.ID)
+			ws.Close()
+			logging.Info("Client disconnected", "client", session.ID)
 		}()
 
-		log.Println("client connected!")
+		logging.Info("Client connected", "client", session.ID, "remote_addr", session.RemoteAddr, "user_agent", session.UserAgent)
+
+		version, err := session.Daemon.Version(r.Context())
+		if err != nil {
+			logging.Warn("Could not get client version", "client", session.ID, "err", err)
+			return
+		}
+
+		sessions.Update(session.ID, func(s *Session) {
+			s.Version = version
+		})
+		logging.Info("Client is running flux", "client", session.ID, "version", version)
+
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
 
 		for {
-			mt, message, err := c.ReadMessage()
-			if err != nil {
-				log.Println("read:", err)
-				break
+			if err := session.Daemon.Ping(r.Context()); err != nil {
+				logging.Warn("Could not ping client", "client", session.ID, "err", err)
+				return
 			}
 
-			log.Printf("recv: %s", message)
-			err = c.WriteMessage(mt, message)
+			now := time.Now()
+			sessions.Update(session.ID, func(s *Session) {
+				s.LastPing = now
+			})
+
+			if config.Watchdog != nil {
+				alertsToSend, err := config.Watchdog.Heartbeat(session.Cluster, now)
+				if err != nil {
+					logging.Error("Could not save watchdog state", "err", err)
+				}
 
-			if err != nil {
-				log.Println("write:", err)
-				break
+				sendWatchdogAlerts(r.Context(), config, alertsToSend)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ws.Done():
+				return
 			}
 		}
+	})))
+
+	config.Server.HandleFunc("/v6/sessions", verified(config, "/v6/sessions", authenticated(config, "/v6/sessions", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, 200, sessions.List())
+	})))
+}
+
+// Adapts a websocket connection to the byte stream used by Flux's RPC codec.
+// Each write is sent as its own binary frame, and reads consume frames in
+// order.
+type websocketConn struct {
+	conn      *websocket.Conn
+	reader    io.Reader
+	readLock  sync.Mutex
+	writeLock sync.Mutex
+	done      chan struct{}
+	doneOnce  sync.Once
+}
+
+func newWebsocketConn(c *websocket.Conn) *websocketConn {
+	c.SetReadDeadline(time.Now().Add(readWait))
+	c.SetPingHandler(func(data string) error {
+		c.SetReadDeadline(time.Now().Add(readWait))
+		return c.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait))
 	})
 
-	return nil
+	return &websocketConn{
+		conn: c,
+		done: make(chan struct{}),
+	}
+}
+
+// Returns a channel that is closed once the connection can no longer be read.
+func (w *websocketConn) Done() <-chan struct{} {
+	return w.done
+}
+
+func (w *websocketConn) Read(b []byte) (int, error) {
+	w.readLock.Lock()
+	defer w.readLock.Unlock()
+
+	for w.reader == nil {
+		msgType, r, err := w.conn.NextReader()
+		if err != nil {
+			w.doneOnce.Do(func() { close(w.done) })
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+
+		if msgType != websocket.BinaryMessage {
+			continue
+		}
+		w.reader = r
+	}
+
+	n, err := w.reader.Read(b)
+	if err == io.EOF {
+		w.reader = nil
+		err = nil
+	}
+	w.conn.SetReadDeadline(time.Now().Add(readWait))
+	return n, err
+}
+
+func (w *websocketConn) Write(b []byte) (int, error) {
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
+
+	if err := w.conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		return 0, err
+	}
+
+	writer, err := w.conn.NextWriter(websocket.BinaryMessage)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := writer.Write(b)
+	if err != nil {
+		return n, err
+	}
+
+	return n, writer.Close()
+}
+
+func (w *websocketConn) Close() error {
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
+
+	w.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "ok"), time.Now().Add(writeWait))
+	return w.conn.Close()
 }
@@ -0,0 +1,118 @@
+package apis
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/flux/remote"
+	"github.com/weaveworks/flux/remote/rpc"
+)
+
+func dialDaemon(t *testing.T, url string, daemon *remote.MockServer) *websocketConn {
+	header := http.Header{}
+	header.Set("User-Agent", "fluxd/1.12.0")
+
+	c, _, err := websocket.DefaultDialer.Dial(strings.Replace(url, "http", "ws", 1)+"/v10/daemon", header)
+	require.NoError(t, err)
+
+	server, err := rpc.NewServer(daemon)
+	require.NoError(t, err)
+
+	ws := newWebsocketConn(c)
+	go server.ServeConn(ws)
+	return ws
+}
+
+func listSessions(t *testing.T, url string) []Session {
+	resp, err := http.Get(url + "/v6/sessions")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	sessions := []Session{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&sessions))
+	return sessions
+}
+
+func TestHandleWebsocket(t *testing.T) {
+	apiConfig := NewAPIConfig(nil, nil, config.NewFakeConfig())
+	HandleWebsocket(apiConfig)
+
+	apiServer := httptest.NewServer(apiConfig.Server)
+	defer apiServer.Close()
+
+	ws := dialDaemon(t, apiServer.URL, &remote.MockServer{VersionAnswer: "1.12.0"})
+
+	var sessions []Session
+	for i := 0; i < 50; i++ {
+		sessions = listSessions(t, apiServer.URL)
+		if len(sessions) == 1 && !sessions[0].LastPing.IsZero() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "1.12.0", sessions[0].Version)
+	assert.Equal(t, "fluxd/1.12.0", sessions[0].UserAgent)
+	assert.False(t, sessions[0].LastPing.IsZero())
+
+	session, ok := apiConfig.Sessions.Get(sessions[0].ID)
+	require.True(t, ok)
+	assert.Nil(t, session.Daemon.Ping(context.TODO()))
+
+	ws.Close()
+
+	for i := 0; i < 50 && len(sessions) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		sessions = listSessions(t, apiServer.URL)
+	}
+
+	assert.Len(t, sessions, 0)
+}
+
+func TestHandleWebsocketVersionError(t *testing.T) {
+	apiConfig := NewAPIConfig(nil, nil, config.NewFakeConfig())
+	HandleWebsocket(apiConfig)
+
+	apiServer := httptest.NewServer(apiConfig.Server)
+	defer apiServer.Close()
+
+	dialDaemon(t, apiServer.URL, &remote.MockServer{VersionError: assert.AnError})
+
+	var sessions []Session
+	for i := 0; i < 50; i++ {
+		time.Sleep(10 * time.Millisecond)
+		sessions = listSessions(t, apiServer.URL)
+		if len(sessions) == 0 {
+			break
+		}
+	}
+
+	assert.Len(t, sessions, 0)
+}
+
+func TestHandleWebsocketCrossOrigin(t *testing.T) {
+	apiConfig := NewAPIConfig(nil, nil, config.NewFakeConfig())
+	HandleWebsocket(apiConfig)
+
+	apiServer := httptest.NewServer(apiConfig.Server)
+	defer apiServer.Close()
+
+	header := http.Header{}
+	header.Set("Origin", "https://evil.example.com")
+
+	_, resp, err := websocket.DefaultDialer.Dial(strings.Replace(apiServer.URL, "http", "ws", 1)+"/v10/daemon", header)
+	assert.Error(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, 403, resp.StatusCode)
+	assert.Empty(t, listSessions(t, apiServer.URL))
+}
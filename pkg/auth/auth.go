@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+)
+
+// Why a request was not authenticated.
+const (
+	ReasonMissing = "missing"
+	ReasonInvalid = "invalid"
+)
+
+// Authenticates requests from Flux daemons by the token that they send with
+// fluxd's --token flag, as `Authorization: Scope-Probe token=<token>`, or as a
+// bearer token. Requests may use the shared token or a cluster's own token,
+// which identifies the cluster that sent them.
+type Authenticator struct {
+	token    string
+	clusters map[string]string
+}
+
+// Create an authenticator that accepts the shared token and the tokens of
+// each cluster, keyed by cluster name. Either may be empty.
+func NewAuthenticator(token string, clusterTokens map[string]string) *Authenticator {
+	a := &Authenticator{
+		token:    token,
+		clusters: map[string]string{},
+	}
+
+	for cluster, clusterToken := range clusterTokens {
+		a.clusters[cluster] = clusterToken
+	}
+
+	logging.Secret(token)
+	for _, clusterToken := range a.clusters {
+		logging.Secret(clusterToken)
+	}
+
+	return a
+}
+
+// Load an authenticator from the auth_token and auth_cluster_tokens settings,
+// the latter a comma separated list of cluster=token pairs. Returns nil if
+// neither is set.
+func Load(config config.Config) (*Authenticator, error) {
+	token := config.Optional("auth_token", "")
+
+	clusterTokens := map[string]string{}
+	for _, pair := range strings.Split(config.Optional("auth_cluster_tokens", ""), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("Invalid setting AUTH_CLUSTER_TOKENS: expected cluster=token pairs")
+		}
+
+		cluster := strings.TrimSpace(parts[0])
+		if _, ok := clusterTokens[cluster]; ok {
+			return nil, fmt.Errorf("Invalid setting AUTH_CLUSTER_TOKENS: cluster %s configured more than once", cluster)
+		}
+
+		clusterTokens[cluster] = strings.TrimSpace(parts[1])
+	}
+
+	if token == "" && len(clusterTokens) == 0 {
+		return nil, nil
+	}
+
+	return NewAuthenticator(token, clusterTokens), nil
+}
+
+// Authenticate a request, returning the cluster that its token belongs to, or
+// an empty cluster for the shared token. If the request is not authenticated
+// the reason is returned instead. A nil authenticator accepts every request.
+func (a *Authenticator) Authenticate(r *http.Request) (cluster string, ok bool, reason string) {
+	if a == nil {
+		return "", true, ""
+	}
+
+	token := Token(r)
+	if token == "" {
+		return "", false, ReasonMissing
+	}
+
+	// compare against every token so that the time taken does not reveal
+	// which one matched
+	matched := false
+	for name, clusterToken := range a.clusters {
+		if equal(token, clusterToken) {
+			cluster = name
+			matched = true
+		}
+	}
+
+	if a.token != "" && equal(token, a.token) {
+		matched = true
+	}
+
+	if !matched {
+		return "", false, ReasonInvalid
+	}
+
+	return cluster, true, ""
+}
+
+// Return the token sent with a request, from either the Scope-Probe
+// authorization scheme used by fluxd or a bearer token.
+func Token(r *http.Request) string {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+
+	credentials := strings.TrimSpace(parts[1])
+	switch strings.ToLower(parts[0]) {
+	case "scope-probe":
+		if strings.HasPrefix(credentials, "token=") {
+			return strings.TrimPrefix(credentials, "token=")
+		}
+	case "bearer":
+		return credentials
+	}
+
+	return ""
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
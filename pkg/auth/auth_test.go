@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func request(authorization string) *http.Request {
+	r, _ := http.NewRequest("POST", "http://fluxcloud/v6/events", nil)
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	return r
+}
+
+func TestToken(t *testing.T) {
+	assert.Equal(t, "abc", Token(request("Scope-Probe token=abc")))
+	assert.Equal(t, "abc", Token(request("Bearer abc")))
+	assert.Equal(t, "abc", Token(request("bearer  abc")))
+	assert.Equal(t, "", Token(request("Basic dXNlcjpwYXNz")))
+	assert.Equal(t, "", Token(request("Scope-Probe abc")))
+	assert.Equal(t, "", Token(request("")))
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := NewAuthenticator("shared-token", map[string]string{
+		"production": "production-token",
+		"staging":    "staging-token",
+	})
+
+	cluster, ok, _ := a.Authenticate(request("Scope-Probe token=shared-token"))
+	assert.True(t, ok)
+	assert.Equal(t, "", cluster)
+
+	cluster, ok, _ = a.Authenticate(request("Scope-Probe token=production-token"))
+	assert.True(t, ok)
+	assert.Equal(t, "production", cluster)
+
+	cluster, ok, _ = a.Authenticate(request("Bearer staging-token"))
+	assert.True(t, ok)
+	assert.Equal(t, "staging", cluster)
+
+	_, ok, reason := a.Authenticate(request("Scope-Probe token=wrong"))
+	assert.False(t, ok)
+	assert.Equal(t, ReasonInvalid, reason)
+
+	_, ok, reason = a.Authenticate(request(""))
+	assert.False(t, ok)
+	assert.Equal(t, ReasonMissing, reason)
+}
+
+func TestAuthenticateClusterTokensOnly(t *testing.T) {
+	a := NewAuthenticator("", map[string]string{"production": "production-token"})
+
+	_, ok, _ := a.Authenticate(request("Bearer production-token"))
+	assert.True(t, ok)
+
+	// an empty shared token is never accepted
+	_, ok, reason := a.Authenticate(request("Bearer "))
+	assert.False(t, ok)
+	assert.Equal(t, ReasonMissing, reason)
+}
+
+func TestAuthenticateNil(t *testing.T) {
+	var a *Authenticator
+
+	cluster, ok, _ := a.Authenticate(request(""))
+	assert.True(t, ok)
+	assert.Equal(t, "", cluster)
+}
+
+func TestLoad(t *testing.T) {
+	c := config.NewFakeConfig()
+
+	a, err := Load(c)
+	require.Nil(t, err)
+	assert.Nil(t, a)
+
+	c.Set("auth_token", "shared-token")
+	c.Set("auth_cluster_tokens", "production=production-token, staging=staging-token")
+
+	a, err = Load(c)
+	require.Nil(t, err)
+	require.NotNil(t, a)
+
+	cluster, ok, _ := a.Authenticate(request("Bearer staging-token"))
+	assert.True(t, ok)
+	assert.Equal(t, "staging", cluster)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for _, value := range []string{"production", "production=", "=token", "a=1,a=2"} {
+		c := config.NewFakeConfig()
+		c.Set("auth_cluster_tokens", value)
+
+		_, err := Load(c)
+		assert.NotNil(t, err, value)
+	}
+}
@@ -0,0 +1,173 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+)
+
+// Loads a certificate and key, and optionally a CA bundle used to verify
+// client certificates, from files. The files are loaded again when they
+// change so that rotated certificates, such as the ones written by
+// cert-manager to a mounted secret, are served without restarting.
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	lock      sync.RWMutex
+	certPEM   []byte
+	keyPEM    []byte
+	caPEM     []byte
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// Load the certificate and key from certFile and keyFile. If clientCAFile is
+// set, client certificates are verified against its CAs.
+func NewReloader(certFile, keyFile, clientCAFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Load the files again if they changed, returning whether they did. If the
+// new files are invalid the previous certificate is kept.
+func (r *Reloader) Reload() (bool, error) {
+	certPEM, err := ioutil.ReadFile(r.certFile)
+	if err != nil {
+		return false, err
+	}
+
+	keyPEM, err := ioutil.ReadFile(r.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	var caPEM []byte
+	if r.clientCAFile != "" {
+		caPEM, err = ioutil.ReadFile(r.clientCAFile)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	r.lock.RLock()
+	unchanged := r.cert != nil && bytes.Equal(certPEM, r.certPEM) && bytes.Equal(keyPEM, r.keyPEM) && bytes.Equal(caPEM, r.caPEM)
+	r.lock.RUnlock()
+
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, fmt.Errorf("Could not load certificate %s: %s", r.certFile, err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			return false, fmt.Errorf("Could not load client CA bundle %s: no certificates found", r.clientCAFile)
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.certPEM = certPEM
+	r.keyPEM = keyPEM
+	r.caPEM = caPEM
+	r.cert = &cert
+	r.clientCAs = clientCAs
+
+	return true, nil
+}
+
+// Check the files for changes every interval until stop is closed.
+func (r *Reloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			changed, err := r.Reload()
+			if err != nil {
+				logging.Error("Could not reload TLS certificate, keeping the previous one", "err", err)
+			} else if changed {
+				logging.Info("Reloaded TLS certificate", "cert", r.certFile)
+			}
+		}
+	}
+}
+
+// Return the current certificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.cert, nil
+}
+
+// Return whether a request may be served: either no client CA bundle is
+// configured, or the client presented a certificate signed by one of its CAs.
+func (r *Reloader) Verified(req *http.Request) bool {
+	if r == nil {
+		return true
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if r.clientCAs == nil {
+		return true
+	}
+
+	return req.TLS != nil && len(req.TLS.VerifiedChains) > 0
+}
+
+// Return a TLS configuration that serves the current certificate, and
+// verifies client certificates against the current CA bundle if one is
+// configured. Clients without a certificate can still connect so that probes
+// and metrics scrapes work, handlers that need one check Verified.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.lock.RLock()
+			defer r.lock.RUnlock()
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+			}
+
+			if r.clientCAs != nil {
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+				config.ClientCAs = r.clientCAs
+			}
+
+			return config, nil
+		},
+	}
+}
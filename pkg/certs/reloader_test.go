@@ -0,0 +1,203 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// Create a certificate for name signed by parent, or a self-signed CA if
+// parent is nil.
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeCert(t *testing.T, dir string, cert *testCert) (string, string) {
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	require.NoError(t, ioutil.WriteFile(certFile, cert.certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, cert.keyPEM, 0600))
+	return certFile, keyFile
+}
+
+func serve(t *testing.T, reloader *Reloader) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !reloader.Verified(r) {
+			w.WriteHeader(401)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	server.TLS = reloader.TLSConfig()
+	server.StartTLS()
+	return server
+}
+
+func client(ca *testCert, cert *testCert) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	config := &tls.Config{RootCAs: roots}
+	if cert != nil {
+		pair, _ := tls.X509KeyPair(cert.certPEM, cert.keyPEM)
+		config.Certificates = []tls.Certificate{pair}
+	}
+
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+}
+
+func TestReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fluxcloud-certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	first := newTestCert(t, "first", ca)
+	certFile, keyFile := writeCert(t, dir, first)
+
+	reloader, err := NewReloader(certFile, keyFile, "")
+	require.NoError(t, err)
+
+	server := serve(t, reloader)
+	defer server.Close()
+
+	res, err := client(ca, nil).Get(server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "first", res.TLS.PeerCertificates[0].Subject.CommonName)
+
+	changed, err := reloader.Reload()
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	second := newTestCert(t, "second", ca)
+	writeCert(t, dir, second)
+
+	changed, err = reloader.Reload()
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	res, err = client(ca, nil).Get(server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "second", res.TLS.PeerCertificates[0].Subject.CommonName)
+
+	// an invalid certificate does not replace the current one
+	require.NoError(t, ioutil.WriteFile(certFile, []byte("invalid"), 0600))
+	_, err = reloader.Reload()
+	assert.NotNil(t, err)
+
+	res, err = client(ca, nil).Get(server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "second", res.TLS.PeerCertificates[0].Subject.CommonName)
+}
+
+func TestReloaderClientCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fluxcloud-certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	certFile, keyFile := writeCert(t, dir, newTestCert(t, "fluxcloud", ca))
+
+	clientCA := newTestCert(t, "client-ca", nil)
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, ioutil.WriteFile(caFile, clientCA.certPEM, 0600))
+
+	reloader, err := NewReloader(certFile, keyFile, caFile)
+	require.NoError(t, err)
+
+	server := serve(t, reloader)
+	defer server.Close()
+
+	// clients without a certificate from the bundle connect, but are not
+	// verified
+	res, err := client(ca, nil).Get(server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, 401, res.StatusCode)
+
+	res, err = client(ca, newTestCert(t, "fluxd", ca)).Get(server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, 401, res.StatusCode)
+
+	res, err = client(ca, newTestCert(t, "fluxd", clientCA)).Get(server.URL)
+	require.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+}
+
+func TestNewReloaderInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fluxcloud-certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = NewReloader(filepath.Join(dir, "missing.crt"), filepath.Join(dir, "missing.key"), "")
+	assert.NotNil(t, err)
+
+	certFile, keyFile := writeCert(t, dir, newTestCert(t, "fluxcloud", nil))
+
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, ioutil.WriteFile(caFile, []byte("not a certificate"), 0600))
+
+	_, err = NewReloader(certFile, keyFile, caFile)
+	assert.NotNil(t, err)
+}
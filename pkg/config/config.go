@@ -8,4 +8,9 @@ type Config interface {
 
 	// Get a required setting by name.
 	Required(key string) (string, error)
+
+	// Get an optional credential, such as a token or password, by name. Unlike
+	// other settings, the credentials of a named exporter instance do not fall
+	// back to the unprefixed setting, so they are only sent to its endpoint.
+	Credential(key string, defaultValue string) string
 }
@@ -21,6 +21,10 @@ func (d *DefaultConfig) Optional(key string, defaultValue string) string {
 	return value
 }
 
+func (d *DefaultConfig) Credential(key string, defaultValue string) string {
+	return d.Optional(key, defaultValue)
+}
+
 func (d *DefaultConfig) Required(key string) (string, error) {
 	key = strings.ToUpper(key)
 
@@ -25,6 +25,10 @@ func (t *FakeConfig) Optional(key string, defaultValue string) string {
 	return value
 }
 
+func (t *FakeConfig) Credential(key string, defaultValue string) string {
+	return t.Optional(key, defaultValue)
+}
+
 func (t *FakeConfig) Required(key string) (string, error) {
 	value := t.settings[strings.ToUpper(key)]
 	if value == "" {
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"strings"
+)
+
+// A configuration that reads settings with a prefix, used to configure several
+// instances of the same exporter. Optional settings that are not set with the
+// prefix fall back to the unprefixed setting, required settings and
+// credentials do not.
+type PrefixedConfig struct {
+	config Config
+	prefix string
+}
+
+// Wrap a configuration so that every key is read as PREFIX_KEY.
+func NewPrefixedConfig(config Config, prefix string) *PrefixedConfig {
+	return &PrefixedConfig{
+		config: config,
+		prefix: prefix,
+	}
+}
+
+func (p *PrefixedConfig) key(key string) string {
+	return strings.ToUpper(p.prefix + "_" + key)
+}
+
+func (p *PrefixedConfig) Optional(key string, defaultValue string) string {
+	return p.config.Optional(p.key(key), p.config.Optional(key, defaultValue))
+}
+
+func (p *PrefixedConfig) Required(key string) (string, error) {
+	return p.config.Required(p.key(key))
+}
+
+func (p *PrefixedConfig) Credential(key string, defaultValue string) string {
+	return p.config.Credential(p.key(key), defaultValue)
+}
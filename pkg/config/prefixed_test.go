@@ -0,0 +1,50 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixedConfigImplementsConfig(t *testing.T) {
+	_ = Config(&PrefixedConfig{})
+}
+
+func TestPrefixedConfigOptional(t *testing.T) {
+	config := NewFakeConfig()
+	prefixed := NewPrefixedConfig(config, "ops")
+
+	assert.Equal(t, "hello", prefixed.Optional("slack_username", "hello"))
+
+	config.Set("slack_username", "shared")
+	assert.Equal(t, "shared", prefixed.Optional("slack_username", "hello"))
+
+	config.Set("ops_slack_username", "ops")
+	assert.Equal(t, "ops", prefixed.Optional("slack_username", "hello"))
+}
+
+func TestPrefixedConfigCredential(t *testing.T) {
+	config := NewFakeConfig()
+	prefixed := NewPrefixedConfig(config, "ops")
+
+	config.Set("slack_token", "shared")
+	assert.Equal(t, "shared", config.Credential("slack_token", ""))
+	assert.Equal(t, "", prefixed.Credential("slack_token", ""))
+
+	config.Set("ops_slack_token", "ops")
+	assert.Equal(t, "ops", prefixed.Credential("slack_token", ""))
+}
+
+func TestPrefixedConfigRequired(t *testing.T) {
+	config := NewFakeConfig()
+	prefixed := NewPrefixedConfig(config, "ops")
+
+	config.Set("slack_url", "https://shared/")
+	_, err := prefixed.Required("slack_url")
+	assert.EqualError(t, err, "Required setting OPS_SLACK_URL not set")
+
+	config.Set("ops_slack_url", "https://ops/")
+	url, err := prefixed.Required("slack_url")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://ops/", url)
+}
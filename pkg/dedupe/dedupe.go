@@ -0,0 +1,185 @@
+package dedupe
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+// Remembers which events were announced to which destinations so that the
+// same change is only announced once within a suppression window. If a file is
+// set, what has been announced is saved to it so that it survives restarts.
+type Deduplicator struct {
+	window time.Duration
+	file   string
+	lock   sync.Mutex
+	seen   map[string]time.Time
+
+	// Destinations that an event is being sent to, which are not saved.
+	pending map[string]time.Time
+}
+
+// Create a deduplicator that suppresses repeats within window, loading what
+// was previously announced from file if it is set.
+func NewDeduplicator(window time.Duration, file string) (*Deduplicator, error) {
+	d := &Deduplicator{
+		window:  window,
+		file:    file,
+		seen:    map[string]time.Time{},
+		pending: map[string]time.Time{},
+	}
+
+	if err := utils.LoadJSON(file, &d.seen, "deduplication state"); err != nil {
+		return nil, err
+	}
+
+	// a state file holding null leaves no map to record to
+	if d.seen == nil {
+		d.seen = map[string]time.Time{}
+	}
+
+	d.prune(time.Now())
+	return d, nil
+}
+
+// Return the key identifying an event from a cluster: its ID and a hash of
+// the cluster and its type, resources, commits and errors, so that re-sent
+// copies of an event have the same key but the same change synced by two
+// clusters does not.
+func Key(cluster string, event fluxevent.Event) string {
+	ids := []string{}
+	for _, id := range event.ServiceIDs {
+		ids = append(ids, id.String())
+	}
+	sort.Strings(ids)
+
+	commits := []string{}
+	for _, commit := range utils.GetCommits(event.Metadata) {
+		commits = append(commits, commit.Revision)
+	}
+
+	errors := []string{}
+	for _, resourceError := range utils.GetErrors(event.Metadata) {
+		errors = append(errors, fmt.Sprintf("%s: %s", resourceError.ID, resourceError.Error))
+	}
+	sort.Strings(errors)
+
+	hash := sha256.New()
+	for _, part := range [][]string{{cluster}, {event.Type}, ids, commits, errors} {
+		fmt.Fprintf(hash, "%s\n", strings.Join(part, ","))
+	}
+
+	return fmt.Sprintf("%d-%x", event.ID, hash.Sum(nil))
+}
+
+// Return the destinations that have not been sent the event with key by an
+// exporter within the window, and false if there are none. An empty list of
+// destinations stands for the exporter's configured destination.
+//
+// The destinations returned are reserved until they are marked as sent with
+// Mark or released with Release, so that a copy of the event that arrives
+// while the event is being sent is filtered out too.
+func (d *Deduplicator) Filter(key string, exporter string, destinations []string, now time.Time) ([]string, bool) {
+	if d == nil {
+		return destinations, true
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if len(destinations) == 0 {
+		if d.seenAt(key, exporter, "", now) {
+			return destinations, false
+		}
+
+		d.pending[seenKey(key, exporter, "")] = now
+		return destinations, true
+	}
+
+	remaining := []string{}
+	for _, destination := range destinations {
+		if !d.seenAt(key, exporter, destination, now) {
+			d.pending[seenKey(key, exporter, destination)] = now
+			remaining = append(remaining, destination)
+		}
+	}
+
+	return remaining, len(remaining) > 0
+}
+
+// Record that an exporter sent the event with key to destinations.
+func (d *Deduplicator) Mark(key string, exporter string, destinations []string, now time.Time) error {
+	if d == nil {
+		return nil
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, destination := range destinationsOrDefault(destinations) {
+		d.seen[seenKey(key, exporter, destination)] = now
+		delete(d.pending, seenKey(key, exporter, destination))
+	}
+
+	d.prune(now)
+	return d.save()
+}
+
+// Release the destinations that Filter reserved for the event with key when
+// the exporter could not send it, so that it can be sent again.
+func (d *Deduplicator) Release(key string, exporter string, destinations []string) {
+	if d == nil {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, destination := range destinationsOrDefault(destinations) {
+		delete(d.pending, seenKey(key, exporter, destination))
+	}
+}
+
+func (d *Deduplicator) seenAt(key string, exporter string, destination string, now time.Time) bool {
+	for _, seen := range []map[string]time.Time{d.seen, d.pending} {
+		if at, ok := seen[seenKey(key, exporter, destination)]; ok && now.Sub(at) < d.window {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Forget events that were sent, or reserved, before the window.
+func (d *Deduplicator) prune(now time.Time) {
+	for _, seen := range []map[string]time.Time{d.seen, d.pending} {
+		for key, at := range seen {
+			if now.Sub(at) >= d.window {
+				delete(seen, key)
+			}
+		}
+	}
+}
+
+func (d *Deduplicator) save() error {
+	return utils.SaveJSON(d.file, d.seen)
+}
+
+// An empty list of destinations stands for the configured destination.
+func destinationsOrDefault(destinations []string) []string {
+	if len(destinations) == 0 {
+		return []string{""}
+	}
+
+	return destinations
+}
+
+func seenKey(key string, exporter string, destination string) string {
+	return strings.Join([]string{key, exporter, destination}, "|")
+}
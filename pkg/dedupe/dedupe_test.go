@@ -0,0 +1,166 @@
+package dedupe
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKey(t *testing.T) {
+	event := test_utils.NewFluxSyncEvent()
+	assert.Equal(t, Key("production", event), Key("production", test_utils.NewFluxSyncEvent()))
+
+	event.StartedAt = event.StartedAt.Add(time.Minute)
+	assert.Equal(t, Key("production", test_utils.NewFluxSyncEvent()), Key("production", event))
+
+	assert.NotEqual(t, Key("production", test_utils.NewFluxSyncEvent()), Key("production", test_utils.NewFluxSyncErrorEvent()))
+
+	event.ID = 5
+	assert.NotEqual(t, Key("production", test_utils.NewFluxSyncEvent()), Key("production", event))
+}
+
+func TestKeyPerCluster(t *testing.T) {
+	event := test_utils.NewFluxSyncEvent()
+	assert.NotEqual(t, Key("production", event), Key("staging", event))
+}
+
+func TestNilDeduplicator(t *testing.T) {
+	var d *Deduplicator
+
+	destinations, ok := d.Filter("key", "Slack", []string{"#one"}, time.Now())
+	assert.True(t, ok)
+	assert.Equal(t, []string{"#one"}, destinations)
+	assert.Nil(t, d.Mark("key", "Slack", nil, time.Now()))
+	d.Release("key", "Slack", nil)
+}
+
+func TestDeduplicatorWindow(t *testing.T) {
+	d, err := NewDeduplicator(time.Minute, "")
+	require.Nil(t, err)
+
+	now := time.Now()
+
+	_, ok := d.Filter("key", "Slack", nil, now)
+	assert.True(t, ok)
+
+	require.Nil(t, d.Mark("key", "Slack", nil, now))
+
+	_, ok = d.Filter("key", "Slack", nil, now.Add(30*time.Second))
+	assert.False(t, ok)
+
+	_, ok = d.Filter("key", "MS Teams", nil, now.Add(30*time.Second))
+	assert.True(t, ok)
+
+	_, ok = d.Filter("other", "Slack", nil, now.Add(30*time.Second))
+	assert.True(t, ok)
+
+	_, ok = d.Filter("key", "Slack", nil, now.Add(time.Minute))
+	assert.True(t, ok)
+}
+
+func TestDeduplicatorDestinations(t *testing.T) {
+	d, err := NewDeduplicator(time.Minute, "")
+	require.Nil(t, err)
+
+	now := time.Now()
+	require.Nil(t, d.Mark("key", "Slack", []string{"#one"}, now))
+
+	destinations, ok := d.Filter("key", "Slack", []string{"#one", "#two"}, now)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"#two"}, destinations)
+
+	_, ok = d.Filter("key", "Slack", []string{"#one"}, now)
+	assert.False(t, ok)
+}
+
+func TestDeduplicatorReserves(t *testing.T) {
+	d, err := NewDeduplicator(time.Minute, "")
+	require.Nil(t, err)
+
+	now := time.Now()
+
+	destinations, ok := d.Filter("key", "Slack", []string{"#one", "#two"}, now)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"#one", "#two"}, destinations)
+
+	// a copy that arrives while the event is being sent
+	_, ok = d.Filter("key", "Slack", []string{"#one", "#two"}, now)
+	assert.False(t, ok)
+
+	// sending to #one failed
+	require.Nil(t, d.Mark("key", "Slack", []string{"#two"}, now))
+	d.Release("key", "Slack", []string{"#one"})
+
+	destinations, ok = d.Filter("key", "Slack", []string{"#one", "#two"}, now)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"#one"}, destinations)
+}
+
+func TestDeduplicatorReservationExpires(t *testing.T) {
+	d, err := NewDeduplicator(time.Minute, "")
+	require.Nil(t, err)
+
+	now := time.Now()
+
+	_, ok := d.Filter("key", "Slack", nil, now)
+	assert.True(t, ok)
+
+	_, ok = d.Filter("key", "Slack", nil, now.Add(time.Minute))
+	assert.True(t, ok)
+}
+
+func TestDeduplicatorPersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedupe")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "dedupe.json")
+	now := time.Now()
+
+	d, err := NewDeduplicator(time.Hour, file)
+	require.Nil(t, err)
+	require.Nil(t, d.Mark("key", "Slack", nil, now))
+
+	d, err = NewDeduplicator(time.Hour, file)
+	require.Nil(t, err)
+
+	_, ok := d.Filter("key", "Slack", nil, now)
+	assert.False(t, ok)
+}
+
+func TestDeduplicatorUnreadableFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedupe")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "dedupe.json")
+	require.Nil(t, ioutil.WriteFile(file, []byte("{"), 0600))
+
+	d, err := NewDeduplicator(time.Hour, file)
+	require.Nil(t, err)
+
+	_, ok := d.Filter("key", "Slack", nil, time.Now())
+	assert.True(t, ok)
+}
+
+func TestDeduplicatorNullFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedupe")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "dedupe.json")
+	require.Nil(t, ioutil.WriteFile(file, []byte("null"), 0600))
+
+	d, err := NewDeduplicator(time.Hour, file)
+	require.Nil(t, err)
+	require.Nil(t, d.Mark("key", "Slack", nil, time.Now()))
+
+	_, ok := d.Filter("key", "Slack", nil, time.Now())
+	assert.False(t, ok)
+}
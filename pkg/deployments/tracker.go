@@ -0,0 +1,373 @@
+package deployments
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
+	"github.com/weaveworks/flux"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+// The states that a deployment moves through.
+const (
+	// Flux pushed a commit with the change.
+	StateCommitted = "committed"
+
+	// Flux applied the commit to the cluster.
+	StateSynced = "synced"
+
+	// Flux released new images for the commit.
+	StateReleased = "released"
+
+	// Applying or releasing the change failed.
+	StateFailed = "failed"
+)
+
+var stateOrder = map[string]int{
+	StateCommitted: 0,
+	StateSynced:    1,
+	StateReleased:  2,
+}
+
+// A change that Flux is rolling out, correlated from the events about its
+// revision and resources.
+type Deployment struct {
+	Cluster       string                    `json:"cluster,omitempty"`
+	Revision      string                    `json:"revision"`
+	State         string                    `json:"state"`
+	Resources     []flux.ResourceID         `json:"resources"`
+	Commits       []fluxevent.Commit        `json:"commits"`
+	ChangedImages []string                  `json:"changedImages"`
+	Errors        []fluxevent.ResourceError `json:"errors"`
+	Stages        []Stage                   `json:"stages"`
+	Started       time.Time                 `json:"started"`
+	Updated       time.Time                 `json:"updated"`
+}
+
+// A state that a deployment reached and the event that moved it there.
+type Stage struct {
+	State     string    `json:"state"`
+	EventType string    `json:"eventType"`
+	Time      time.Time `json:"time"`
+}
+
+// Correlates commit, sync and release events into deployments. Deployments
+// are forgotten once they have not changed for the ttl, and if a file is set
+// they are saved to it so that they survive restarts.
+type Tracker struct {
+	ttl         time.Duration
+	file        string
+	lock        sync.Mutex
+	deployments map[string]*Deployment
+}
+
+// Create a tracker that keeps deployments for ttl, loading the deployments
+// saved in file if it is set.
+func NewTracker(ttl time.Duration, file string) (*Tracker, error) {
+	t := &Tracker{
+		ttl:         ttl,
+		file:        file,
+		deployments: map[string]*Deployment{},
+	}
+
+	if err := utils.LoadJSON(file, &t.deployments, "deployment state"); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Update the deployments that an event from a cluster is about, returning
+// them. Events that are not about a revision, such as syncs without commits,
+// return none.
+func (t *Tracker) Track(cluster string, event fluxevent.Event, now time.Time) ([]Deployment, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.expire(now)
+
+	var updated []*Deployment
+
+	switch metadata := event.Metadata.(type) {
+	case *fluxevent.CommitEventMetadata:
+		if metadata.Revision == "" {
+			return nil, nil
+		}
+
+		deployment := t.deployment(cluster, metadata.Revision, now)
+		deployment.addResources(event.ServiceIDs)
+		deployment.addCommits([]fluxevent.Commit{{Revision: metadata.Revision}})
+		deployment.advance(StateCommitted, event.Type, now)
+		updated = append(updated, deployment)
+	case *fluxevent.SyncEventMetadata:
+		updated = t.trackSync(cluster, event, metadata, now)
+	case *fluxevent.ReleaseEventMetadata:
+		updated = t.trackRelease(cluster, event, metadata.ReleaseEventCommon, now)
+	case *fluxevent.AutoReleaseEventMetadata:
+		updated = t.trackRelease(cluster, event, metadata.ReleaseEventCommon, now)
+	}
+
+	if len(updated) == 0 {
+		return nil, nil
+	}
+
+	deployments := []Deployment{}
+	for _, deployment := range updated {
+		deployments = append(deployments, deployment.copy())
+	}
+
+	return deployments, t.save()
+}
+
+// Return all of the deployments that are being tracked, oldest first.
+func (t *Tracker) Deployments() []Deployment {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	deployments := []Deployment{}
+	for _, deployment := range t.deployments {
+		deployments = append(deployments, deployment.copy())
+	}
+
+	sort.Slice(deployments, func(i, j int) bool {
+		return deployments[i].Started.Before(deployments[j].Started)
+	})
+
+	return deployments
+}
+
+// A sync moves every deployment of its commits forward. If Flux did not push
+// any of the commits itself they start a new deployment of the newest commit.
+func (t *Tracker) trackSync(cluster string, event fluxevent.Event, metadata *fluxevent.SyncEventMetadata, now time.Time) []*Deployment {
+	if len(metadata.Commits) == 0 {
+		return nil
+	}
+
+	var updated []*Deployment
+	for _, commit := range metadata.Commits {
+		if deployment, ok := t.deployments[utils.ClusterKey(cluster, commit.Revision)]; ok {
+			deployment.addCommits([]fluxevent.Commit{commit})
+			if len(deployment.Resources) == 0 {
+				deployment.addResources(event.ServiceIDs)
+			}
+			updated = append(updated, deployment)
+		}
+	}
+
+	if len(updated) == 0 {
+		deployment := t.deployment(cluster, metadata.Commits[0].Revision, now)
+		deployment.addCommits(metadata.Commits)
+		deployment.addResources(utils.GetResourceIDs(event))
+		updated = append(updated, deployment)
+	}
+
+	for _, deployment := range updated {
+		deployment.Errors = nil
+		for _, resourceError := range metadata.Errors {
+			if len(updated) == 1 || deployment.hasResource(resourceError.ID) {
+				deployment.Errors = append(deployment.Errors, resourceError)
+			}
+		}
+
+		if len(deployment.Errors) > 0 {
+			deployment.advance(StateFailed, event.Type, now)
+		} else {
+			deployment.advance(StateSynced, event.Type, now)
+		}
+	}
+
+	return updated
+}
+
+// A release belongs to the deployment of its revision, or to the newest
+// deployment of one of its resources.
+func (t *Tracker) trackRelease(cluster string, event fluxevent.Event, release fluxevent.ReleaseEventCommon, now time.Time) []*Deployment {
+	deployment, ok := t.deployments[utils.ClusterKey(cluster, release.Revision)]
+	if !ok {
+		for _, candidate := range t.deployments {
+			if candidate.Cluster != cluster || !candidate.hasAnyResource(event.ServiceIDs) {
+				continue
+			}
+
+			if deployment == nil || candidate.Updated.After(deployment.Updated) {
+				deployment = candidate
+			}
+		}
+	}
+
+	if deployment == nil {
+		if release.Revision == "" {
+			return nil
+		}
+
+		deployment = t.deployment(cluster, release.Revision, now)
+	}
+
+	deployment.addResources(event.ServiceIDs)
+	for _, image := range release.Result.ChangedImages() {
+		deployment.ChangedImages = appendIfMissing(deployment.ChangedImages, image)
+	}
+
+	if release.Error != "" {
+		deployment.advance(StateFailed, event.Type, now)
+	} else {
+		deployment.advance(StateReleased, event.Type, now)
+	}
+
+	return []*Deployment{deployment}
+}
+
+// Return the deployment of a revision to a cluster, starting it if it is new.
+func (t *Tracker) deployment(cluster string, revision string, now time.Time) *Deployment {
+	key := utils.ClusterKey(cluster, revision)
+
+	deployment, ok := t.deployments[key]
+	if !ok {
+		deployment = &Deployment{
+			Cluster:  cluster,
+			Revision: revision,
+			Started:  now,
+		}
+		t.deployments[key] = deployment
+	}
+
+	return deployment
+}
+
+func (t *Tracker) expire(now time.Time) {
+	if t.ttl <= 0 {
+		return
+	}
+
+	for key, deployment := range t.deployments {
+		if now.Sub(deployment.Updated) >= t.ttl {
+			delete(t.deployments, key)
+		}
+	}
+}
+
+func (t *Tracker) save() error {
+	return utils.SaveJSON(t.file, t.deployments)
+}
+
+// Move the deployment to a state. Events that arrive out of order do not move
+// it backwards, but a failed deployment takes any state that succeeds.
+func (d *Deployment) advance(state string, eventType string, now time.Time) {
+	d.Updated = now
+
+	if len(d.Stages) > 0 {
+		last := d.Stages[len(d.Stages)-1]
+		if last.State == state && last.EventType == eventType {
+			return
+		}
+	}
+
+	d.Stages = append(d.Stages, Stage{
+		State:     state,
+		EventType: eventType,
+		Time:      now,
+	})
+
+	if d.State == "" || d.State == StateFailed || state == StateFailed || stateOrder[state] >= stateOrder[d.State] {
+		d.State = state
+	}
+}
+
+// Return the key that identifies the deployment: its revision, scoped to its
+// cluster.
+func (d Deployment) Key() string {
+	return utils.ClusterKey(d.Cluster, d.Revision)
+}
+
+// Return an event describing the deployment, used to filter and route it like
+// the event that last changed it.
+func (d Deployment) Event() fluxevent.Event {
+	event := fluxevent.Event{
+		ServiceIDs: d.Resources,
+		LogLevel:   fluxevent.LogLevelInfo,
+		Metadata: &fluxevent.SyncEventMetadata{
+			Commits: d.Commits,
+			Errors:  d.Errors,
+		},
+	}
+
+	if len(d.Stages) > 0 {
+		event.Type = d.Stages[len(d.Stages)-1].EventType
+		event.StartedAt = d.Started
+		event.EndedAt = d.Stages[len(d.Stages)-1].Time
+	}
+
+	if d.State == StateFailed {
+		event.LogLevel = fluxevent.LogLevelError
+	}
+
+	return event
+}
+
+func (d *Deployment) addResources(ids []flux.ResourceID) {
+	for _, id := range ids {
+		if !d.hasResource(id) {
+			d.Resources = append(d.Resources, id)
+		}
+	}
+}
+
+// Add commits, filling in the messages of commits that are already known.
+func (d *Deployment) addCommits(commits []fluxevent.Commit) {
+	for _, commit := range commits {
+		known := false
+		for i := range d.Commits {
+			if d.Commits[i].Revision == commit.Revision {
+				known = true
+				if commit.Message != "" {
+					d.Commits[i].Message = commit.Message
+				}
+			}
+		}
+
+		if !known {
+			d.Commits = append(d.Commits, commit)
+		}
+	}
+}
+
+func (d *Deployment) hasResource(id flux.ResourceID) bool {
+	for _, resource := range d.Resources {
+		if resource.String() == id.String() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *Deployment) hasAnyResource(ids []flux.ResourceID) bool {
+	for _, id := range ids {
+		if d.hasResource(id) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *Deployment) copy() Deployment {
+	deployment := *d
+	deployment.Resources = append([]flux.ResourceID{}, d.Resources...)
+	deployment.Commits = append([]fluxevent.Commit{}, d.Commits...)
+	deployment.ChangedImages = append([]string{}, d.ChangedImages...)
+	deployment.Errors = append([]fluxevent.ResourceError{}, d.Errors...)
+	deployment.Stages = append([]Stage{}, d.Stages...)
+	return deployment
+}
+
+func appendIfMissing(slice []string, s string) []string {
+	for _, v := range slice {
+		if v == s {
+			return slice
+		}
+	}
+	return append(slice, s)
+}
@@ -0,0 +1,177 @@
+package deployments
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+const revision = "d644e1a05db6881abf0cdb78299917b95f442036"
+
+// Return a commit, sync and automated release event for the same revision.
+func lifecycle() (fluxevent.Event, fluxevent.Event, fluxevent.Event) {
+	sync := test_utils.NewFluxSyncEvent()
+	sync.Metadata.(*fluxevent.SyncEventMetadata).Commits[0].Revision = revision
+
+	release := test_utils.NewFluxAutoReleaseEvent()
+	release.Metadata.(*fluxevent.AutoReleaseEventMetadata).Revision = revision
+
+	return test_utils.NewFluxCommitEvent(), sync, release
+}
+
+func TestTrackerLifecycle(t *testing.T) {
+	tracker, err := NewTracker(time.Hour, "")
+	require.Nil(t, err)
+
+	commit, sync, release := lifecycle()
+	now := time.Now()
+
+	deployments, err := tracker.Track("production", commit, now)
+	require.Nil(t, err)
+	require.Len(t, deployments, 1)
+	assert.Equal(t, revision, deployments[0].Revision)
+	assert.Equal(t, StateCommitted, deployments[0].State)
+	assert.Equal(t, "default:deployment/test", deployments[0].Resources[0].String())
+
+	deployments, err = tracker.Track("production", sync, now.Add(time.Minute))
+	require.Nil(t, err)
+	require.Len(t, deployments, 1)
+	assert.Equal(t, StateSynced, deployments[0].State)
+	assert.Equal(t, "change test image", deployments[0].Commits[0].Message)
+
+	deployments, err = tracker.Track("production", release, now.Add(2*time.Minute))
+	require.Nil(t, err)
+	require.Len(t, deployments, 1)
+	assert.Equal(t, StateReleased, deployments[0].State)
+	assert.Equal(t, []string{"justinbarrick/nginx:test3"}, deployments[0].ChangedImages)
+	assert.Len(t, deployments[0].Resources, 1)
+
+	require.Len(t, deployments[0].Stages, 3)
+	assert.Equal(t, "commit", deployments[0].Stages[0].EventType)
+	assert.Equal(t, "autorelease", deployments[0].Event().Type)
+
+	assert.Len(t, tracker.Deployments(), 1)
+}
+
+func TestTrackerPerCluster(t *testing.T) {
+	tracker, err := NewTracker(time.Hour, "")
+	require.Nil(t, err)
+
+	commit, sync, release := lifecycle()
+	now := time.Now()
+
+	_, err = tracker.Track("production", commit, now)
+	require.Nil(t, err)
+
+	deployments, err := tracker.Track("production", sync, now.Add(time.Minute))
+	require.Nil(t, err)
+	require.Len(t, deployments, 1)
+	assert.Equal(t, StateSynced, deployments[0].State)
+
+	// the same revision syncing to another cluster is its own deployment
+	deployments, err = tracker.Track("staging", release, now.Add(2*time.Minute))
+	require.Nil(t, err)
+	require.Len(t, deployments, 1)
+	assert.Equal(t, "staging", deployments[0].Cluster)
+	assert.Equal(t, "staging/"+revision, deployments[0].Key())
+	require.Len(t, deployments[0].Stages, 1)
+
+	assert.Len(t, tracker.Deployments(), 2)
+}
+
+func TestTrackerOutOfOrder(t *testing.T) {
+	tracker, err := NewTracker(time.Hour, "")
+	require.Nil(t, err)
+
+	_, sync, release := lifecycle()
+	now := time.Now()
+
+	_, err = tracker.Track("production", release, now)
+	require.Nil(t, err)
+
+	// a late sync does not move the deployment back
+	deployments, err := tracker.Track("production", sync, now.Add(time.Minute))
+	require.Nil(t, err)
+	require.Len(t, deployments, 1)
+	assert.Equal(t, StateReleased, deployments[0].State)
+	assert.Len(t, deployments[0].Stages, 2)
+
+	// repeated events do not add stages
+	deployments, err = tracker.Track("production", sync, now.Add(time.Minute))
+	require.Nil(t, err)
+	assert.Len(t, deployments[0].Stages, 2)
+}
+
+func TestTrackerSyncErrors(t *testing.T) {
+	tracker, err := NewTracker(time.Hour, "")
+	require.Nil(t, err)
+
+	deployments, err := tracker.Track("production", test_utils.NewFluxSyncErrorEvent(), time.Now())
+	require.Nil(t, err)
+	require.Len(t, deployments, 1)
+	assert.Equal(t, StateFailed, deployments[0].State)
+	assert.Len(t, deployments[0].Errors, 2)
+	assert.Len(t, deployments[0].Resources, 2)
+	assert.Equal(t, fluxevent.LogLevelError, deployments[0].Event().LogLevel)
+}
+
+func TestTrackerIgnoresSyncsWithoutCommits(t *testing.T) {
+	tracker, err := NewTracker(time.Hour, "")
+	require.Nil(t, err)
+
+	sync := test_utils.NewFluxSyncEvent()
+	sync.Metadata.(*fluxevent.SyncEventMetadata).Commits = nil
+
+	deployments, err := tracker.Track("production", sync, time.Now())
+	require.Nil(t, err)
+	assert.Len(t, deployments, 0)
+}
+
+func TestTrackerExpires(t *testing.T) {
+	tracker, err := NewTracker(time.Hour, "")
+	require.Nil(t, err)
+
+	commit, sync, _ := lifecycle()
+	now := time.Now()
+
+	tracker.Track("production", commit, now)
+
+	// the sync starts a new deployment once the commit was forgotten
+	deployments, err := tracker.Track("production", sync, now.Add(2*time.Hour))
+	require.Nil(t, err)
+	require.Len(t, deployments, 1)
+	assert.Len(t, deployments[0].Stages, 1)
+	assert.Equal(t, StateSynced, deployments[0].State)
+}
+
+func TestTrackerSavesDeployments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deployments")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "deployments.json")
+	now := time.Now()
+
+	tracker, err := NewTracker(time.Hour, file)
+	require.Nil(t, err)
+
+	commit, sync, _ := lifecycle()
+	_, err = tracker.Track("production", commit, now)
+	require.Nil(t, err)
+
+	tracker, err = NewTracker(time.Hour, file)
+	require.Nil(t, err)
+
+	deployments, err := tracker.Track("production", sync, now.Add(time.Minute))
+	require.Nil(t, err)
+	require.Len(t, deployments, 1)
+	assert.Len(t, deployments[0].Stages, 2)
+	assert.Equal(t, "default:deployment/test", deployments[0].Resources[0].String())
+}
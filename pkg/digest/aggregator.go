@@ -0,0 +1,202 @@
+package digest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+// Identifies a batch of events: the cluster they came from, the exporter that
+// will send it and where it will send it, empty for the exporter's configured
+// destination.
+type Key struct {
+	Cluster     string
+	Exporter    string
+	Destination string
+}
+
+// Called with the events collected for a key, and the IDs they were added
+// with, when its batch is complete.
+type FlushFunc func(key Key, ids []string, events []fluxevent.Event)
+
+// Collects events for each key and flushes them together once the window has
+// passed since the first event, or once no events have arrived for the quiet
+// period, whichever is first. A zero window or quiet period is not used. If a
+// file is set the batches are saved to it so that they survive restarts.
+type Aggregator struct {
+	window  time.Duration
+	quiet   time.Duration
+	file    string
+	flush   FlushFunc
+	lock    sync.Mutex
+	batches map[Key]*batch
+}
+
+type batch struct {
+	ids     []string
+	events  []fluxevent.Event
+	started time.Time
+	updated time.Time
+	timer   *time.Timer
+}
+
+// A batch as it is saved.
+type savedBatch struct {
+	Key     Key
+	IDs     []string
+	Events  []fluxevent.Event
+	Started time.Time
+	Updated time.Time
+}
+
+// Create an aggregator that calls flush with each completed batch, loading
+// the batches saved in file if it is set. Batches that were due while
+// fluxcloud was down are flushed at once.
+func NewAggregator(window time.Duration, quiet time.Duration, file string, flush FlushFunc) (*Aggregator, error) {
+	a := &Aggregator{
+		window:  window,
+		quiet:   quiet,
+		file:    file,
+		flush:   flush,
+		batches: map[Key]*batch{},
+	}
+
+	saved := []savedBatch{}
+	if err := utils.LoadJSON(file, &saved, "digest state"); err != nil {
+		return nil, err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for _, s := range saved {
+		b := &batch{
+			ids:     s.IDs,
+			events:  s.Events,
+			started: s.Started,
+			updated: s.Updated,
+		}
+		a.schedule(s.Key, b, time.Now())
+		a.batches[s.Key] = b
+	}
+
+	return a, nil
+}
+
+// Add an event identified by id to the batch for key, starting a batch if
+// there is none. Returns false if the batch already has an event with id.
+func (a *Aggregator) Add(key Key, id string, event fluxevent.Event) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	now := time.Now()
+
+	b, ok := a.batches[key]
+	if ok {
+		for _, batched := range b.ids {
+			if batched == id {
+				return false
+			}
+		}
+	}
+
+	if !ok {
+		b = &batch{started: now}
+		a.batches[key] = b
+	}
+
+	b.updated = now
+	a.schedule(key, b, now)
+
+	b.ids = append(b.ids, id)
+	b.events = append(b.events, event)
+	a.save()
+	return true
+}
+
+// Start or move the timer that flushes a batch. Must be called with the lock
+// held.
+func (a *Aggregator) schedule(key Key, b *batch, now time.Time) {
+	delay := a.delay(b, now)
+	if b.timer != nil {
+		b.timer.Reset(delay)
+		return
+	}
+
+	b.timer = time.AfterFunc(delay, func() {
+		a.flushKey(key, b)
+	})
+}
+
+// Flush every batch now, such as when shutting down.
+func (a *Aggregator) Flush() {
+	a.lock.Lock()
+	batches := a.batches
+	a.batches = map[Key]*batch{}
+	a.save()
+	a.lock.Unlock()
+
+	for key, b := range batches {
+		b.timer.Stop()
+		a.flush(key, b.ids, b.events)
+	}
+}
+
+// Return the number of events waiting to be flushed.
+func (a *Aggregator) Len() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	count := 0
+	for _, b := range a.batches {
+		count += len(b.events)
+	}
+	return count
+}
+
+// Return how long after now to flush a batch, it is due once the window has
+// passed since its first event or the quiet period since its last event.
+func (a *Aggregator) delay(b *batch, now time.Time) time.Duration {
+	deadline := b.started.Add(a.window)
+	if a.quiet > 0 && (a.window <= 0 || b.updated.Add(a.quiet).Before(deadline)) {
+		deadline = b.updated.Add(a.quiet)
+	}
+
+	return deadline.Sub(now)
+}
+
+func (a *Aggregator) flushKey(key Key, b *batch) {
+	a.lock.Lock()
+	if a.batches[key] != b {
+		// already flushed
+		a.lock.Unlock()
+		return
+	}
+	delete(a.batches, key)
+	a.save()
+	a.lock.Unlock()
+
+	a.flush(key, b.ids, b.events)
+}
+
+// Save the batches, must be called with the lock held. Errors are logged since
+// the batches are still flushed while fluxcloud is running.
+func (a *Aggregator) save() {
+	saved := []savedBatch{}
+	for key, b := range a.batches {
+		saved = append(saved, savedBatch{
+			Key:     key,
+			IDs:     b.ids,
+			Events:  b.events,
+			Started: b.started,
+			Updated: b.updated,
+		})
+	}
+
+	if err := utils.SaveJSON(a.file, saved); err != nil {
+		logging.Error("Could not save digest state", "err", err)
+	}
+}
@@ -0,0 +1,182 @@
+package digest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+type flushes struct {
+	lock    sync.Mutex
+	batches map[Key][]fluxevent.Event
+	done    chan Key
+}
+
+func newFlushes() *flushes {
+	return &flushes{
+		batches: map[Key][]fluxevent.Event{},
+		done:    make(chan Key, 10),
+	}
+}
+
+func (f *flushes) flush(key Key, ids []string, events []fluxevent.Event) {
+	f.lock.Lock()
+	f.batches[key] = events
+	f.lock.Unlock()
+	f.done <- key
+}
+
+func (f *flushes) wait(t *testing.T) Key {
+	select {
+	case key := <-f.done:
+		return key
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch was not flushed")
+		return Key{}
+	}
+}
+
+func TestAggregatorWindow(t *testing.T) {
+	f := newFlushes()
+	a, err := NewAggregator(50*time.Millisecond, 0, "", f.flush)
+	require.NoError(t, err)
+
+	slack := Key{Exporter: "Slack"}
+	teams := Key{Exporter: "MS Teams", Destination: "https://teams/"}
+
+	a.Add(slack, "sync", test_utils.NewFluxSyncEvent())
+	a.Add(slack, "commit", test_utils.NewFluxCommitEvent())
+	a.Add(teams, "sync", test_utils.NewFluxSyncEvent())
+	assert.Equal(t, 3, a.Len())
+
+	f.wait(t)
+	f.wait(t)
+
+	assert.Len(t, f.batches[slack], 2)
+	assert.Len(t, f.batches[teams], 1)
+	assert.Equal(t, 0, a.Len())
+}
+
+func TestAggregatorQuietPeriod(t *testing.T) {
+	f := newFlushes()
+	a, err := NewAggregator(time.Hour, 50*time.Millisecond, "", f.flush)
+	require.NoError(t, err)
+
+	key := Key{Exporter: "Slack"}
+	start := time.Now()
+
+	a.Add(key, "one", test_utils.NewFluxSyncEvent())
+	time.Sleep(20 * time.Millisecond)
+	a.Add(key, "two", test_utils.NewFluxSyncEvent())
+
+	f.wait(t)
+	assert.True(t, time.Since(start) >= 70*time.Millisecond)
+	assert.True(t, time.Since(start) < time.Hour)
+	assert.Len(t, f.batches[key], 2)
+}
+
+func TestAggregatorWindowBeatsQuietPeriod(t *testing.T) {
+	a, err := NewAggregator(time.Minute, time.Hour, "", nil)
+	require.NoError(t, err)
+
+	now := time.Now()
+	b := &batch{started: now.Add(-50 * time.Second), updated: now}
+	assert.Equal(t, 10*time.Second, a.delay(b, now))
+
+	a, err = NewAggregator(0, time.Second, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, a.delay(b, now))
+}
+
+func TestAggregatorFlush(t *testing.T) {
+	f := newFlushes()
+	a, err := NewAggregator(time.Hour, 0, "", f.flush)
+	require.NoError(t, err)
+
+	key := Key{Exporter: "Slack"}
+	a.Add(key, "sync", test_utils.NewFluxSyncEvent())
+	a.Flush()
+
+	assert.Equal(t, key, f.wait(t))
+	assert.Len(t, f.batches[key], 1)
+	assert.Equal(t, 0, a.Len())
+}
+
+func TestAggregatorSkipsDuplicates(t *testing.T) {
+	f := newFlushes()
+	a, err := NewAggregator(time.Hour, 0, "", f.flush)
+	require.NoError(t, err)
+
+	key := Key{Exporter: "Slack"}
+	assert.True(t, a.Add(key, "sync", test_utils.NewFluxSyncEvent()))
+	assert.False(t, a.Add(key, "sync", test_utils.NewFluxSyncEvent()))
+	assert.True(t, a.Add(Key{Exporter: "MS Teams"}, "sync", test_utils.NewFluxSyncEvent()))
+	assert.Equal(t, 2, a.Len())
+
+	a.Flush()
+	f.wait(t)
+	f.wait(t)
+	assert.Len(t, f.batches[key], 1)
+}
+
+func TestAggregatorPersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "digest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "digest.json")
+	key := Key{Cluster: "production", Exporter: "Slack", Destination: "#deploys"}
+
+	a, err := NewAggregator(time.Hour, 0, file, nil)
+	require.NoError(t, err)
+	a.Add(key, "sync", test_utils.NewFluxSyncEvent())
+	a.Add(key, "commit", test_utils.NewFluxCommitEvent())
+
+	// the batch is still collecting after a restart
+	f := newFlushes()
+	a, err = NewAggregator(time.Hour, 0, file, f.flush)
+	require.NoError(t, err)
+	assert.Equal(t, 2, a.Len())
+	assert.False(t, a.Add(key, "sync", test_utils.NewFluxSyncEvent()))
+
+	a.Flush()
+	assert.Equal(t, key, f.wait(t))
+	require.Len(t, f.batches[key], 2)
+	assert.Equal(t, "sync", f.batches[key][0].Type)
+	assert.Equal(t, "commit", f.batches[key][1].Type)
+
+	a, err = NewAggregator(time.Hour, 0, file, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, a.Len())
+}
+
+func TestAggregatorFlushesDueBatchesOnLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "digest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "digest.json")
+	key := Key{Exporter: "Slack"}
+
+	a, err := NewAggregator(50*time.Millisecond, 0, file, nil)
+	require.NoError(t, err)
+	a.Add(key, "sync", test_utils.NewFluxSyncEvent())
+	a.batches[key].timer.Stop()
+
+	// fluxcloud was down when the batch was due
+	time.Sleep(60 * time.Millisecond)
+
+	f := newFlushes()
+	_, err = NewAggregator(50*time.Millisecond, 0, file, f.flush)
+	require.NoError(t, err)
+	assert.Equal(t, key, f.wait(t))
+	assert.Len(t, f.batches[key], 1)
+}
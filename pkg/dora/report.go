@@ -0,0 +1,167 @@
+package dora
+
+import (
+	"sort"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
+)
+
+// The DORA metrics of one namespace of a cluster, or of all namespaces, over
+// a period.
+type Metrics struct {
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+
+	Deployments       int     `json:"deployments"`
+	DeploymentsPerDay float64 `json:"deploymentsPerDay"`
+
+	// The median time from a commit to the sync that applied it, if any
+	// commit times are known.
+	MedianLeadTimeSeconds *float64 `json:"medianLeadTimeSeconds,omitempty"`
+
+	FailedDeployments int     `json:"failedDeployments"`
+	ChangeFailureRate float64 `json:"changeFailureRate"`
+
+	Restores                   int      `json:"restores"`
+	MedianTimeToRestoreSeconds *float64 `json:"medianTimeToRestoreSeconds,omitempty"`
+
+	// Whether the namespace is failing to sync now.
+	Failing bool `json:"failing"`
+}
+
+// The DORA metrics of a period.
+type Report struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Total Metrics   `json:"total"`
+	// The metrics of each namespace, by cluster, since namespaces such as
+	// default exist in every cluster.
+	Namespaces []Metrics `json:"namespaces"`
+}
+
+// Compute the DORA metrics of the deployments and restores between start and
+// end.
+func (t *Tracker) Report(start, end time.Time) Report {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	type samples struct {
+		deployments []Deployment
+		restores    []Restore
+		failing     bool
+	}
+
+	all := &samples{}
+	namespaces := map[string]*samples{}
+	get := func(cluster, ns string) *samples {
+		key := utils.ClusterKey(cluster, ns)
+		if _, ok := namespaces[key]; !ok {
+			namespaces[key] = &samples{}
+		}
+		return namespaces[key]
+	}
+
+	for _, deployment := range t.state.Deployments {
+		if deployment.Time.Before(start) || deployment.Time.After(end) {
+			continue
+		}
+
+		s := get(deployment.Cluster, deployment.Namespace)
+		s.deployments = append(s.deployments, deployment)
+		all.deployments = append(all.deployments, deployment)
+	}
+
+	for _, restore := range t.state.Restores {
+		if restore.RestoredAt.Before(start) || restore.RestoredAt.After(end) {
+			continue
+		}
+
+		s := get(restore.Cluster, restore.Namespace)
+		s.restores = append(s.restores, restore)
+		all.restores = append(all.restores, restore)
+	}
+
+	for key := range t.state.Failing {
+		get(splitClusterKey(key)).failing = true
+		all.failing = true
+	}
+
+	days := end.Sub(start).Hours() / 24
+
+	compute := func(cluster, ns string, s *samples) Metrics {
+		metrics := Metrics{
+			Cluster:     cluster,
+			Namespace:   ns,
+			Deployments: len(s.deployments),
+			Restores:    len(s.restores),
+			Failing:     s.failing,
+		}
+
+		if days > 0 {
+			metrics.DeploymentsPerDay = float64(metrics.Deployments) / days
+		}
+
+		leadTimes := []time.Duration{}
+		for _, deployment := range s.deployments {
+			if deployment.Failed {
+				metrics.FailedDeployments++
+			}
+			leadTimes = append(leadTimes, deployment.LeadTimes...)
+		}
+
+		if metrics.Deployments > 0 {
+			metrics.ChangeFailureRate = float64(metrics.FailedDeployments) / float64(metrics.Deployments)
+		}
+
+		timesToRestore := []time.Duration{}
+		for _, restore := range s.restores {
+			timesToRestore = append(timesToRestore, restore.RestoredAt.Sub(restore.FailedAt))
+		}
+
+		metrics.MedianLeadTimeSeconds = medianSeconds(leadTimes)
+		metrics.MedianTimeToRestoreSeconds = medianSeconds(timesToRestore)
+		return metrics
+	}
+
+	report := Report{
+		Start:      start,
+		End:        end,
+		Total:      compute("", "", all),
+		Namespaces: []Metrics{},
+	}
+
+	for key, s := range namespaces {
+		cluster, ns := splitClusterKey(key)
+		report.Namespaces = append(report.Namespaces, compute(cluster, ns, s))
+	}
+
+	sort.Slice(report.Namespaces, func(i, j int) bool {
+		a, b := report.Namespaces[i], report.Namespaces[j]
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		return a.Namespace < b.Namespace
+	})
+
+	return report
+}
+
+// Return the median of durations in seconds, or nil if there are none.
+func medianSeconds(durations []time.Duration) *float64 {
+	if len(durations) == 0 {
+		return nil
+	}
+
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+
+	middle := len(durations) / 2
+	median := durations[middle].Seconds()
+	if len(durations)%2 == 0 {
+		median = (durations[middle-1] + durations[middle]).Seconds() / 2
+	}
+
+	return &median
+}
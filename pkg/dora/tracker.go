@@ -0,0 +1,294 @@
+package dora
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+// The histogram buckets for lead time and time to restore, in seconds.
+var durationBuckets = []float64{
+	60, 5 * 60, 15 * 60, 30 * 60, 60 * 60, 3 * 60 * 60, 6 * 60 * 60,
+	12 * 60 * 60, 24 * 60 * 60, 2 * 24 * 60 * 60, 7 * 24 * 60 * 60,
+}
+
+// A sync that applied commits to a namespace.
+type Deployment struct {
+	Cluster   string    `json:"cluster,omitempty"`
+	Namespace string    `json:"namespace"`
+	Revision  string    `json:"revision"`
+	Time      time.Time `json:"time"`
+	Failed    bool      `json:"failed"`
+
+	// The time from each commit to the sync, for the commits whose time is
+	// known.
+	LeadTimes []time.Duration `json:"leadTimes,omitempty"`
+}
+
+// A namespace that synced successfully after it failed.
+type Restore struct {
+	Cluster    string    `json:"cluster,omitempty"`
+	Namespace  string    `json:"namespace"`
+	FailedAt   time.Time `json:"failedAt"`
+	RestoredAt time.Time `json:"restoredAt"`
+}
+
+type state struct {
+	Deployments []Deployment `json:"deployments"`
+	Restores    []Restore    `json:"restores"`
+
+	// When each commit was made, by revision scoped to its cluster.
+	Commits map[string]time.Time `json:"commits"`
+
+	// When each namespace that is failing to sync started failing, by
+	// namespace scoped to its cluster.
+	Failing map[string]time.Time `json:"failing"`
+}
+
+// Computes DORA metrics per cluster and namespace from Flux events: deployment frequency,
+// lead time, change failure rate and time to restore. It is a Prometheus
+// collector, and keeps the deployments and restores of the retention period
+// for reports. If a file is set they are saved to it so that they survive
+// restarts.
+type Tracker struct {
+	retention time.Duration
+	file      string
+	lock      sync.Mutex
+	state     state
+
+	deployments       *prometheus.CounterVec
+	failedDeployments *prometheus.CounterVec
+	leadTime          *prometheus.HistogramVec
+	timeToRestore     *prometheus.HistogramVec
+}
+
+// Create a tracker that keeps deployments for retention, loading the state
+// saved in file if it is set.
+func NewTracker(retention time.Duration, file string) (*Tracker, error) {
+	t := &Tracker{
+		retention: retention,
+		file:      file,
+		state: state{
+			Commits: map[string]time.Time{},
+			Failing: map[string]time.Time{},
+		},
+		deployments: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fluxcloud_dora_deployments_total",
+			Help: "Syncs that applied commits, by cluster and namespace.",
+		}, []string{"cluster", "namespace"}),
+		failedDeployments: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fluxcloud_dora_failed_deployments_total",
+			Help: "Syncs that applied commits and failed to apply resources, by cluster and namespace.",
+		}, []string{"cluster", "namespace"}),
+		leadTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "fluxcloud_dora_lead_time_seconds",
+			Help:    "Time from a commit to the sync that applied it, by cluster and namespace.",
+			Buckets: durationBuckets,
+		}, []string{"cluster", "namespace"}),
+		timeToRestore: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "fluxcloud_dora_time_to_restore_seconds",
+			Help:    "Time from a failed sync to the next successful sync, by cluster and namespace.",
+			Buckets: durationBuckets,
+		}, []string{"cluster", "namespace"}),
+	}
+
+	if err := utils.LoadJSON(file, &t.state, "DORA state"); err != nil {
+		return nil, err
+	}
+
+	if t.state.Commits == nil {
+		t.state.Commits = map[string]time.Time{}
+	}
+
+	if t.state.Failing == nil {
+		t.state.Failing = map[string]time.Time{}
+	}
+
+	return t, nil
+}
+
+// Record an event from a cluster. Commit events record when Flux pushed a
+// commit, and sync events record deployments, failures and restores.
+func (t *Tracker) Record(cluster string, event fluxevent.Event, now time.Time) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	at := eventTime(event, now)
+
+	switch metadata := event.Metadata.(type) {
+	case *fluxevent.CommitEventMetadata:
+		if metadata.Revision == "" {
+			return nil
+		}
+
+		key := utils.ClusterKey(cluster, metadata.Revision)
+		if _, ok := t.state.Commits[key]; !ok {
+			t.state.Commits[key] = at
+		}
+	case *fluxevent.SyncEventMetadata:
+		t.recordSync(cluster, event, metadata, at)
+	default:
+		return nil
+	}
+
+	t.expire(now)
+	return t.save()
+}
+
+func (t *Tracker) recordSync(cluster string, event fluxevent.Event, metadata *fluxevent.SyncEventMetadata, at time.Time) {
+	failing := map[string]bool{}
+	for _, resourceError := range metadata.Errors {
+		failing[namespace(resourceError.ID.Components())] = true
+	}
+
+	for ns := range failing {
+		key := utils.ClusterKey(cluster, ns)
+		if _, ok := t.state.Failing[key]; !ok {
+			t.state.Failing[key] = at
+		}
+	}
+
+	// every sync reports all of the resources that fail to apply in its
+	// cluster, so a failing namespace of the cluster without errors was
+	// restored
+	for key, since := range t.state.Failing {
+		failingCluster, ns := splitClusterKey(key)
+		if failingCluster != cluster || failing[ns] {
+			continue
+		}
+
+		delete(t.state.Failing, key)
+		t.state.Restores = append(t.state.Restores, Restore{
+			Cluster:    cluster,
+			Namespace:  ns,
+			FailedAt:   since,
+			RestoredAt: at,
+		})
+		t.timeToRestore.WithLabelValues(cluster, ns).Observe(at.Sub(since).Seconds())
+	}
+
+	if len(metadata.Commits) == 0 {
+		return
+	}
+
+	var leadTimes []time.Duration
+	for _, commit := range metadata.Commits {
+		if committed, ok := t.state.Commits[utils.ClusterKey(cluster, commit.Revision)]; ok {
+			leadTimes = append(leadTimes, at.Sub(committed))
+		}
+	}
+
+	namespaces := map[string]bool{}
+	for _, id := range utils.GetResourceIDs(event) {
+		namespaces[namespace(id.Components())] = true
+	}
+
+	for ns := range namespaces {
+		t.state.Deployments = append(t.state.Deployments, Deployment{
+			Cluster:   cluster,
+			Namespace: ns,
+			Revision:  metadata.Commits[0].Revision,
+			Time:      at,
+			Failed:    failing[ns],
+			LeadTimes: leadTimes,
+		})
+
+		t.deployments.WithLabelValues(cluster, ns).Inc()
+		if failing[ns] {
+			t.failedDeployments.WithLabelValues(cluster, ns).Inc()
+		}
+
+		for _, leadTime := range leadTimes {
+			t.leadTime.WithLabelValues(cluster, ns).Observe(leadTime.Seconds())
+		}
+	}
+}
+
+// Describe the Prometheus metrics.
+func (t *Tracker) Describe(ch chan<- *prometheus.Desc) {
+	t.deployments.Describe(ch)
+	t.failedDeployments.Describe(ch)
+	t.leadTime.Describe(ch)
+	t.timeToRestore.Describe(ch)
+}
+
+// Collect the Prometheus metrics.
+func (t *Tracker) Collect(ch chan<- prometheus.Metric) {
+	t.deployments.Collect(ch)
+	t.failedDeployments.Collect(ch)
+	t.leadTime.Collect(ch)
+	t.timeToRestore.Collect(ch)
+}
+
+// Forget the deployments, restores and commits older than the retention.
+func (t *Tracker) expire(now time.Time) {
+	if t.retention <= 0 {
+		return
+	}
+
+	cutoff := now.Add(-t.retention)
+
+	deployments := []Deployment{}
+	for _, deployment := range t.state.Deployments {
+		if deployment.Time.After(cutoff) {
+			deployments = append(deployments, deployment)
+		}
+	}
+	t.state.Deployments = deployments
+
+	restores := []Restore{}
+	for _, restore := range t.state.Restores {
+		if restore.RestoredAt.After(cutoff) {
+			restores = append(restores, restore)
+		}
+	}
+	t.state.Restores = restores
+
+	for key, committed := range t.state.Commits {
+		if !committed.After(cutoff) {
+			delete(t.state.Commits, key)
+		}
+	}
+}
+
+func (t *Tracker) save() error {
+	return utils.SaveJSON(t.file, t.state)
+}
+
+// Return when an event finished, or now if it has no time.
+func eventTime(event fluxevent.Event, now time.Time) time.Time {
+	if !event.EndedAt.IsZero() {
+		return event.EndedAt
+	}
+
+	if !event.StartedAt.IsZero() {
+		return event.StartedAt
+	}
+
+	return now
+}
+
+func namespace(ns, _, _ string) string {
+	return ns
+}
+
+// Split a namespace scoped to a cluster into the cluster and namespace,
+// namespaces never contain a slash.
+func splitClusterKey(key string) (string, string) {
+	i := strings.LastIndex(key, "/")
+	if i < 0 {
+		return "", key
+	}
+
+	return key[:i], key[i+1:]
+}
+
+// Return how long deployments are kept for reports, zero if they are kept
+// forever.
+func (t *Tracker) Retention() time.Duration {
+	return t.retention
+}
@@ -0,0 +1,163 @@
+package dora
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+const revision = "d644e1a05db6881abf0cdb78299917b95f442036"
+
+var start = time.Date(2019, 4, 8, 9, 0, 0, 0, time.UTC)
+
+func at(event fluxevent.Event, t time.Time) fluxevent.Event {
+	event.StartedAt = t
+	event.EndedAt = t
+	return event
+}
+
+func syncOf(revision string) fluxevent.Event {
+	sync := test_utils.NewFluxSyncEvent()
+	sync.Metadata.(*fluxevent.SyncEventMetadata).Commits[0].Revision = revision
+	return sync
+}
+
+func TestTrackerLeadTime(t *testing.T) {
+	tracker, err := NewTracker(0, "")
+	require.Nil(t, err)
+
+	require.Nil(t, tracker.Record("production", at(test_utils.NewFluxCommitEvent(), start), start))
+	require.Nil(t, tracker.Record("production", at(syncOf(revision), start.Add(10*time.Minute)), start))
+
+	// commits that Flux did not push have no lead time
+	require.Nil(t, tracker.Record("production", at(test_utils.NewFluxSyncEvent(), start.Add(time.Hour)), start))
+
+	report := tracker.Report(start, start.Add(24*time.Hour))
+	require.Len(t, report.Namespaces, 1)
+
+	metrics := report.Namespaces[0]
+	assert.Equal(t, "default", metrics.Namespace)
+	assert.Equal(t, 2, metrics.Deployments)
+	assert.Equal(t, 2.0, metrics.DeploymentsPerDay)
+	require.NotNil(t, metrics.MedianLeadTimeSeconds)
+	assert.Equal(t, 600.0, *metrics.MedianLeadTimeSeconds)
+	assert.Equal(t, 2, report.Total.Deployments)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(tracker.deployments.WithLabelValues("production", "default")))
+}
+
+func TestTrackerChangeFailureRateAndRestore(t *testing.T) {
+	tracker, err := NewTracker(0, "")
+	require.Nil(t, err)
+
+	require.Nil(t, tracker.Record("production", at(test_utils.NewFluxSyncEvent(), start), start))
+	require.Nil(t, tracker.Record("production", at(test_utils.NewFluxSyncErrorEvent(), start.Add(time.Minute)), start))
+
+	// syncs without commits are not deployments, but they still report errors
+	repeat := at(test_utils.NewFluxSyncErrorEvent(), start.Add(5*time.Minute))
+	repeat.Metadata.(*fluxevent.SyncEventMetadata).Commits = nil
+	require.Nil(t, tracker.Record("production", repeat, start))
+
+	report := tracker.Report(start, start.Add(time.Hour))
+	require.Len(t, report.Namespaces, 1)
+	assert.Equal(t, 2, report.Namespaces[0].Deployments)
+	assert.Equal(t, 1, report.Namespaces[0].FailedDeployments)
+	assert.Equal(t, 0.5, report.Namespaces[0].ChangeFailureRate)
+	assert.True(t, report.Namespaces[0].Failing)
+	assert.Nil(t, report.Namespaces[0].MedianTimeToRestoreSeconds)
+
+	fixed := at(test_utils.NewFluxSyncEvent(), start.Add(31*time.Minute))
+	fixed.Metadata.(*fluxevent.SyncEventMetadata).Commits = nil
+	require.Nil(t, tracker.Record("production", fixed, start))
+
+	report = tracker.Report(start, start.Add(time.Hour))
+	assert.False(t, report.Namespaces[0].Failing)
+	assert.Equal(t, 1, report.Namespaces[0].Restores)
+	require.NotNil(t, report.Namespaces[0].MedianTimeToRestoreSeconds)
+	assert.Equal(t, 1800.0, *report.Namespaces[0].MedianTimeToRestoreSeconds)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(tracker.failedDeployments.WithLabelValues("production", "default")))
+}
+
+func TestTrackerPerCluster(t *testing.T) {
+	tracker, err := NewTracker(0, "")
+	require.Nil(t, err)
+
+	require.Nil(t, tracker.Record("production", at(test_utils.NewFluxCommitEvent(), start), start))
+	require.Nil(t, tracker.Record("production", at(test_utils.NewFluxSyncErrorEvent(), start.Add(time.Minute)), start))
+
+	// a clean sync of another cluster does not restore the failing namespace,
+	// and commits pushed to one cluster have no lead time in another
+	require.Nil(t, tracker.Record("staging", at(syncOf(revision), start.Add(10*time.Minute)), start))
+
+	// the default namespaces of the clusters are reported separately
+	report := tracker.Report(start, start.Add(time.Hour))
+	require.Len(t, report.Namespaces, 2)
+	production, staging := report.Namespaces[0], report.Namespaces[1]
+	assert.Equal(t, "production", production.Cluster)
+	assert.Equal(t, "default", production.Namespace)
+	assert.True(t, production.Failing)
+	assert.Equal(t, 0, production.Restores)
+	assert.Equal(t, 1, production.Deployments)
+	assert.Equal(t, "staging", staging.Cluster)
+	assert.Equal(t, "default", staging.Namespace)
+	assert.False(t, staging.Failing)
+	assert.Equal(t, 1, staging.Deployments)
+	assert.Nil(t, staging.MedianLeadTimeSeconds)
+	assert.Equal(t, 2, report.Total.Deployments)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(tracker.deployments.WithLabelValues("staging", "default")))
+
+	fixed := at(test_utils.NewFluxSyncEvent(), start.Add(31*time.Minute))
+	fixed.Metadata.(*fluxevent.SyncEventMetadata).Commits = nil
+	require.Nil(t, tracker.Record("production", fixed, start))
+
+	report = tracker.Report(start, start.Add(time.Hour))
+	assert.False(t, report.Namespaces[0].Failing)
+	assert.Equal(t, 1, report.Namespaces[0].Restores)
+}
+
+func TestTrackerRetention(t *testing.T) {
+	tracker, err := NewTracker(24*time.Hour, "")
+	require.Nil(t, err)
+
+	require.Nil(t, tracker.Record("production", at(test_utils.NewFluxSyncEvent(), start), start))
+	require.Nil(t, tracker.Record("production", at(test_utils.NewFluxSyncEvent(), start.Add(48*time.Hour)), start.Add(48*time.Hour)))
+
+	report := tracker.Report(start, start.Add(72*time.Hour))
+	assert.Equal(t, 1, report.Total.Deployments)
+}
+
+func TestTrackerSavesState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dora")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "dora.json")
+
+	tracker, err := NewTracker(0, file)
+	require.Nil(t, err)
+	require.Nil(t, tracker.Record("production", at(test_utils.NewFluxCommitEvent(), start), start))
+
+	tracker, err = NewTracker(0, file)
+	require.Nil(t, err)
+	require.Nil(t, tracker.Record("production", at(syncOf(revision), start.Add(time.Hour)), start))
+
+	report := tracker.Report(start, start.Add(24*time.Hour))
+	require.NotNil(t, report.Total.MedianLeadTimeSeconds)
+	assert.Equal(t, 3600.0, *report.Total.MedianLeadTimeSeconds)
+}
+
+func TestMedianSeconds(t *testing.T) {
+	assert.Nil(t, medianSeconds(nil))
+	assert.Equal(t, 2.0, *medianSeconds([]time.Duration{3 * time.Second, time.Second, 2 * time.Second}))
+	assert.Equal(t, 2.5, *medianSeconds([]time.Duration{4 * time.Second, time.Second}))
+}
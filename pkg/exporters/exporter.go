@@ -2,8 +2,10 @@ package exporters
 
 import (
 	"context"
+	"fmt"
 	"github.com/justinbarrick/fluxcloud/pkg/msg"
 	"net/http"
+	"net/url"
 )
 
 // An exporter sends a formatted event to an upstream.
@@ -20,3 +22,42 @@ type Exporter interface {
 	// Returns the name of the exporter.
 	Name() string
 }
+
+// Implemented by exporters that can validate their configuration, see Check.
+type Checker interface {
+	// Check the exporter's configuration against its upstream.
+	Check(c context.Context, client *http.Client) error
+}
+
+// Validate an exporter's configuration if it implements Checker.
+func Check(c context.Context, client *http.Client, exporter Exporter) error {
+	if checker, ok := Unwrap(exporter).(Checker); ok {
+		return checker.Check(c, client)
+	}
+
+	return nil
+}
+
+// Return an error if value is not an absolute http or https URL.
+func checkUrl(service string, value string) error {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("Invalid %s URL: %s", service, err)
+	}
+
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("Invalid %s URL: must be an absolute http or https URL", service)
+	}
+
+	return nil
+}
+
+// Return the URLs a message should be posted to: the destinations it was
+// routed to, or the exporter's configured URL.
+func destinationUrls(message msg.Message, url string) []string {
+	if len(message.Destinations) > 0 {
+		return message.Destinations
+	}
+
+	return []string{url}
+}
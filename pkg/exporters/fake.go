@@ -9,9 +9,17 @@ import (
 
 type FakeExporter struct {
 	Sent []msg.Message
+
+	// Optional name to return from Name() and error to return from Send().
+	ExporterName string
+	SendError    error
 }
 
 func (f *FakeExporter) Send(_ context.Context, _ *http.Client, message msg.Message) error {
+	if f.SendError != nil {
+		return f.SendError
+	}
+
 	f.Sent = append(f.Sent, message)
 	return nil
 }
@@ -25,5 +33,9 @@ func (f *FakeExporter) FormatLink(link string, name string) string {
 }
 
 func (f *FakeExporter) Name() string {
+	if f.ExporterName != "" {
+		return f.ExporterName
+	}
+
 	return "Fake"
 }
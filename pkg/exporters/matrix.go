@@ -1,16 +1,14 @@
 package exporters
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
 	"path/filepath"
 
 	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
 	"github.com/justinbarrick/fluxcloud/pkg/msg"
 )
 
@@ -19,6 +17,22 @@ type MatrixMessage struct {
 	Format        string `json:"format"`
 	FormattedBody string `json:"formatted_body"`
 	Body          string `json:"body"`
+
+	// Set when editing a message: the new content and the message it
+	// replaces.
+	NewContent *MatrixMessage  `json:"m.new_content,omitempty"`
+	RelatesTo  *MatrixRelation `json:"m.relates_to,omitempty"`
+}
+
+// Relates a Matrix message to another event.
+type MatrixRelation struct {
+	RelType string `json:"rel_type"`
+	EventID string `json:"event_id"`
+}
+
+// The response to sending a Matrix message.
+type matrixResponse struct {
+	EventID string `json:"event_id"`
 }
 
 // The Matrix exporter sends Flux events to a Matrix channel.
@@ -27,6 +41,19 @@ type Matrix struct {
 	fullUrl     string
 	roomId      string
 	accessToken string
+	sender      *Sender
+
+	notifications notifications
+}
+
+func init() {
+	Register("matrix", func(config config.Config) (Exporter, error) {
+		exporter, err := NewMatrix(config)
+		if err != nil {
+			return nil, err
+		}
+		return exporter, nil
+	})
 }
 
 // Initialize a new Matrix instance
@@ -43,6 +70,7 @@ func NewMatrix(config config.Config) (*Matrix, error) {
 	if err != nil {
 		return nil, err
 	}
+	logging.Secret(s.accessToken)
 
 	s.roomId, err = config.Required("Matrix_room_id")
 	if err != nil {
@@ -54,16 +82,26 @@ func NewMatrix(config config.Config) (*Matrix, error) {
 		return nil, err
 	}
 
+	s.sender, err = NewSender("Matrix", "matrix", config)
+	if err != nil {
+		return nil, err
+	}
+
 	return &s, nil
 }
 
 func (s *Matrix) GetUrl() (string, error) {
+	return s.roomUrl(s.roomId)
+}
+
+// Return the URL for sending messages to a room.
+func (s *Matrix) roomUrl(roomId string) (string, error) {
 	parsed, err := url.Parse(s.url)
 	if err != nil {
 		return "", err
 	}
 
-	pathPart := fmt.Sprintf("_matrix/client/r0/rooms/%s/send/m.room.message", s.roomId)
+	pathPart := fmt.Sprintf("_matrix/client/r0/rooms/%s/send/m.room.message", roomId)
 	parsed.Path = filepath.Join(parsed.Path, pathPart)
 
 	query, err := url.ParseQuery(parsed.RawQuery)
@@ -77,38 +115,102 @@ func (s *Matrix) GetUrl() (string, error) {
 	return parsed.String(), nil
 }
 
-// Send a Message to Matrix
+// Send a Message to Matrix, to the rooms it was routed to or the configured
+// room.
 func (s *Matrix) Send(c context.Context, client *http.Client, message msg.Message) error {
-	b := new(bytes.Buffer)
-
 	body := fmt.Sprintf("<a href='%s'>%s</a><br>%s", message.TitleLink, message.Title, message.Body)
 
-	err := json.NewEncoder(b).Encode(MatrixMessage{
+	roomIds := []string{s.roomId}
+	if len(message.Destinations) > 0 {
+		roomIds = message.Destinations
+	}
+
+	content := MatrixMessage{
 		MsgType:       "m.text",
 		Format:        "org.matrix.custom.html",
 		FormattedBody: body,
 		Body:          message.Title,
-	})
+	}
+
+	sender := senderOrDefault(s.sender, "Matrix")
+	for _, roomId := range roomIds {
+		url, err := s.roomUrl(roomId)
+		if err != nil {
+			return err
+		}
+
+		if message.NotificationKey == "" {
+			err = sender.PostJSON(c, client, url, nil, content)
+		} else {
+			err = s.sendNotification(c, client, sender, url, message.NotificationKey, roomId, content)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Send a message to a room, editing the message that was sent for the same
+// notification if there is one.
+func (s *Matrix) sendNotification(c context.Context, client *http.Client, sender *Sender, url string, key string, roomId string, content MatrixMessage) error {
+	if eventId, ok := s.notifications.get(key, roomId); ok {
+		newContent := content
+		edit := content
+		edit.Body = "* " + content.Body
+		edit.FormattedBody = "* " + content.FormattedBody
+		edit.NewContent = &newContent
+		edit.RelatesTo = &MatrixRelation{
+			RelType: "m.replace",
+			EventID: eventId,
+		}
+
+		return sender.PostJSON(c, client, url, nil, edit)
+	}
+
+	response := matrixResponse{}
+	if err := sender.PostJSONResponse(c, client, url, nil, content, &response); err != nil {
+		return err
+	}
+
+	if response.EventID != "" {
+		s.notifications.set(key, roomId, response.EventID)
+	}
+
+	return nil
+}
+
+// Check that the Matrix URL is valid and that the room can be reached with
+// the access token.
+func (s *Matrix) Check(c context.Context, client *http.Client) error {
+	if err := checkUrl("Matrix", s.url); err != nil {
+		return err
+	}
+
+	parsed, err := url.Parse(s.url)
 	if err != nil {
-		log.Print("Could encode message to Matrix:", err)
 		return err
 	}
 
-	log.Print(string(b.Bytes()))
+	parsed.Path = filepath.Join(parsed.Path, fmt.Sprintf("_matrix/client/r0/rooms/%s/joined_members", s.roomId))
+
+	req, err := http.NewRequest("GET", parsed.String(), nil)
+	if err != nil {
+		return err
+	}
 
-	req, _ := http.NewRequest("POST", s.fullUrl, b)
-	req.Header.Set("Content-Type", "application/json")
-	req = req.WithContext(c)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.accessToken))
 
-	res, err := client.Do(req)
+	res, err := client.Do(req.WithContext(c))
 	if err != nil {
-		log.Print("Could not post to matrix:", err)
 		return err
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != 200 {
-		log.Print("Could not post to Matrix, status code:", res.StatusCode)
-		return fmt.Errorf("Could not post to Matrix, status code: %d", res.StatusCode)
+		return fmt.Errorf("Matrix room %s is not reachable, status: %d", s.roomId, res.StatusCode)
 	}
 
 	return nil
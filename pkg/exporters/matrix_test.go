@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
 	"github.com/justinbarrick/fluxcloud/pkg/msg"
 	"github.com/stretchr/testify/assert"
 	"net/http"
@@ -108,6 +109,35 @@ func TestMatrixSend(t *testing.T) {
 	})
 }
 
+func TestMatrixSendDestinations(t *testing.T) {
+	matrix := Matrix{}
+
+	message := msg.Message{
+		Title:        "The title of the message",
+		Destinations: []string{"!one:myserver", "!two:myserver"},
+	}
+
+	paths := []string{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		fmt.Fprintln(w, "Hello, client")
+	}))
+	defer ts.Close()
+
+	matrix.url = ts.URL
+	matrix.roomId = "!myroom:myserver"
+	matrix.accessToken = "myaccesstoken"
+	matrix.fullUrl, _ = matrix.GetUrl()
+
+	err := matrix.Send(context.TODO(), &http.Client{}, message)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"/_matrix/client/r0/rooms/!one:myserver/send/m.room.message",
+		"/_matrix/client/r0/rooms/!two:myserver/send/m.room.message",
+	}, paths)
+}
+
 func TestMatrixSendNon200(t *testing.T) {
 	matrix := Matrix{}
 	message := msg.Message{}
@@ -153,3 +183,81 @@ func TestMatrixName(t *testing.T) {
 func TestMatrixImplementsExporter(t *testing.T) {
 	_ = Exporter(&Matrix{})
 }
+
+func TestMatrixSendNotificationEdits(t *testing.T) {
+	matrix := Matrix{}
+
+	message := msg.Message{
+		Title:           "Deployment of d644e1a: committed",
+		NotificationKey: "deployment-d644e1a",
+	}
+
+	received := []MatrixMessage{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedMessage := MatrixMessage{}
+		json.NewDecoder(r.Body).Decode(&receivedMessage)
+		received = append(received, receivedMessage)
+		fmt.Fprintln(w, `{"event_id": "$original"}`)
+	}))
+	defer ts.Close()
+
+	matrix.url = ts.URL
+	matrix.roomId = "!myroom:myserver"
+	matrix.accessToken = "myaccesstoken"
+
+	assert.Nil(t, matrix.Send(context.TODO(), &http.Client{}, message))
+
+	message.Title = "Deployment of d644e1a: synced"
+	assert.Nil(t, matrix.Send(context.TODO(), &http.Client{}, message))
+
+	assert.Len(t, received, 2)
+	assert.Nil(t, received[0].RelatesTo)
+	assert.Equal(t, "* Deployment of d644e1a: synced", received[1].Body)
+	assert.Equal(t, &MatrixRelation{RelType: "m.replace", EventID: "$original"}, received[1].RelatesTo)
+	assert.Equal(t, "Deployment of d644e1a: synced", received[1].NewContent.Body)
+}
+
+func TestMatrixCheck(t *testing.T) {
+	matrix := Matrix{}
+
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "GET", r.Method)
+		assert.Equal(t, "/_matrix/client/r0/rooms/!myroom:myserver/joined_members", r.URL.Path)
+		assert.Equal(t, "Bearer myaccesstoken", r.Header.Get("Authorization"))
+		w.WriteHeader(status)
+		fmt.Fprintln(w, `{"joined": {}}`)
+	}))
+	defer ts.Close()
+
+	matrix.url = ts.URL
+	matrix.roomId = "!myroom:myserver"
+	matrix.accessToken = "myaccesstoken"
+
+	assert.Nil(t, matrix.Check(context.TODO(), &http.Client{}))
+
+	status = http.StatusForbidden
+	err := matrix.Check(context.TODO(), &http.Client{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "!myroom:myserver")
+}
+
+func TestMatrixCheckInvalidURL(t *testing.T) {
+	matrix := Matrix{url: "mymatrix", roomId: "!myroom:myserver"}
+	assert.NotNil(t, matrix.Check(context.TODO(), &http.Client{}))
+}
+
+func TestMatrixRedactsToken(t *testing.T) {
+	config := config.NewFakeConfig()
+	config.Set("matrix_url", "https://mymatrix/")
+	config.Set("matrix_token", "mysecretmatrixtoken")
+	config.Set("matrix_room_id", "!myroom:mymatrix")
+
+	matrix, err := NewMatrix(config)
+	assert.Nil(t, err)
+
+	err = fmt.Errorf("Post %s: EOF", matrix.fullUrl)
+	assert.NotContains(t, logging.Redact(err.Error()), "mysecretmatrixtoken")
+	assert.NotContains(t, logging.Redact("using mysecretmatrixtoken"), "mysecretmatrixtoken")
+}
@@ -1,20 +1,19 @@
 package exporters
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 
 	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
 	"github.com/justinbarrick/fluxcloud/pkg/msg"
 )
 
 // The MSTeams exporter sends Flux events to a Microsoft Teams channel via a webhook.
 type MSTeams struct {
-	Url string
+	Url    string
+	Sender *Sender
 }
 
 // Represents a MS Teams message sent to the API
@@ -40,6 +39,16 @@ type MSTeamsActionTarget struct {
 	URI string `json:"uri"`
 }
 
+func init() {
+	Register("msteams", func(config config.Config) (Exporter, error) {
+		exporter, err := NewMSTeams(config)
+		if err != nil {
+			return nil, err
+		}
+		return exporter, nil
+	})
+}
+
 // Initialize a new MSTeams instance
 func NewMSTeams(config config.Config) (*MSTeams, error) {
 	var err error
@@ -49,39 +58,33 @@ func NewMSTeams(config config.Config) (*MSTeams, error) {
 	if err != nil {
 		return nil, err
 	}
+	logging.Secret(t.Url)
+
+	t.Sender, err = NewSender("MS Teams", "msteams", config)
+	if err != nil {
+		return nil, err
+	}
 
 	return &t, nil
 }
 
 // Send a MSTeamsMessage to MS Teams
 func (s *MSTeams) Send(ctx context.Context, client *http.Client, message msg.Message) error {
-	msTeamsMessage := s.NewMSTeamsMessage(message)
-	fmt.Println(msTeamsMessage)
-	b := new(bytes.Buffer)
-	err := json.NewEncoder(b).Encode(msTeamsMessage)
-	if err != nil {
-		log.Print("Error when encoding MS Teams message in JSON:", err)
-		return err
-	}
-
-	req, _ := http.NewRequest("POST", s.Url, b)
-	req.Header.Set("Content-Type", "application/json")
-	req = req.WithContext(ctx)
-	log.Print(string(b.Bytes()))
-	res, err := client.Do(req)
-	if err != nil {
-		log.Print("Could not post to MS Teams:", err)
-		return err
-	}
-
-	if res.StatusCode != 200 {
-		log.Print("Could not post to MS Teams, status: ", res.Status)
-		return fmt.Errorf("Could not post to MS Teams, status: %d", res.StatusCode)
+	sender := senderOrDefault(s.Sender, "MS Teams")
+	for _, url := range destinationUrls(message, s.Url) {
+		if err := sender.PostJSON(ctx, client, url, nil, s.NewMSTeamsMessage(message)); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// Check that the MS Teams webhook URL is valid.
+func (s *MSTeams) Check(ctx context.Context, client *http.Client) error {
+	return checkUrl("MS Teams", s.Url)
+}
+
 // Return the new line character for MS Teams messages
 func (s *MSTeams) NewLine() string {
 	return "\n"
@@ -0,0 +1,68 @@
+package exporters
+
+import (
+	"sync"
+)
+
+// The number of notifications an exporter remembers, older notifications are
+// sent as new messages.
+const maxNotifications = 1000
+
+// Remembers the messages an exporter sent for each notification key and
+// destination, so that later messages with the same key can edit them. The
+// zero value is ready to use.
+type notifications struct {
+	lock  sync.Mutex
+	refs  map[string]string
+	order []string
+}
+
+// Return the reference to the message sent for a key and destination.
+func (n *notifications) get(key, destination string) (string, bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	ref, ok := n.refs[key+"\x00"+destination]
+	return ref, ok
+}
+
+// Remember the reference to the message sent for a key and destination.
+func (n *notifications) set(key, destination, ref string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.refs == nil {
+		n.refs = map[string]string{}
+	}
+
+	id := key + "\x00" + destination
+	if _, ok := n.refs[id]; !ok {
+		n.order = append(n.order, id)
+	}
+	n.refs[id] = ref
+
+	for len(n.order) > maxNotifications {
+		delete(n.refs, n.order[0])
+		n.order = n.order[1:]
+	}
+}
+
+// Forget the message sent for a key and destination, so the next message is
+// sent as a new one.
+func (n *notifications) forget(key, destination string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	id := key + "\x00" + destination
+	if _, ok := n.refs[id]; !ok {
+		return
+	}
+
+	delete(n.refs, id)
+	for i, existing := range n.order {
+		if existing == id {
+			n.order = append(n.order[:i], n.order[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package exporters
+
+import (
+	"sort"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/report"
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
+	"github.com/weaveworks/flux"
+	fluxevent "github.com/weaveworks/flux/event"
+	"github.com/weaveworks/flux/update"
+)
+
+// The versions of the payload that the webhook exporter can send.
+const (
+	// The message encoded as is, its fields change along with Flux's event
+	// type.
+	WebhookPayloadVersionLegacy = "legacy"
+
+	// See WebhookPayloadV1 and schemas/webhook-payload-v1.json.
+	WebhookPayloadVersionV1 = "v1"
+)
+
+// Version 1 of the webhook payload. Fields are only ever added to it, any
+// other change is made in a new version.
+type WebhookPayloadV1 struct {
+	Version string `json:"version"`
+
+	// The kind of message: the Flux event type for events, alert or resolved
+	// for sync error alerts, stalled or recovered for watchdog alerts,
+	// deployment, digest or report.
+	Type string `json:"type"`
+
+	// The type of the Flux event the message is about.
+	EventType string `json:"eventType"`
+
+	EventId   int64     `json:"eventId"`
+	Cluster   string    `json:"cluster,omitempty"`
+	LogLevel  string    `json:"logLevel,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	EndedAt   time.Time `json:"endedAt"`
+
+	// The rendered message.
+	Title     string `json:"title"`
+	TitleLink string `json:"titleLink,omitempty"`
+	Body      string `json:"body"`
+
+	NotificationKey string `json:"notificationKey,omitempty"`
+
+	Commits   []WebhookCommit   `json:"commits"`
+	Resources []WebhookResource `json:"resources"`
+	Images    []WebhookImage    `json:"images"`
+	Errors    []WebhookError    `json:"errors"`
+
+	// The counts of a report, only set for reports.
+	Report *WebhookReport `json:"report,omitempty"`
+}
+
+// A commit that was synced or released.
+type WebhookCommit struct {
+	Revision string `json:"revision"`
+	Message  string `json:"message,omitempty"`
+}
+
+// A resource that the event is about.
+type WebhookResource struct {
+	Id        string `json:"id"`
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+}
+
+// An image that a release changed in one of a workload's containers.
+type WebhookImage struct {
+	Resource  string `json:"resource"`
+	Container string `json:"container"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+}
+
+// A resource that failed to sync or release.
+type WebhookError struct {
+	Resource string `json:"resource"`
+	Path     string `json:"path,omitempty"`
+	Error    string `json:"error"`
+}
+
+// What Flux applied during the period of a report.
+type WebhookReport struct {
+	Events        int                      `json:"events"`
+	Syncs         int                      `json:"syncs"`
+	Releases      int                      `json:"releases"`
+	ChangedImages []string                 `json:"changedImages"`
+	Namespaces    []WebhookReportNamespace `json:"namespaces"`
+}
+
+// What Flux applied to a namespace during the period of a report.
+type WebhookReportNamespace struct {
+	Namespace       string   `json:"namespace"`
+	Syncs           int      `json:"syncs"`
+	Releases        int      `json:"releases"`
+	ChangedImages   []string `json:"changedImages"`
+	FailedResources []string `json:"failedResources"`
+}
+
+// Convert a message to version 1 of the webhook payload. Digests list the
+// commits, resources, images and errors of all of their events, and reports
+// those of their summary.
+func NewWebhookPayloadV1(message msg.Message) WebhookPayloadV1 {
+	event := message.Event
+
+	payload := WebhookPayloadV1{
+		Version:         WebhookPayloadVersionV1,
+		Type:            message.Type,
+		EventType:       event.Type,
+		EventId:         int64(event.ID),
+		Cluster:         message.Cluster,
+		LogLevel:        event.LogLevel,
+		StartedAt:       event.StartedAt,
+		EndedAt:         event.EndedAt,
+		Title:           message.Title,
+		TitleLink:       message.TitleLink,
+		Body:            message.Body,
+		NotificationKey: message.NotificationKey,
+		Commits:         []WebhookCommit{},
+		Resources:       []WebhookResource{},
+		Images:          []WebhookImage{},
+		Errors:          []WebhookError{},
+	}
+
+	if message.Report != nil {
+		payload.addReport(*message.Report)
+		return payload
+	}
+
+	events := message.Events
+	if len(events) == 0 {
+		events = []fluxevent.Event{event}
+	}
+
+	seen := map[string]bool{}
+	for _, event := range events {
+		if event.StartedAt.Before(payload.StartedAt) {
+			payload.StartedAt = event.StartedAt
+		}
+
+		if event.EndedAt.After(payload.EndedAt) {
+			payload.EndedAt = event.EndedAt
+		}
+
+		payload.addEvent(event, seen)
+	}
+
+	return payload
+}
+
+// Add the commits, resources, images and errors of an event to the payload,
+// skipping the commits and resources that an earlier event in seen had.
+func (p *WebhookPayloadV1) addEvent(event fluxevent.Event, seen map[string]bool) {
+	added := map[string]bool{}
+
+	for _, commit := range utils.GetCommits(event.Metadata) {
+		if seen["commit "+commit.Revision] {
+			continue
+		}
+		added["commit "+commit.Revision] = true
+
+		p.Commits = append(p.Commits, WebhookCommit{
+			Revision: commit.Revision,
+			Message:  commit.Message,
+		})
+	}
+
+	for _, id := range utils.GetResourceIDs(event) {
+		if seen["resource "+id.String()] {
+			continue
+		}
+		added["resource "+id.String()] = true
+
+		p.addResource(id)
+	}
+
+	for _, resourceError := range utils.GetErrors(event.Metadata) {
+		p.addError(resourceError)
+	}
+
+	result := utils.GetResult(event.Metadata)
+	for _, id := range sortedIDs(result) {
+		workload := result[id]
+
+		switch workload.Status {
+		case update.ReleaseStatusSuccess:
+			for _, container := range workload.PerContainer {
+				p.Images = append(p.Images, WebhookImage{
+					Resource:  id.String(),
+					Container: container.Container,
+					From:      container.Current.String(),
+					To:        container.Target.String(),
+				})
+			}
+		case update.ReleaseStatusFailed:
+			p.Errors = append(p.Errors, WebhookError{
+				Resource: id.String(),
+				Error:    workload.Error,
+			})
+		}
+	}
+
+	for key := range added {
+		seen[key] = true
+	}
+}
+
+// Add the period, commits, resources and errors of a report to the payload,
+// along with its counts.
+func (p *WebhookPayloadV1) addReport(summary report.Report) {
+	p.StartedAt = summary.Start
+	p.EndedAt = summary.End
+
+	for _, commit := range summary.Commits {
+		p.Commits = append(p.Commits, WebhookCommit{
+			Revision: commit.Revision,
+			Message:  commit.Message,
+		})
+	}
+
+	for _, id := range summary.Resources {
+		p.addResource(id)
+	}
+
+	for _, resourceError := range summary.Errors {
+		p.addError(resourceError)
+	}
+
+	p.Report = &WebhookReport{
+		Events:        summary.Events,
+		Syncs:         summary.Syncs,
+		Releases:      summary.Releases,
+		ChangedImages: append([]string{}, summary.ChangedImages...),
+		Namespaces:    []WebhookReportNamespace{},
+	}
+
+	for _, namespace := range summary.Namespaces {
+		failed := []string{}
+		for _, id := range namespace.FailedResources {
+			failed = append(failed, id.String())
+		}
+
+		p.Report.Namespaces = append(p.Report.Namespaces, WebhookReportNamespace{
+			Namespace:       namespace.Namespace,
+			Syncs:           namespace.Syncs,
+			Releases:        namespace.Releases,
+			ChangedImages:   append([]string{}, namespace.ChangedImages...),
+			FailedResources: failed,
+		})
+	}
+}
+
+func (p *WebhookPayloadV1) addResource(id flux.ResourceID) {
+	namespace, kind, name := id.Components()
+	p.Resources = append(p.Resources, WebhookResource{
+		Id:        id.String(),
+		Namespace: namespace,
+		Kind:      kind,
+		Name:      name,
+	})
+}
+
+func (p *WebhookPayloadV1) addError(resourceError fluxevent.ResourceError) {
+	p.Errors = append(p.Errors, WebhookError{
+		Resource: resourceError.ID.String(),
+		Path:     resourceError.Path,
+		Error:    resourceError.Error,
+	})
+}
+
+// Return the workloads in a release result in a stable order.
+func sortedIDs(result update.Result) []flux.ResourceID {
+	ids := []flux.ResourceID{}
+	for id := range result {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].String() < ids[j].String()
+	})
+
+	return ids
+}
@@ -0,0 +1,212 @@
+package exporters
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/report"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/flux"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+func TestNewWebhookPayloadV1AutoRelease(t *testing.T) {
+	payload := NewWebhookPayloadV1(msg.Message{
+		Type:    "autorelease",
+		Title:   "Applying changes",
+		Body:    "the body",
+		Cluster: "production",
+		Event:   test_utils.NewFluxAutoReleaseEvent(),
+	})
+
+	assert.Equal(t, "v1", payload.Version)
+	assert.Equal(t, "autorelease", payload.Type)
+	assert.Equal(t, "autorelease", payload.EventType)
+	assert.Equal(t, "production", payload.Cluster)
+	assert.Equal(t, "Applying changes", payload.Title)
+	assert.Equal(t, "the body", payload.Body)
+	assert.Equal(t, []WebhookCommit{}, payload.Commits)
+	assert.Equal(t, []WebhookResource{
+		{Id: "default:deployment/test", Namespace: "default", Kind: "deployment", Name: "test"},
+	}, payload.Resources)
+	assert.Equal(t, []WebhookImage{
+		{
+			Resource:  "default:deployment/test",
+			Container: "test2",
+			From:      "justinbarrick/nginx:test1",
+			To:        "justinbarrick/nginx:test3",
+		},
+	}, payload.Images)
+	assert.Equal(t, []WebhookError{}, payload.Errors)
+}
+
+func TestNewWebhookPayloadV1SyncErrors(t *testing.T) {
+	payload := NewWebhookPayloadV1(msg.Message{
+		Type:  "sync",
+		Event: test_utils.NewFluxSyncErrorEvent(),
+	})
+
+	assert.Equal(t, []WebhookCommit{
+		{Revision: "4997efcd4ac6255604d0d44eeb7085c5b0eb9d48", Message: "create invalid resource"},
+	}, payload.Commits)
+	assert.Len(t, payload.Resources, 3)
+	require.Len(t, payload.Errors, 2)
+	assert.Equal(t, "default:persistentvolumeclaim/test", payload.Errors[0].Resource)
+	assert.Equal(t, "manifests/test.yaml", payload.Errors[0].Path)
+	assert.Contains(t, payload.Errors[0].Error, "field is immutable")
+	assert.Equal(t, []WebhookImage{}, payload.Images)
+}
+
+func TestNewWebhookPayloadV1Digest(t *testing.T) {
+	first := test_utils.NewFluxSyncEvent()
+	first.ServiceIDs = []flux.ResourceID{flux.MustParseResourceID("a:deployment/api")}
+	last := test_utils.NewFluxSyncErrorEvent()
+
+	payload := NewWebhookPayloadV1(msg.Message{
+		Type:    "digest",
+		Cluster: "production",
+		Event:   last,
+		Events:  []fluxevent.Event{first, last, first},
+	})
+
+	assert.Equal(t, "digest", payload.Type)
+	assert.Equal(t, "production", payload.Cluster)
+	assert.Equal(t, first.StartedAt, payload.StartedAt)
+	assert.Equal(t, last.EndedAt, payload.EndedAt)
+	assert.Equal(t, []WebhookCommit{
+		{Revision: "810c2e6f22ac5ab7c831fe0dd697fe32997b098f", Message: "change test image"},
+		{Revision: "4997efcd4ac6255604d0d44eeb7085c5b0eb9d48", Message: "create invalid resource"},
+	}, payload.Commits)
+
+	resources := []string{}
+	for _, resource := range payload.Resources {
+		resources = append(resources, resource.Id)
+	}
+	assert.Equal(t, []string{
+		"a:deployment/api",
+		"default:persistentvolumeclaim/test",
+		"default:persistentvolumeclaim/test",
+		"default:persistentvolumeclaim/lol",
+	}, resources)
+	assert.Len(t, payload.Errors, 2)
+	assert.Nil(t, payload.Report)
+}
+
+func TestNewWebhookPayloadV1Report(t *testing.T) {
+	start := time.Date(2019, 4, 8, 9, 0, 0, 0, time.UTC)
+	end := time.Date(2019, 4, 15, 9, 0, 0, 0, time.UTC)
+
+	summary := report.NewReport([]fluxevent.Event{
+		test_utils.NewFluxSyncErrorEvent(),
+		test_utils.NewFluxAutoReleaseEvent(),
+	}, start, end)
+
+	payload := NewWebhookPayloadV1(msg.Message{
+		Type:   "report",
+		Title:  "Flux deployment report",
+		Report: &summary,
+	})
+
+	assert.Equal(t, "report", payload.Type)
+	assert.Equal(t, start, payload.StartedAt)
+	assert.Equal(t, end, payload.EndedAt)
+	assert.Equal(t, []WebhookCommit{
+		{Revision: "4997efcd4ac6255604d0d44eeb7085c5b0eb9d48", Message: "create invalid resource"},
+	}, payload.Commits)
+	assert.Len(t, payload.Resources, 3)
+	require.Len(t, payload.Errors, 2)
+	assert.Equal(t, "default:persistentvolumeclaim/test", payload.Errors[0].Resource)
+	assert.Equal(t, "manifests/test.yaml", payload.Errors[0].Path)
+
+	require.NotNil(t, payload.Report)
+	assert.Equal(t, 2, payload.Report.Events)
+	assert.Equal(t, 1, payload.Report.Syncs)
+	assert.Equal(t, 1, payload.Report.Releases)
+	assert.Equal(t, []string{"justinbarrick/nginx:test3"}, payload.Report.ChangedImages)
+	assert.Equal(t, []WebhookReportNamespace{
+		{
+			Namespace:     "default",
+			Syncs:         1,
+			Releases:      1,
+			ChangedImages: []string{"justinbarrick/nginx:test3"},
+			FailedResources: []string{
+				"default:persistentvolumeclaim/test",
+				"default:persistentvolumeclaim/lol",
+			},
+		},
+	}, payload.Report.Namespaces)
+}
+
+type schema struct {
+	Required   []string          `json:"required"`
+	Properties map[string]schema `json:"properties"`
+	Items      *schema           `json:"items"`
+	Enum       []string          `json:"enum"`
+}
+
+// Return the JSON field names of a struct, and the ones that are always set.
+func jsonFields(t reflect.Type) (fields []string, required []string) {
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("json"), ",")
+		fields = append(fields, tag[0])
+		if len(tag) == 1 {
+			required = append(required, tag[0])
+		}
+	}
+	return
+}
+
+func assertMatchesSchema(t *testing.T, s schema, typ reflect.Type) {
+	fields, required := jsonFields(typ)
+	assert.ElementsMatch(t, required, s.Required, typ.Name())
+
+	properties := []string{}
+	for name := range s.Properties {
+		properties = append(properties, name)
+	}
+	assert.ElementsMatch(t, fields, properties, typ.Name())
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.Type.Kind() == reflect.Slice && s.Properties[name].Items != nil && field.Type.Elem().Kind() == reflect.Struct {
+			assertMatchesSchema(t, *s.Properties[name].Items, field.Type.Elem())
+		}
+		if field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct {
+			assertMatchesSchema(t, s.Properties[name], field.Type.Elem())
+		}
+	}
+}
+
+func TestWebhookPayloadV1MatchesSchema(t *testing.T) {
+	data, err := ioutil.ReadFile("../../schemas/webhook-payload-v1.json")
+	require.NoError(t, err)
+
+	s := schema{}
+	require.NoError(t, json.Unmarshal(data, &s))
+
+	assertMatchesSchema(t, s, reflect.TypeOf(WebhookPayloadV1{}))
+}
+
+func TestWebhookPayloadV1Types(t *testing.T) {
+	data, err := ioutil.ReadFile("../../schemas/webhook-payload-v1.json")
+	require.NoError(t, err)
+
+	s := schema{}
+	require.NoError(t, json.Unmarshal(data, &s))
+
+	// the types set by the formatters, and every Flux event type
+	assert.ElementsMatch(t, []string{
+		fluxevent.EventCommit, fluxevent.EventSync, fluxevent.EventRelease, fluxevent.EventAutoRelease,
+		fluxevent.EventAutomate, fluxevent.EventDeautomate, fluxevent.EventLock, fluxevent.EventUnlock,
+		fluxevent.EventUpdatePolicy,
+		"alert", "resolved", "stalled", "recovered", "deployment", "digest", "report",
+	}, s.Properties["type"].Enum)
+}
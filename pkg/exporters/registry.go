@@ -0,0 +1,178 @@
+package exporters
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+)
+
+// Creates an exporter from its configuration.
+type Constructor func(config config.Config) (Exporter, error)
+
+var registry = map[string]Constructor{}
+
+// How long an exporter may take to send a message unless configured otherwise.
+const DefaultTimeout = 120 * time.Second
+
+// Register an exporter type so that it can be selected with EXPORTER_TYPE.
+// Exporters register themselves from init, registering the same name twice
+// panics.
+func Register(name string, constructor Constructor) {
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("exporter %s registered twice", name))
+	}
+
+	registry[name] = constructor
+}
+
+// Return the names of all registered exporter types in sorted order.
+func Names() []string {
+	names := []string{}
+	for name := range registry {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Create an exporter by its registered name.
+//
+// The name may include an instance name after a colon, such as "slack:ops", in
+// which case the exporter reads its settings with the instance name as a prefix
+// (OPS_SLACK_URL) and the instance name is included in its Name().
+func New(name string, c config.Config) (Exporter, error) {
+	exporterType, instanceName := parseExporterName(name)
+
+	constructor, ok := registry[exporterType]
+	if !ok {
+		return nil, fmt.Errorf("Unknown exporter type %q, valid types are: %s", exporterType, strings.Join(Names(), ", "))
+	}
+
+	if instanceName != "" {
+		c = config.NewPrefixedConfig(c, instanceName)
+	}
+
+	timeout, err := time.ParseDuration(c.Optional(exporterType+"_timeout", c.Optional("exporter_timeout", DefaultTimeout.String())))
+	if err != nil {
+		return nil, fmt.Errorf("Exporter %s: invalid timeout: %s", name, err)
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("Exporter %s: invalid timeout: %s must be more than 0", name, timeout)
+	}
+
+	exporter, err := constructor(c)
+	if err != nil {
+		if instanceName == "" {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Exporter %s: %s", name, err)
+	}
+
+	return &instance{
+		Exporter: exporter,
+		spec:     name,
+		name:     instanceName,
+		config:   c,
+		timeout:  timeout,
+	}, nil
+}
+
+// Create exporters from a comma separated list of exporter names, as accepted
+// by New. Each name may only be used once.
+func NewExporters(names string, c config.Config) ([]Exporter, error) {
+	exporters := []Exporter{}
+	seen := map[string]bool{}
+
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if seen[name] {
+			return nil, fmt.Errorf("Exporter %s configured more than once", name)
+		}
+		seen[name] = true
+
+		exporter, err := New(name, c)
+		if err != nil {
+			return nil, err
+		}
+
+		exporters = append(exporters, exporter)
+	}
+
+	return exporters, nil
+}
+
+func parseExporterName(name string) (string, string) {
+	parts := strings.SplitN(name, ":", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
+
+// Return the configuration that an exporter was created with: the prefixed
+// configuration for named instances, or c otherwise.
+func InstanceConfig(exporter Exporter, c config.Config) config.Config {
+	if i, ok := exporter.(*instance); ok {
+		return i.config
+	}
+
+	return c
+}
+
+// Return how long an exporter may take to send a message, configured with the
+// <type>_timeout or exporter_timeout settings.
+func Timeout(exporter Exporter) time.Duration {
+	if i, ok := exporter.(*instance); ok {
+		return i.timeout
+	}
+
+	return DefaultTimeout
+}
+
+// Return the name that an exporter was created with, such as "slack" or
+// "slack:ops", or its Name() if it was not created by the registry.
+func Spec(exporter Exporter) string {
+	if i, ok := exporter.(*instance); ok {
+		return i.spec
+	}
+
+	return exporter.Name()
+}
+
+// Return the registered type of an exporter, such as "slack" for "slack:ops".
+func Type(exporter Exporter) string {
+	exporterType, _ := parseExporterName(Spec(exporter))
+	return exporterType
+}
+
+// Return the exporter that was created by the registry, for type assertions.
+func Unwrap(exporter Exporter) Exporter {
+	if i, ok := exporter.(*instance); ok {
+		return i.Exporter
+	}
+
+	return exporter
+}
+
+// An exporter created from the registry, optionally a named instance.
+type instance struct {
+	Exporter
+	spec    string
+	name    string
+	config  config.Config
+	timeout time.Duration
+}
+
+// Return the name of the exporter including its instance name.
+func (i *instance) Name() string {
+	if i.name == "" {
+		return i.Exporter.Name()
+	}
+
+	return fmt.Sprintf("%s (%s)", i.Exporter.Name(), i.name)
+}
@@ -0,0 +1,195 @@
+package exporters
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryNames(t *testing.T) {
+	assert.Equal(t, []string{"matrix", "msteams", "slack", "webhook"}, Names())
+}
+
+func TestRegistryMissingConfig(t *testing.T) {
+	for _, name := range Names() {
+		exporter, err := New(name, config.NewFakeConfig())
+		assert.NotNil(t, err, name)
+		assert.Nil(t, exporter, name)
+	}
+}
+
+func TestRegistryNew(t *testing.T) {
+	config := config.NewFakeConfig()
+	config.Set("webhook_url", "https://mywebhook/")
+
+	exporter, err := New("webhook", config)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://mywebhook/", Unwrap(exporter).(*Webhook).Url)
+}
+
+func TestRegistryUnknown(t *testing.T) {
+	_, err := New("irc", config.NewFakeConfig())
+	assert.EqualError(t, err, `Unknown exporter type "irc", valid types are: matrix, msteams, slack, webhook`)
+}
+
+func TestRegistryRegisterTwice(t *testing.T) {
+	assert.Panics(t, func() {
+		Register("slack", func(config.Config) (Exporter, error) {
+			return nil, nil
+		})
+	})
+}
+
+func TestRegistryNewInstance(t *testing.T) {
+	config := config.NewFakeConfig()
+	config.Set("ops_slack_url", "https://ops/")
+	config.Set("ops_slack_channel", "#ops")
+	config.Set("slack_username", "Shared Username")
+
+	exporter, err := New("slack:ops", config)
+	assert.Nil(t, err)
+	assert.Equal(t, "Slack (ops)", exporter.Name())
+
+	slack := Unwrap(exporter).(*Slack)
+	assert.Equal(t, "https://ops/", slack.Url)
+	assert.Equal(t, []SlackChannel{SlackChannel{"#ops", "*"}}, slack.Channels)
+	assert.Equal(t, "Shared Username", slack.Username)
+}
+
+func TestRegistryNewInstanceCredentials(t *testing.T) {
+	config := config.NewFakeConfig()
+	config.Set("slack_url", "https://shared/")
+	config.Set("slack_channel", "#shared")
+	config.Set("slack_token", "shared-token")
+	config.Set("ops_slack_url", "https://ops/")
+	config.Set("ops_slack_channel", "#ops")
+	config.Set("webhook_url", "https://shared/")
+	config.Set("webhook_secret", "shared-secret")
+	config.Set("webhook_bearer_token", "shared-token")
+	config.Set("webhook_headers", "X-Api-Key=shared")
+	config.Set("ops_webhook_url", "https://ops/")
+
+	exporter, err := New("slack", config)
+	assert.Nil(t, err)
+	assert.Equal(t, "shared-token", Unwrap(exporter).(*Slack).Token)
+
+	exporter, err = New("slack:ops", config)
+	assert.Nil(t, err)
+	assert.Equal(t, "", Unwrap(exporter).(*Slack).Token)
+
+	exporter, err = New("webhook:ops", config)
+	assert.Nil(t, err)
+	webhook := Unwrap(exporter).(*Webhook)
+	assert.Equal(t, "", webhook.Secret)
+	assert.Equal(t, http.Header{}, webhook.Header)
+	assert.Len(t, webhook.HeaderTemplates, 0)
+
+	config.Set("ops_webhook_password", "ops-password")
+	exporter, err = New("webhook:ops", config)
+	assert.Nil(t, err)
+	assert.Contains(t, Unwrap(exporter).(*Webhook).Header.Get("Authorization"), "Basic ")
+}
+
+func TestRegistryNewInstanceMissingConfig(t *testing.T) {
+	config := config.NewFakeConfig()
+	config.Set("slack_url", "https://shared/")
+	config.Set("slack_channel", "#shared")
+
+	_, err := New("slack:ops", config)
+	assert.EqualError(t, err, "Exporter slack:ops: Required setting OPS_SLACK_URL not set")
+}
+
+func TestNewExporters(t *testing.T) {
+	config := config.NewFakeConfig()
+	config.Set("webhook_url", "https://mywebhook/")
+	config.Set("ops_msteams_url", "https://ops/")
+	config.Set("dev_msteams_url", "https://dev/")
+
+	exporters, err := NewExporters("webhook, msteams:ops,msteams:dev", config)
+	assert.Nil(t, err)
+	assert.Len(t, exporters, 3)
+	assert.Equal(t, "Webhook", exporters[0].Name())
+	assert.Equal(t, "MS Teams (ops)", exporters[1].Name())
+	assert.Equal(t, "MS Teams (dev)", exporters[2].Name())
+}
+
+func TestNewExportersDuplicate(t *testing.T) {
+	config := config.NewFakeConfig()
+	config.Set("ops_msteams_url", "https://ops/")
+
+	_, err := NewExporters("msteams:ops,msteams:ops", config)
+	assert.EqualError(t, err, "Exporter msteams:ops configured more than once")
+}
+
+func TestInstanceConfig(t *testing.T) {
+	config := config.NewFakeConfig()
+	config.Set("webhook_url", "https://mywebhook/")
+	config.Set("ops_webhook_url", "https://ops/")
+
+	webhook, err := New("webhook", config)
+	assert.Nil(t, err)
+	assert.Equal(t, config, InstanceConfig(webhook, config))
+
+	ops, err := New("webhook:ops", config)
+	assert.Nil(t, err)
+	url, _ := InstanceConfig(ops, config).Required("webhook_url")
+	assert.Equal(t, "https://ops/", url)
+}
+
+func TestTimeout(t *testing.T) {
+	config := config.NewFakeConfig()
+	config.Set("webhook_url", "https://mywebhook/")
+	config.Set("ops_webhook_url", "https://ops/")
+	config.Set("dev_webhook_url", "https://dev/")
+	config.Set("exporter_timeout", "30s")
+	config.Set("dev_webhook_timeout", "5s")
+
+	assert.Equal(t, DefaultTimeout, Timeout(&FakeExporter{}))
+
+	webhook, err := New("webhook", config)
+	assert.Nil(t, err)
+	assert.Equal(t, 30*time.Second, Timeout(webhook))
+
+	dev, err := New("webhook:dev", config)
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Second, Timeout(dev))
+
+	config.Set("ops_exporter_timeout", "soon")
+	_, err = New("webhook:ops", config)
+	assert.NotNil(t, err)
+
+	// a timeout of 0 would fail every send at once
+	for _, timeout := range []string{"0s", "-5s"} {
+		config.Set("ops_exporter_timeout", timeout)
+		_, err = New("webhook:ops", config)
+		assert.NotNil(t, err, timeout)
+	}
+}
+
+func TestSpecAndType(t *testing.T) {
+	config := config.NewFakeConfig()
+	config.Set("ops_webhook_url", "https://ops/")
+
+	ops, err := New("webhook:ops", config)
+	assert.Nil(t, err)
+	assert.Equal(t, "webhook:ops", Spec(ops))
+	assert.Equal(t, "webhook", Type(ops))
+
+	fake := &FakeExporter{}
+	assert.Equal(t, "Fake", Spec(fake))
+}
+
+func TestCheck(t *testing.T) {
+	config := config.NewFakeConfig()
+	config.Set("ops_webhook_url", "ops")
+
+	ops, err := New("webhook:ops", config)
+	assert.Nil(t, err)
+	assert.NotNil(t, Check(context.TODO(), &http.Client{}, ops))
+
+	assert.Nil(t, Check(context.TODO(), &http.Client{}, &FakeExporter{}))
+}
@@ -0,0 +1,330 @@
+package exporters
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+	"golang.org/x/time/rate"
+)
+
+// The delay before retrying a failed request, doubled after each attempt.
+var retryBackoff = 500 * time.Millisecond
+
+// The delay before retrying a rate limited request without a Retry-After.
+var defaultRetryAfter = time.Second
+
+// How often limiters for hosts that are no longer sent to are dropped.
+var limiterSweep = time.Minute
+
+// An error response from an exporter's upstream.
+type HTTPError struct {
+	Service    string
+	StatusCode int
+
+	// How long the upstream asked us to wait before trying again, if set.
+	Delay time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("Could not post to %s, status: %d", e.Service, e.StatusCode)
+}
+
+// Return how long to wait before sending to the upstream again, used by the
+// delivery queue to schedule its next attempt.
+func (e *HTTPError) RetryAfter() time.Duration {
+	return e.Delay
+}
+
+// Returns true if the request may succeed if it is sent again.
+func (e *HTTPError) Temporary() bool {
+	return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Returns true if sending the request again cannot succeed, such as when the
+// upstream rejected it with a 4xx other than 408 or 429. The delivery queue
+// gives up on these at once instead of retrying them.
+func (e *HTTPError) Permanent() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500 && !e.Temporary()
+}
+
+// Sends JSON messages to an exporter's upstream. Requests to each destination
+// host are rate limited with a token bucket, and requests that are rate limited
+// (429, honouring Retry-After) or fail with a 5xx are retried until the
+// context's deadline.
+//
+// A Sender without a rate limit or retries sends every request once.
+type Sender struct {
+	Service    string
+	MaxRetries int
+
+	limit    rate.Limit
+	burst    int
+	lock     sync.Mutex
+	limiters map[string]*hostLimiter
+	swept    time.Time
+}
+
+// The rate limiter for a destination host and when it was last used.
+type hostLimiter struct {
+	*rate.Limiter
+	used time.Time
+}
+
+// Create a Sender for an exporter, configured with the <prefix>_rate_limit
+// (requests per second), <prefix>_rate_burst and <prefix>_max_retries
+// settings.
+func NewSender(service string, prefix string, config config.Config) (*Sender, error) {
+	limit, err := strconv.ParseFloat(config.Optional(prefix+"_rate_limit", "1"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s_rate_limit: %s", prefix, err)
+	} else if limit < 0 {
+		return nil, fmt.Errorf("Invalid %s_rate_limit: %v, must be 0 or more", prefix, limit)
+	}
+
+	burst, err := strconv.Atoi(config.Optional(prefix+"_rate_burst", "5"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s_rate_burst: %s", prefix, err)
+	} else if burst <= 0 {
+		return nil, fmt.Errorf("Invalid %s_rate_burst: %d, must be more than 0", prefix, burst)
+	}
+
+	maxRetries, err := strconv.Atoi(config.Optional(prefix+"_max_retries", "3"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s_max_retries: %s", prefix, err)
+	} else if maxRetries < 0 {
+		return nil, fmt.Errorf("Invalid %s_max_retries: %d, must be 0 or more", prefix, maxRetries)
+	}
+
+	return &Sender{
+		Service:    service,
+		MaxRetries: maxRetries,
+		limit:      rate.Limit(limit),
+		burst:      burst,
+	}, nil
+}
+
+// An HTTP request sent by a Sender.
+type Request struct {
+	// The HTTP method, POST if it is empty.
+	Method string
+	Url    string
+	Header http.Header
+	Body   []byte
+
+	// If set, called with each attempt before it is sent, for example to
+	// sign it.
+	Prepare func(req *http.Request, body []byte)
+}
+
+// POST a JSON payload to url with optional extra headers.
+func (s *Sender) PostJSON(ctx context.Context, client *http.Client, url string, header http.Header, payload interface{}) error {
+	return s.PostJSONResponse(ctx, client, url, header, payload, nil)
+}
+
+// POST a JSON payload to url with optional extra headers, decoding the JSON
+// response into response if it is not nil. A response that cannot be decoded
+// is logged but is not an error, since the request succeeded.
+func (s *Sender) PostJSONResponse(ctx context.Context, client *http.Client, url string, header http.Header, payload interface{}, response interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	jsonHeader := http.Header{}
+	for key, values := range header {
+		jsonHeader[key] = values
+	}
+	jsonHeader.Set("Content-Type", "application/json")
+
+	return s.Do(ctx, client, Request{
+		Url:    url,
+		Header: jsonHeader,
+		Body:   body,
+	}, response)
+}
+
+// Send a request, decoding the JSON response into response if it is not
+// nil. Requests are rate limited and retried like PostJSON.
+func (s *Sender) Do(ctx context.Context, client *http.Client, request Request, response interface{}) error {
+	for attempt := 0; ; attempt++ {
+		if err := s.wait(ctx, request.Url); err != nil {
+			return err
+		}
+
+		err := s.send(ctx, client, request, response)
+		if err == nil || attempt >= s.MaxRetries {
+			return err
+		}
+
+		delay := retryBackoff << uint(attempt)
+		if httpErr, ok := err.(*HTTPError); ok {
+			if !httpErr.Temporary() {
+				return err
+			}
+
+			if httpErr.StatusCode == http.StatusTooManyRequests {
+				delay = httpErr.Delay
+				if delay == 0 {
+					delay = defaultRetryAfter
+				}
+			}
+		} else if ctx.Err() != nil {
+			return err
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return err
+		}
+
+		logging.Warn("Retrying request", "service", s.Service, "delay", delay, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (s *Sender) send(ctx context.Context, client *http.Client, request Request, response interface{}) error {
+	method := request.Method
+	if method == "" {
+		method = "POST"
+	}
+
+	req, err := http.NewRequest(method, request.Url, bytes.NewReader(request.Body))
+	if err != nil {
+		return redactURL(err)
+	}
+
+	for key, values := range request.Header {
+		req.Header[key] = values
+	}
+	req = req.WithContext(ctx)
+
+	if request.Prepare != nil {
+		request.Prepare(req, request.Body)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		err = redactURL(err)
+		logging.Warn("Could not post", "service", s.Service, "err", err)
+		return err
+	}
+	defer res.Body.Close()
+	defer io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		logging.Warn("Could not post", "service", s.Service, "status", res.Status)
+		return &HTTPError{
+			Service:    s.Service,
+			StatusCode: res.StatusCode,
+			Delay:      parseRetryAfter(res.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+
+	if response != nil {
+		if err := json.NewDecoder(res.Body).Decode(response); err != nil {
+			logging.Warn("Could not read response", "service", s.Service, "err", err)
+		}
+	}
+
+	return nil
+}
+
+// Redact the path and query of the URL in an error from the HTTP client, since
+// URLs such as rendered webhook URLs can hold tokens.
+func redactURL(err error) error {
+	urlErr, ok := err.(*url.Error)
+	if !ok {
+		return err
+	}
+
+	redacted := *urlErr
+	redacted.URL = logging.RedactURL(urlErr.URL)
+	return &redacted
+}
+
+// Wait for the rate limit of the destination's host.
+func (s *Sender) wait(ctx context.Context, destination string) error {
+	if s.limit <= 0 {
+		return nil
+	}
+
+	host := destination
+	if parsed, err := url.Parse(destination); err == nil && parsed.Host != "" {
+		host = parsed.Host
+	}
+
+	now := time.Now()
+
+	s.lock.Lock()
+	s.sweep(now)
+	limiter, ok := s.limiters[host]
+	if !ok {
+		limiter = &hostLimiter{Limiter: rate.NewLimiter(s.limit, s.burst)}
+		s.limiters[host] = limiter
+	}
+	limiter.used = now
+	s.lock.Unlock()
+
+	return limiter.Wait(ctx)
+}
+
+// Drop the limiters that have been idle long enough to have refilled, since
+// they allow the same as a new limiter. Must be called with the lock held.
+func (s *Sender) sweep(now time.Time) {
+	if s.limiters == nil {
+		s.limiters = map[string]*hostLimiter{}
+	}
+
+	if now.Sub(s.swept) < limiterSweep {
+		return
+	}
+	s.swept = now
+
+	idle := time.Duration(float64(s.burst) / float64(s.limit) * float64(time.Second))
+	for host, limiter := range s.limiters {
+		if now.Sub(limiter.used) > idle {
+			delete(s.limiters, host)
+		}
+	}
+}
+
+// Return sender, or a Sender that sends each request once if it is nil.
+func senderOrDefault(sender *Sender, service string) *Sender {
+	if sender == nil {
+		return &Sender{Service: service}
+	}
+
+	return sender
+}
+
+// Parse a Retry-After header, which is either a number of seconds or a date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
+}
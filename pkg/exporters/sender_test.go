@@ -0,0 +1,240 @@
+package exporters
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	retryBackoff = time.Millisecond
+	defaultRetryAfter = time.Millisecond
+}
+
+func newTestServer(statuses ...int) (*httptest.Server, *int32) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(atomic.AddInt32(&requests, 1)) - 1
+		if i < len(statuses) {
+			w.WriteHeader(statuses[i])
+		}
+	}))
+	return ts, &requests
+}
+
+func TestNewSender(t *testing.T) {
+	config := config.NewFakeConfig()
+
+	sender, err := NewSender("Slack", "slack", config)
+	assert.Nil(t, err)
+	assert.Equal(t, "Slack", sender.Service)
+	assert.Equal(t, 3, sender.MaxRetries)
+
+	config.Set("slack_max_retries", "lots")
+	_, err = NewSender("Slack", "slack", config)
+	assert.NotNil(t, err)
+}
+
+func TestNewSenderOutOfRange(t *testing.T) {
+	for _, test := range []struct {
+		setting  string
+		value    string
+		expected string
+	}{
+		{"slack_rate_limit", "-1", "Invalid slack_rate_limit: -1, must be 0 or more"},
+		{"slack_rate_burst", "0", "Invalid slack_rate_burst: 0, must be more than 0"},
+		{"slack_max_retries", "-1", "Invalid slack_max_retries: -1, must be 0 or more"},
+	} {
+		config := config.NewFakeConfig()
+		config.Set(test.setting, test.value)
+
+		_, err := NewSender("Slack", "slack", config)
+		assert.EqualError(t, err, test.expected)
+	}
+
+	config := config.NewFakeConfig()
+	config.Set("slack_rate_limit", "0")
+	sender, err := NewSender("Slack", "slack", config)
+	assert.Nil(t, err)
+	assert.Nil(t, sender.wait(context.TODO(), "https://hooks.slack.com/"))
+}
+
+func TestSenderRetriesRateLimited(t *testing.T) {
+	ts, requests := newTestServer(429, 429)
+	defer ts.Close()
+
+	sender := &Sender{Service: "Slack", MaxRetries: 3}
+	err := sender.PostJSON(context.TODO(), &http.Client{}, ts.URL, nil, map[string]string{})
+	assert.Nil(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(requests))
+}
+
+func TestSenderRetriesServerErrors(t *testing.T) {
+	ts, requests := newTestServer(500, 502, 503, 504)
+	defer ts.Close()
+
+	sender := &Sender{Service: "Slack", MaxRetries: 3}
+	err := sender.PostJSON(context.TODO(), &http.Client{}, ts.URL, nil, map[string]string{})
+	assert.EqualError(t, err, "Could not post to Slack, status: 504")
+	assert.Equal(t, int32(4), atomic.LoadInt32(requests))
+}
+
+func TestSenderDoesNotRetryClientErrors(t *testing.T) {
+	ts, requests := newTestServer(404)
+	defer ts.Close()
+
+	sender := &Sender{Service: "Slack", MaxRetries: 3}
+	err := sender.PostJSON(context.TODO(), &http.Client{}, ts.URL, nil, map[string]string{})
+	assert.EqualError(t, err, "Could not post to Slack, status: 404")
+	assert.Equal(t, int32(1), atomic.LoadInt32(requests))
+}
+
+func TestHTTPErrorPermanent(t *testing.T) {
+	for status, permanent := range map[int]bool{
+		400: true,
+		403: true,
+		404: true,
+		408: false,
+		429: false,
+		500: false,
+		503: false,
+	} {
+		err := &HTTPError{Service: "Slack", StatusCode: status}
+		assert.Equal(t, permanent, err.Permanent(), status)
+		assert.Equal(t, !permanent, err.Temporary(), status)
+	}
+}
+
+func TestSenderRetryAfterPastDeadline(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(429)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	sender := &Sender{Service: "Slack", MaxRetries: 3}
+	err := sender.PostJSON(ctx, &http.Client{}, ts.URL, nil, map[string]string{})
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	httpErr, ok := err.(*HTTPError)
+	assert.True(t, ok)
+	assert.Equal(t, 429, httpErr.StatusCode)
+	assert.Equal(t, 30*time.Second, httpErr.RetryAfter())
+}
+
+func TestSenderRateLimit(t *testing.T) {
+	ts, requests := newTestServer()
+	defer ts.Close()
+
+	sender := &Sender{
+		Service: "Slack",
+		limit:   20,
+		burst:   1,
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, sender.PostJSON(context.TODO(), &http.Client{}, ts.URL, nil, map[string]string{}))
+	}
+
+	assert.True(t, time.Since(start) >= 90*time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(requests))
+}
+
+func TestSenderRateLimitPerHost(t *testing.T) {
+	ts, requests := newTestServer()
+	defer ts.Close()
+
+	sender := &Sender{
+		Service: "Slack",
+		limit:   20,
+		burst:   1,
+	}
+
+	start := time.Now()
+	for _, path := range []string{"/one", "/two", "/three"} {
+		assert.Nil(t, sender.PostJSON(context.TODO(), &http.Client{}, ts.URL+path, nil, map[string]string{}))
+	}
+
+	assert.True(t, time.Since(start) >= 90*time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(requests))
+	assert.Len(t, sender.limiters, 1)
+}
+
+func TestSenderDropsIdleLimiters(t *testing.T) {
+	sender := &Sender{
+		Service: "Slack",
+		limit:   10,
+		burst:   5,
+	}
+
+	assert.Nil(t, sender.wait(context.TODO(), "https://one.example.com/hook"))
+	assert.Nil(t, sender.wait(context.TODO(), "https://two.example.com/hook"))
+	assert.Len(t, sender.limiters, 2)
+
+	sender.lock.Lock()
+	sender.limiters["one.example.com"].used = time.Now().Add(-time.Second)
+	sender.swept = time.Time{}
+	sender.lock.Unlock()
+
+	assert.Nil(t, sender.wait(context.TODO(), "https://two.example.com/hook"))
+	assert.Len(t, sender.limiters, 1)
+	assert.Contains(t, sender.limiters, "two.example.com")
+}
+
+func TestSenderHeaders(t *testing.T) {
+	var auth, contentType string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		contentType = r.Header.Get("Content-Type")
+	}))
+	defer ts.Close()
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer token")
+
+	err := senderOrDefault(nil, "Slack").PostJSON(context.TODO(), &http.Client{}, ts.URL, header, map[string]string{})
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer token", auth)
+	assert.Equal(t, "application/json", contentType)
+}
+
+func TestSenderRedactsURLs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.Close()
+
+	// rendered webhook URLs can hold tokens, errors include the URL
+	err := senderOrDefault(nil, "Webhook").Do(context.TODO(), &http.Client{}, Request{
+		Url: ts.URL + "/deploys/production/SECRETTOKEN123",
+	}, nil)
+	assert.NotNil(t, err)
+	assert.NotContains(t, err.Error(), "SECRETTOKEN123")
+	assert.Contains(t, err.Error(), "/REDACTED")
+
+	err = senderOrDefault(nil, "Webhook").Do(context.TODO(), &http.Client{}, Request{
+		Url: "http://[::1/SECRETTOKEN123",
+	}, nil)
+	assert.NotNil(t, err)
+	assert.NotContains(t, err.Error(), "SECRETTOKEN123")
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120", now))
+	assert.Equal(t, time.Minute, parseRetryAfter("Tue, 01 Jan 2019 00:01:00 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Mon, 31 Dec 2018 00:01:00 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
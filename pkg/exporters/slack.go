@@ -1,16 +1,15 @@
 package exporters
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 
 	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
 	"github.com/justinbarrick/fluxcloud/pkg/msg"
 )
 
@@ -21,6 +20,9 @@ type Slack struct {
 	Token     string
 	Channels  []SlackChannel
 	IconEmoji string
+	Sender    *Sender
+
+	notifications notifications
 }
 
 // Represents a slack message sent to the API
@@ -29,6 +31,17 @@ type SlackMessage struct {
 	IconEmoji   string            `json:"icon_emoji"`
 	Username    string            `json:"username"`
 	Attachments []SlackAttachment `json:"attachments"`
+
+	// The timestamp of the message to replace, when updating a message.
+	Ts string `json:"ts,omitempty"`
+}
+
+// The response of the Slack Web API.
+type slackResponse struct {
+	Ok      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Channel string `json:"channel"`
+	Ts      string `json:"ts"`
 }
 
 // Represents a section of a slack message that is sent to the API
@@ -45,6 +58,16 @@ type SlackChannel struct {
 	Namespace string `json:"namespace"`
 }
 
+func init() {
+	Register("slack", func(config config.Config) (Exporter, error) {
+		exporter, err := NewSlack(config)
+		if err != nil {
+			return nil, err
+		}
+		return exporter, nil
+	})
+}
+
 // Initialize a new Slack instance
 func NewSlack(config config.Config) (*Slack, error) {
 	var err error
@@ -60,52 +83,133 @@ func NewSlack(config config.Config) (*Slack, error) {
 		return nil, err
 	}
 	s.parseSlackChannelConfig(channels)
-	log.Println(s.Channels)
+	logging.Debug("Slack channels", "channels", fmt.Sprint(s.Channels))
 
-	s.Token = config.Optional("slack_token", "")
+	s.Token = config.Credential("slack_token", "")
+	logging.Secret(s.Url, s.Token)
 	s.Username = config.Optional("slack_username", "Flux Deployer")
 	s.IconEmoji = config.Optional("slack_icon_emoji", ":star-struck:")
 
+	s.Sender, err = NewSender("slack", "slack", config)
+	if err != nil {
+		return nil, err
+	}
+
 	return &s, nil
 }
 
 // Send a SlackMessage to Slack
 func (s *Slack) Send(c context.Context, client *http.Client, message msg.Message) error {
+	header := http.Header{}
+	if s.Token != "" {
+		header.Set("Authorization", fmt.Sprintf("Bearer %s", s.Token))
+	}
+
+	sender := senderOrDefault(s.Sender, "slack")
 	for _, slackMessage := range s.NewSlackMessage(message) {
-		fmt.Println(slackMessage)
-		b := new(bytes.Buffer)
-		err := json.NewEncoder(b).Encode(slackMessage)
+		var err error
+		if message.NotificationKey != "" && s.canUpdate() {
+			err = s.sendNotification(c, client, sender, header, message.NotificationKey, slackMessage)
+		} else {
+			err = sender.PostJSON(c, client, s.Url, header, slackMessage)
+		}
+
 		if err != nil {
-			log.Print("Could encode message to slack:", err)
 			return err
 		}
+	}
 
-		log.Print(string(b.Bytes()))
+	return nil
+}
 
-		req, _ := http.NewRequest("POST", s.Url, b)
-		req.Header.Set("Content-Type", "application/json")
+// Check that the Slack URL is valid and, when messages are sent with the Slack
+// Web API, that the token is accepted.
+func (s *Slack) Check(c context.Context, client *http.Client) error {
+	if err := checkUrl("slack", s.Url); err != nil {
+		return err
+	}
 
-		if s.Token != "" {
-			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.Token))
-		}
+	if !s.canUpdate() {
+		return nil
+	}
 
-		req = req.WithContext(c)
+	header := http.Header{}
+	header.Set("Authorization", fmt.Sprintf("Bearer %s", s.Token))
 
-		res, err := client.Do(req)
-		if err != nil {
-			log.Print("Could not post to slack:", err)
+	response := slackResponse{}
+	err := senderOrDefault(s.Sender, "slack").PostJSONResponse(c, client, s.apiUrl("auth.test"), header, struct{}{}, &response)
+	if err != nil {
+		return err
+	}
+
+	if !response.Ok {
+		return fmt.Errorf("Slack token was rejected: %s", response.Error)
+	}
+
+	return nil
+}
+
+// Post a message with the Slack Web API, updating the message that was sent
+// for the same notification to the channel if there is one.
+func (s *Slack) sendNotification(c context.Context, client *http.Client, sender *Sender, header http.Header, key string, slackMessage SlackMessage) error {
+	if ref, ok := s.notifications.get(key, slackMessage.Channel); ok {
+		update := slackMessage
+		update.Channel, update.Ts = splitSlackRef(ref)
+
+		response := slackResponse{}
+		if err := sender.PostJSONResponse(c, client, s.apiUrl("chat.update"), header, update, &response); err != nil {
 			return err
 		}
 
-		if res.StatusCode != 200 {
-			log.Print("Could not post to slack, status: ", res.Status)
-			return fmt.Errorf("Could not post to slack, status: %d", res.StatusCode)
+		if response.Ok {
+			return nil
 		}
+
+		logging.Warn("Could not update Slack message, sending a new one", "err", response.Error)
+		s.notifications.forget(key, slackMessage.Channel)
+	}
+
+	response := slackResponse{}
+	if err := sender.PostJSONResponse(c, client, s.Url, header, slackMessage, &response); err != nil {
+		return err
 	}
 
+	if !response.Ok {
+		return fmt.Errorf("Could not post to slack: %s", response.Error)
+	}
+
+	s.notifications.set(key, slackMessage.Channel, response.Channel+" "+response.Ts)
 	return nil
 }
 
+// Return true if messages are sent with the Slack Web API, which can update
+// messages, instead of an incoming webhook.
+func (s *Slack) canUpdate() bool {
+	parsed, err := url.Parse(s.Url)
+	if err != nil {
+		return false
+	}
+
+	return s.Token != "" && strings.HasSuffix(parsed.Path, "/chat.postMessage")
+}
+
+// Return the URL of another Slack Web API method.
+func (s *Slack) apiUrl(method string) string {
+	parsed, _ := url.Parse(s.Url)
+	parsed.Path = strings.TrimSuffix(parsed.Path, "chat.postMessage") + method
+	return parsed.String()
+}
+
+// Split a reference to a sent message into its channel ID and timestamp.
+func splitSlackRef(ref string) (string, string) {
+	parts := strings.SplitN(ref, " ", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+
+	return parts[0], parts[1]
+}
+
 // Return the new line character for Slack messages
 func (s *Slack) NewLine() string {
 	return "\n"
@@ -168,10 +272,22 @@ func (s *Slack) parseSlackChannelConfig(channels string) error {
 	return nil
 }
 
-// Match namespaces from service IDs to Slack channels.
+// Match namespaces from service IDs to Slack channels, unless the message was
+// routed to specific channels. Messages that are not about any resources, such
+// as watchdog alerts, go to the channels for all namespaces, or to every
+// channel if there are none.
 func (s *Slack) determineChannels(message msg.Message) []string {
+	if len(message.Destinations) > 0 {
+		return message.Destinations
+	}
+
+	serviceIDs := message.ServiceIDs()
+	if len(serviceIDs) == 0 {
+		return s.fallbackChannels()
+	}
+
 	var channels []string
-	for _, serviceID := range message.Event.ServiceIDs {
+	for _, serviceID := range serviceIDs {
 		ns, _, _ := serviceID.Components()
 
 		for _, ch := range s.Channels {
@@ -183,6 +299,25 @@ func (s *Slack) determineChannels(message msg.Message) []string {
 	return channels
 }
 
+// Return the channels for all namespaces, or every channel if there are none.
+func (s *Slack) fallbackChannels() []string {
+	var channels []string
+	for _, ch := range s.Channels {
+		if ch.Namespace == "*" {
+			channels = appendIfMissing(channels, ch.Channel)
+		}
+	}
+
+	if len(channels) > 0 {
+		return channels
+	}
+
+	for _, ch := range s.Channels {
+		channels = appendIfMissing(channels, ch.Channel)
+	}
+	return channels
+}
+
 func appendIfMissing(slice []string, s string) []string {
 	for _, v := range slice {
 		if v == s {
@@ -12,7 +12,9 @@ import (
 
 	"github.com/justinbarrick/fluxcloud/pkg/config"
 	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/watchdog"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	fluxevent "github.com/weaveworks/flux/event"
 )
 
@@ -126,6 +128,102 @@ func TestNewSlackMessage(t *testing.T) {
 	assert.Equal(t, message.Title, attach.Title)
 }
 
+func TestNewSlackMessageDestinations(t *testing.T) {
+	resourceID, _ := flux.ParseResourceID("namespace:resource/name")
+	message := msg.Message{
+		Title: "The title of the message",
+		Event: fluxevent.Event{
+			ServiceIDs: []flux.ResourceID{
+				resourceID,
+			},
+		},
+		Destinations: []string{"#routed"},
+	}
+
+	slackMessages := testSlack.NewSlackMessage(message)
+	assert.Len(t, slackMessages, 1)
+	assert.Equal(t, "#routed", slackMessages[0].Channel)
+}
+
+func TestSlackSendWatchdogAlert(t *testing.T) {
+	config := config.NewFakeConfig()
+	config.Set("slack_url", "https://myslack/")
+	config.Set("slack_channel", "#general")
+
+	slack, err := NewSlack(config)
+	assert.Nil(t, err)
+
+	message := msg.Message{
+		Title: "Flux has not synced or answered a heartbeat on cluster default",
+		Body:  "The daemon is not connected to fluxcloud.",
+		Type:  "stalled",
+		Event: watchdog.Alert{Cluster: watchdog.Cluster{Name: "default"}}.Event(),
+	}
+
+	sent := []SlackMessage{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slackMessage := SlackMessage{}
+		json.NewDecoder(r.Body).Decode(&slackMessage)
+		sent = append(sent, slackMessage)
+	}))
+	defer ts.Close()
+
+	slack.Url = ts.URL
+
+	assert.Nil(t, slack.Send(context.TODO(), &http.Client{}, message))
+	assert.Len(t, sent, 1)
+	assert.Equal(t, "#general", sent[0].Channel)
+	assert.Equal(t, message.Title, sent[0].Attachments[0].Title)
+}
+
+func TestNewSlackMessageWithoutResources(t *testing.T) {
+	message := msg.Message{
+		Title: "The title of the message",
+	}
+
+	slackMessages := testSlack.NewSlackMessage(message)
+	assert.Len(t, slackMessages, 1)
+	assert.Equal(t, "#channel", slackMessages[0].Channel)
+
+	slack := Slack{
+		Channels: []SlackChannel{
+			SlackChannel{"#a", "a"},
+			SlackChannel{"#b", "b"},
+		},
+	}
+
+	slackMessages = slack.NewSlackMessage(message)
+	assert.Len(t, slackMessages, 2)
+	assert.Equal(t, "#a", slackMessages[0].Channel)
+	assert.Equal(t, "#b", slackMessages[1].Channel)
+}
+
+func TestNewSlackMessageDigest(t *testing.T) {
+	a, _ := flux.ParseResourceID("a:deployment/a")
+	b, _ := flux.ParseResourceID("b:deployment/b")
+
+	first := fluxevent.Event{ServiceIDs: []flux.ResourceID{a}}
+	last := fluxevent.Event{ServiceIDs: []flux.ResourceID{b}}
+	message := msg.Message{
+		Title:  "The title of the message",
+		Type:   "digest",
+		Event:  last,
+		Events: []fluxevent.Event{first, last},
+	}
+
+	slack := Slack{
+		Channels: []SlackChannel{
+			SlackChannel{"#a", "a"},
+			SlackChannel{"#b", "b"},
+		},
+	}
+
+	slackMessages := slack.NewSlackMessage(message)
+	require.Len(t, slackMessages, 2)
+	assert.Equal(t, "#b", slackMessages[0].Channel)
+	assert.Equal(t, "#a", slackMessages[1].Channel)
+}
+
 func TestSlackSend(t *testing.T) {
 	resourceID, _ := flux.ParseResourceID("namespace:resource/name")
 	message := msg.Message{
@@ -234,3 +332,109 @@ func TestSlackSendAuthToken(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, fmt.Sprintf("Bearer %s", testSlack.Token), authHeader)
 }
+
+func TestSlackSendNotificationUpdates(t *testing.T) {
+	resourceID, _ := flux.ParseResourceID("namespace:resource/name")
+	message := msg.Message{
+		Title:           "Deployment of d644e1a: committed",
+		NotificationKey: "deployment-d644e1a",
+		Event: fluxevent.Event{
+			ServiceIDs: []flux.ResourceID{
+				resourceID,
+			},
+		},
+	}
+
+	paths := []string{}
+	received := []SlackMessage{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slackMessage := SlackMessage{}
+		json.NewDecoder(r.Body).Decode(&slackMessage)
+		paths = append(paths, r.URL.Path)
+		received = append(received, slackMessage)
+		fmt.Fprintln(w, `{"ok": true, "channel": "C1234", "ts": "1555000000.000100"}`)
+	}))
+	defer ts.Close()
+
+	slack := &Slack{
+		Url:      ts.URL + "/api/chat.postMessage",
+		Token:    "xoxb-token",
+		Channels: []SlackChannel{{"#general", "*"}},
+	}
+
+	assert.Nil(t, slack.Send(context.TODO(), &http.Client{}, message))
+
+	message.Title = "Deployment of d644e1a: synced"
+	assert.Nil(t, slack.Send(context.TODO(), &http.Client{}, message))
+
+	assert.Equal(t, []string{"/api/chat.postMessage", "/api/chat.update"}, paths)
+	assert.Equal(t, "#general", received[0].Channel)
+	assert.Equal(t, "C1234", received[1].Channel)
+	assert.Equal(t, "1555000000.000100", received[1].Ts)
+	assert.Equal(t, "Deployment of d644e1a: synced", received[1].Attachments[0].Title)
+}
+
+func TestSlackSendNotificationWebhook(t *testing.T) {
+	resourceID, _ := flux.ParseResourceID("namespace:resource/name")
+	message := msg.Message{
+		Title:           "Deployment of d644e1a: committed",
+		NotificationKey: "deployment-d644e1a",
+		Event: fluxevent.Event{
+			ServiceIDs: []flux.ResourceID{
+				resourceID,
+			},
+		},
+	}
+
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintln(w, "ok")
+	}))
+	defer ts.Close()
+
+	// incoming webhooks cannot update messages, so each state is a new message
+	slack := &Slack{
+		Url:      ts.URL,
+		Channels: []SlackChannel{{"#general", "*"}},
+	}
+
+	assert.Nil(t, slack.Send(context.TODO(), &http.Client{}, message))
+	assert.Nil(t, slack.Send(context.TODO(), &http.Client{}, message))
+	assert.Equal(t, 2, requests)
+}
+
+func TestSlackCheck(t *testing.T) {
+	ok := true
+	paths := []string{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		assert.Equal(t, "Bearer xoxb-token", r.Header.Get("Authorization"))
+		if ok {
+			fmt.Fprintln(w, `{"ok": true}`)
+		} else {
+			fmt.Fprintln(w, `{"ok": false, "error": "invalid_auth"}`)
+		}
+	}))
+	defer ts.Close()
+
+	slack := &Slack{
+		Url:   ts.URL + "/api/chat.postMessage",
+		Token: "xoxb-token",
+	}
+
+	assert.Nil(t, slack.Check(context.TODO(), &http.Client{}))
+	assert.Equal(t, []string{"/api/auth.test"}, paths)
+
+	ok = false
+	err := slack.Check(context.TODO(), &http.Client{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid_auth")
+
+	// incoming webhooks can only be checked by sending a message
+	slack.Url = ts.URL + "/services/T000/B000/XXXX"
+	assert.Nil(t, slack.Check(context.TODO(), &http.Client{}))
+	assert.Len(t, paths, 2)
+}
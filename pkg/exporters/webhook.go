@@ -3,19 +3,89 @@ package exporters
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
 	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/templates"
+)
+
+// The headers that signed webhooks are sent with, see WebhookSignature.
+const (
+	WebhookTimestampHeader = "X-Fluxcloud-Timestamp"
+	WebhookSignatureHeader = "X-Fluxcloud-Signature"
 )
 
 // The Webhook exporter sends Flux events to a Webhook channel via a webhook.
 type Webhook struct {
-	Url string
+	Url    string
+	Sender *Sender
+
+	// The URL parsed as a template, if it is nil Url is sent to as it is.
+	UrlTemplate *template.Template
+
+	// The HTTP method, POST if it is empty.
+	Method string
+
+	// Headers sent with every request as they are, such as the authorization
+	// header built from the credentials.
+	Header http.Header
+
+	// Headers sent with every request that are rendered from templates, the
+	// headers configured with webhook_headers.
+	HeaderTemplates map[string][]*template.Template
+
+	// If set, requests are signed with it, see WebhookSignature.
+	Secret string
+
+	// The version of the payload to send, see WebhookPayloadVersionV1.
+	PayloadVersion string
+
+	// If set, the body is rendered from this template instead of being
+	// encoded in the payload version.
+	BodyTemplate *template.Template
+
+	// A template for the Content-Type header, application/json if it is nil.
+	ContentType *template.Template
+
+	// The link to the repository that templates are rendered with.
+	VCSLink string
+}
+
+// The values that webhook templates are rendered with: the values available
+// to the formatter's templates and the formatted message.
+type WebhookTemplateValues struct {
+	*templates.Values
+	Type            string
+	Title           string
+	TitleLink       string
+	Body            string
+	Cluster         string
+	NotificationKey string
+
+	// The message as a version 1 payload, see WebhookPayloadV1.
+	Payload WebhookPayloadV1
+}
+
+func init() {
+	Register("webhook", func(config config.Config) (Exporter, error) {
+		exporter, err := NewWebhook(config)
+		if err != nil {
+			return nil, err
+		}
+		return exporter, nil
+	})
 }
 
 // Initialize a new Webhook instance
@@ -27,39 +97,272 @@ func NewWebhook(config config.Config) (*Webhook, error) {
 	if err != nil {
 		return nil, err
 	}
+	logging.Secret(s.Url)
+
+	s.Method = strings.ToUpper(config.Optional("webhook_method", "POST"))
+	switch s.Method {
+	case "POST", "PUT", "PATCH":
+	default:
+		return nil, fmt.Errorf("Invalid webhook_method %s: must be POST, PUT or PATCH", s.Method)
+	}
+
+	s.VCSLink = config.Optional("github_url", "")
+
+	s.UrlTemplate, err = parseWebhookTemplate("webhook_url", s.Url)
+	if err != nil {
+		return nil, err
+	}
+
+	s.ContentType, err = parseWebhookTemplate("webhook_content_type", config.Optional("webhook_content_type", "application/json"))
+	if err != nil {
+		return nil, err
+	}
+
+	if body := config.Optional("webhook_body_template", ""); body != "" {
+		s.BodyTemplate, err = parseWebhookTemplate("webhook_body_template", body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.HeaderTemplates, err = parseHeaders(config.Credential("webhook_headers", ""))
+	if err != nil {
+		return nil, err
+	}
+
+	username := config.Credential("webhook_username", "")
+	password := config.Credential("webhook_password", "")
+	token := config.Credential("webhook_bearer_token", "")
+	logging.Secret(password, token)
+
+	if token != "" && (username != "" || password != "") {
+		return nil, fmt.Errorf("Only one of webhook_bearer_token and webhook_username can be set")
+	}
+
+	s.Header = http.Header{}
+	if username != "" || password != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		s.Header.Set("Authorization", "Basic "+credentials)
+	} else if token != "" {
+		s.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	s.Secret = config.Credential("webhook_secret", "")
+	logging.Secret(s.Secret)
+
+	s.PayloadVersion = config.Optional("webhook_payload_version", WebhookPayloadVersionLegacy)
+	switch s.PayloadVersion {
+	case WebhookPayloadVersionLegacy, WebhookPayloadVersionV1:
+	default:
+		return nil, fmt.Errorf("Invalid webhook_payload_version %s: must be %s or %s", s.PayloadVersion, WebhookPayloadVersionLegacy, WebhookPayloadVersionV1)
+	}
+
+	s.Sender, err = NewSender("Webhook", "webhook", config)
+	if err != nil {
+		return nil, err
+	}
 
 	return &s, nil
 }
 
 // Send a WebhookMessage to Webhook
 func (s *Webhook) Send(c context.Context, client *http.Client, message msg.Message) error {
-	b := new(bytes.Buffer)
-	err := json.NewEncoder(b).Encode(message)
+	values := s.templateValues(message)
+
+	body, err := s.payload(message, values)
 	if err != nil {
-		log.Print("Could encode message to Webhook:", err)
 		return err
 	}
 
-	log.Print(string(b.Bytes()))
+	header := http.Header{}
+	for key, headerTemplates := range s.HeaderTemplates {
+		for _, tpl := range headerTemplates {
+			rendered, err := executeWebhookTemplate(key+" header", tpl, values)
+			if err != nil {
+				return err
+			}
+			header.Add(key, rendered)
+		}
+	}
 
-	req, _ := http.NewRequest("POST", s.Url, b)
-	req.Header.Set("Content-Type", "application/json")
-	req = req.WithContext(c)
+	// set after the templates so that credentials are never rendered
+	for key, headerValues := range s.Header {
+		header[key] = append([]string{}, headerValues...)
+	}
 
-	res, err := client.Do(req)
-	if err != nil {
-		log.Print("Could not post to Webhook:", err)
-		return err
+	contentType := "application/json"
+	if s.ContentType != nil {
+		contentType, err = executeWebhookTemplate("content type", s.ContentType, values)
+		if err != nil {
+			return err
+		}
+	}
+	header.Set("Content-Type", contentType)
+
+	urls := message.Destinations
+	if len(urls) == 0 {
+		url := s.Url
+		if s.UrlTemplate != nil {
+			url, err = executeWebhookTemplate("URL", s.UrlTemplate, values)
+			if err != nil {
+				return err
+			}
+		}
+
+		if url == "" {
+			return fmt.Errorf("Webhook URL template rendered an empty URL")
+		}
+
+		urls = []string{url}
 	}
 
-	if res.StatusCode != 200 {
-		log.Print("Could not post to Webhook, status: ", res.Status)
-		return errors.New(fmt.Sprintf("Could not post to Webhook, status: %d", res.StatusCode))
+	sender := senderOrDefault(s.Sender, "Webhook")
+	for _, url := range urls {
+
+		err = sender.Do(c, client, Request{
+			Method:  s.Method,
+			Url:     url,
+			Header:  header,
+			Body:    body,
+			Prepare: s.sign,
+		}, nil)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// Return the values that templates are rendered with for a message.
+func (s *Webhook) templateValues(message msg.Message) *WebhookTemplateValues {
+	return &WebhookTemplateValues{
+		Values:          templates.NewValues(s.VCSLink, message.Event, s.FormatLink),
+		Type:            message.Type,
+		Title:           message.Title,
+		TitleLink:       message.TitleLink,
+		Body:            message.Body,
+		Cluster:         message.Cluster,
+		NotificationKey: message.NotificationKey,
+		Payload:         NewWebhookPayloadV1(message),
+	}
+}
+
+// Parse the template in one of the webhook's settings.
+func parseWebhookTemplate(setting string, tpl string) (*template.Template, error) {
+	parsed, err := templates.Parse(tpl)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s: %s", setting, err)
+	}
+
+	return parsed, nil
+}
+
+// Render one of the webhook's parsed templates.
+func executeWebhookTemplate(name string, tpl *template.Template, values *WebhookTemplateValues) (string, error) {
+	rendered := &bytes.Buffer{}
+	if err := tpl.Execute(rendered, values); err != nil {
+		return "", fmt.Errorf("Could not render webhook %s template: %s", name, err)
+	}
+
+	return rendered.String(), nil
+}
+
+// Encode a message with the body template if one is set, or in the configured
+// payload version.
+func (s *Webhook) payload(message msg.Message, values *WebhookTemplateValues) ([]byte, error) {
+	if s.BodyTemplate != nil {
+		body, err := executeWebhookTemplate("body", s.BodyTemplate, values)
+		return []byte(body), err
+	}
+
+	if s.PayloadVersion == WebhookPayloadVersionV1 {
+		return json.Marshal(NewWebhookPayloadV1(message))
+	}
+
+	// the destinations are webhook URLs, don't leak them to each other
+	payload := message
+	payload.Destinations = nil
+	return json.Marshal(payload)
+}
+
+// Sign a request with the secret, if it is set.
+func (s *Webhook) sign(req *http.Request, body []byte) {
+	if s.Secret == "" {
+		return
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, WebhookSignature(s.Secret, timestamp, body))
+}
+
+// Return the signature of a webhook request sent at timestamp, in Unix
+// seconds: "sha256=" and the hex encoded HMAC-SHA256 of the timestamp, a
+// period and the body, keyed with the secret. Receivers should compute it
+// from the X-Fluxcloud-Timestamp header and the raw body, compare it with
+// X-Fluxcloud-Signature in constant time, and reject old timestamps.
+func WebhookSignature(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Parse a comma separated list of Name=value headers, whose values are
+// templates.
+func parseHeaders(value string) (map[string][]*template.Template, error) {
+	headers := map[string][]*template.Template{}
+
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("Invalid webhook_headers: expected Name=value pairs")
+		}
+
+		tpl, err := templates.Parse(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid webhook_headers: %s header: %s", name, err)
+		}
+
+		name = http.CanonicalHeaderKey(name)
+		headers[name] = append(headers[name], tpl)
+		if isSensitiveHeader(name) {
+			logging.Secret(strings.TrimSpace(parts[1]))
+		}
+	}
+
+	return headers, nil
+}
+
+// Return true if a header's value is likely a credential.
+func isSensitiveHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, sensitive := range []string{"auth", "token", "key", "secret", "signature"} {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check that the webhook URL is valid, URLs that are templates are only
+// checked when they are rendered.
+func (s *Webhook) Check(c context.Context, client *http.Client) error {
+	if strings.Contains(s.Url, "{{") {
+		return nil
+	}
+
+	return checkUrl("Webhook", s.Url)
+}
+
 // Return the new line character for Webhook messages
 func (s *Webhook) NewLine() string {
 	return "\n"
@@ -2,14 +2,23 @@ package exporters
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/justinbarrick/fluxcloud/pkg/config"
 	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/templates"
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestWebhookDefault(t *testing.T) {
@@ -63,6 +72,30 @@ func TestWebhookSend(t *testing.T) {
 	assert.Equal(t, receivedMessage, message)
 }
 
+func TestWebhookSendDestinations(t *testing.T) {
+	received := []msg.Message{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedMessage := msg.Message{}
+		json.NewDecoder(r.Body).Decode(&receivedMessage)
+		received = append(received, receivedMessage)
+		fmt.Fprintln(w, "Hello, client")
+	}))
+	defer ts.Close()
+
+	webhook := Webhook{Url: "http://unused.invalid/"}
+	message := msg.Message{
+		Title:        "The title of the message",
+		Destinations: []string{ts.URL + "/one", ts.URL + "/two"},
+	}
+
+	err := webhook.Send(context.TODO(), &http.Client{}, message)
+	assert.Nil(t, err)
+	assert.Len(t, received, 2)
+	assert.Nil(t, received[0].Destinations)
+	assert.Equal(t, "The title of the message", received[0].Title)
+}
+
 func TestWebhookSendNon200(t *testing.T) {
 	webhook := Webhook{}
 	message := msg.Message{}
@@ -102,3 +135,221 @@ func TestWebhookName(t *testing.T) {
 func TestWebhookImplementsExporter(t *testing.T) {
 	_ = Exporter(&Webhook{})
 }
+
+func TestWebhookCheck(t *testing.T) {
+	webhook := Webhook{Url: "https://mywebhook/"}
+	assert.Nil(t, webhook.Check(context.TODO(), &http.Client{}))
+
+	webhook.Url = "mywebhook"
+	assert.NotNil(t, webhook.Check(context.TODO(), &http.Client{}))
+
+	webhook.Url = "http://%zz"
+	assert.NotNil(t, webhook.Check(context.TODO(), &http.Client{}))
+}
+
+func TestWebhookOptions(t *testing.T) {
+	config := config.NewFakeConfig()
+	config.Set("webhook_url", "https://mywebhook/")
+	config.Set("webhook_method", "put")
+	config.Set("webhook_headers", "X-Team=platform, X-Api-Key=abc123")
+	config.Set("webhook_username", "fluxcloud")
+	config.Set("webhook_password", "hunter2")
+	config.Set("webhook_secret", "mysecret")
+
+	webhook, err := NewWebhook(config)
+	assert.Nil(t, err)
+	assert.Equal(t, "PUT", webhook.Method)
+	assert.Len(t, webhook.HeaderTemplates["X-Team"], 1)
+	assert.Len(t, webhook.HeaderTemplates["X-Api-Key"], 1)
+	assert.Equal(t, http.Header{"Authorization": []string{"Basic Zmx1eGNsb3VkOmh1bnRlcjI="}}, webhook.Header)
+	assert.Equal(t, "mysecret", webhook.Secret)
+	assert.Equal(t, WebhookPayloadVersionLegacy, webhook.PayloadVersion)
+}
+
+func TestWebhookOptionsInvalid(t *testing.T) {
+	for _, settings := range []map[string]string{
+		{"webhook_method": "GET"},
+		{"webhook_headers": "X-Team"},
+		{"webhook_bearer_token": "mytoken", "webhook_username": "fluxcloud"},
+		{"webhook_payload_version": "v2"},
+		{"webhook_body_template": "{{ .Title"},
+		{"webhook_content_type": "{{ end }}"},
+		{"webhook_headers": "X-Event={{ .EventType"},
+		{"webhook_url": "https://mywebhook/{{ .Cluster"},
+	} {
+		config := config.NewFakeConfig()
+		config.Set("webhook_url", "https://mywebhook/")
+		for key, value := range settings {
+			config.Set(key, value)
+		}
+
+		_, err := NewWebhook(config)
+		assert.NotNil(t, err, settings)
+	}
+}
+
+func TestWebhookSendSigned(t *testing.T) {
+	var method, timestamp, signature, authorization, team string
+	var body []byte
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		timestamp = r.Header.Get(WebhookTimestampHeader)
+		signature = r.Header.Get(WebhookSignatureHeader)
+		authorization = r.Header.Get("Authorization")
+		team = r.Header.Get("X-Team")
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	headers, err := parseHeaders("X-Team=platform")
+	assert.Nil(t, err)
+
+	webhook := Webhook{
+		Url:    ts.URL,
+		Method: "PATCH",
+		Header: http.Header{
+			"Authorization": []string{"Bearer mytoken"},
+		},
+		HeaderTemplates: headers,
+		Secret:          "mysecret",
+	}
+
+	err = webhook.Send(context.TODO(), &http.Client{}, msg.Message{Title: "The title of the message"})
+	assert.Nil(t, err)
+
+	assert.Equal(t, "PATCH", method)
+	assert.Equal(t, "Bearer mytoken", authorization)
+	assert.Equal(t, "platform", team)
+
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	assert.Nil(t, err)
+	assert.InDelta(t, time.Now().Unix(), sent, 5)
+
+	mac := hmac.New(sha256.New, []byte("mysecret"))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), signature)
+	assert.Equal(t, signature, WebhookSignature("mysecret", timestamp, body))
+}
+
+func TestWebhookSendCredentialsNotRendered(t *testing.T) {
+	var authorization, apiKey string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		apiKey = r.Header.Get("X-Api-Key")
+	}))
+	defer ts.Close()
+
+	config := config.NewFakeConfig()
+	config.Set("webhook_url", ts.URL)
+	config.Set("webhook_bearer_token", "abc{{def")
+	config.Set("webhook_headers", "X-Api-Key={{ .Cluster }}-key,Authorization={{ .Cluster }}")
+
+	webhook, err := NewWebhook(config)
+	assert.Nil(t, err)
+
+	err = webhook.Send(context.TODO(), &http.Client{}, msg.Message{Title: "title", Cluster: "production"})
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer abc{{def", authorization)
+	assert.Equal(t, "production-key", apiKey)
+}
+
+func TestWebhookSendUnsigned(t *testing.T) {
+	var method, signature string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		signature = r.Header.Get(WebhookSignatureHeader)
+	}))
+	defer ts.Close()
+
+	webhook := Webhook{Url: ts.URL}
+	assert.Nil(t, webhook.Send(context.TODO(), &http.Client{}, msg.Message{}))
+	assert.Equal(t, "POST", method)
+	assert.Equal(t, "", signature)
+}
+
+func TestWebhookSendPayloadV1(t *testing.T) {
+	received := map[string]interface{}{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer ts.Close()
+
+	webhook := Webhook{Url: ts.URL, PayloadVersion: WebhookPayloadVersionV1}
+
+	err := webhook.Send(context.TODO(), &http.Client{}, msg.Message{
+		Title:        "The title of the message",
+		Cluster:      "production",
+		Destinations: []string{ts.URL},
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, "v1", received["version"])
+	assert.Equal(t, "The title of the message", received["title"])
+	assert.Equal(t, "production", received["cluster"])
+	assert.NotContains(t, received, "Destinations")
+	assert.NotContains(t, received, "Event")
+}
+
+func TestWebhookSendTemplates(t *testing.T) {
+	var path, contentType, eventHeader, timestamp, signature string
+	var body []byte
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		contentType = r.Header.Get("Content-Type")
+		eventHeader = r.Header.Get("X-Event")
+		timestamp = r.Header.Get(WebhookTimestampHeader)
+		signature = r.Header.Get(WebhookSignatureHeader)
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	config := config.NewFakeConfig()
+	config.Set("github_url", "https://github.com/org/repo")
+	config.Set("webhook_url", ts.URL+"/deploys/{{ .Cluster }}")
+	config.Set("webhook_headers", "X-Event={{ .EventType }}")
+	config.Set("webhook_content_type", "application/vnd.deploy+json")
+	config.Set("webhook_secret", "mysecret")
+	config.Set("webhook_body_template", `{"cluster": {{ json .Cluster }}, "title": {{ json .Title }}, "link": "{{ .VCSLink }}/commit/{{ (index .Commits 0).Revision }}", "images": {{ json .Payload.Images }}}`)
+
+	webhook, err := NewWebhook(config)
+	assert.Nil(t, err)
+
+	event := test_utils.NewFluxSyncEvent()
+	err = webhook.Send(context.TODO(), &http.Client{}, msg.Message{
+		Title:   "Applied \"changes\"",
+		Cluster: "production",
+		Event:   event,
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, "/deploys/production", path)
+	assert.Equal(t, "application/vnd.deploy+json", contentType)
+	assert.Equal(t, "sync", eventHeader)
+
+	received := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(body, &received))
+	assert.Equal(t, "production", received["cluster"])
+	assert.Equal(t, `Applied "changes"`, received["title"])
+	assert.Equal(t, "https://github.com/org/repo/commit/"+utils.GetCommits(event.Metadata)[0].Revision, received["link"])
+	assert.Equal(t, []interface{}{}, received["images"])
+
+	assert.Equal(t, WebhookSignature("mysecret", timestamp, body), signature)
+}
+
+func TestWebhookSendTemplateError(t *testing.T) {
+	body, err := templates.Parse("{{ .Missing }}")
+	assert.Nil(t, err)
+
+	webhook := Webhook{
+		Url:          "https://mywebhook/",
+		BodyTemplate: body,
+	}
+
+	err = webhook.Send(context.TODO(), &http.Client{}, msg.Message{Title: "title"})
+	assert.NotNil(t, err)
+}
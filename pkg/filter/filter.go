@@ -0,0 +1,189 @@
+package filter
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
+	"github.com/weaveworks/flux"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+// Decides whether an event should be sent at all, before it is formatted.
+//
+// Each include list that is set must match the event and no exclude list may
+// match it. Namespaces and resources match the event's ServiceIDs and the
+// resources that failed to sync: an include list matches if any resource
+// matches, an exclude list only drops the event if every resource matches.
+// Namespace and resource patterns are globs such as "team-*" or
+// "default:deployment/*".
+type Filter struct {
+	IncludeEventTypes []string
+	ExcludeEventTypes []string
+	IncludeNamespaces []string
+	ExcludeNamespaces []string
+	IncludeResources  []string
+	ExcludeResources  []string
+	IncludeLogLevels  []string
+	ExcludeLogLevels  []string
+
+	// Only allow events that have sync errors.
+	OnlyErrors bool
+}
+
+// Load a filter from configuration, reading each setting with a prefix such as
+// "slack_" (SLACK_EXCLUDE_EVENT_TYPES) or no prefix for the global filter.
+// Returns nil if no filters are configured.
+func NewFilter(c config.Config, prefix string) (*Filter, error) {
+	var err error
+
+	f := &Filter{
+		IncludeEventTypes: list(c, prefix+"include_event_types"),
+		ExcludeEventTypes: list(c, prefix+"exclude_event_types"),
+		IncludeNamespaces: list(c, prefix+"include_namespaces"),
+		ExcludeNamespaces: list(c, prefix+"exclude_namespaces"),
+		IncludeResources:  list(c, prefix+"include_resources"),
+		ExcludeResources:  list(c, prefix+"exclude_resources"),
+		IncludeLogLevels:  list(c, prefix+"include_log_levels"),
+		ExcludeLogLevels:  list(c, prefix+"exclude_log_levels"),
+	}
+
+	f.OnlyErrors, err = strconv.ParseBool(c.Optional(prefix+"only_errors", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid setting %s: %s", strings.ToUpper(prefix+"only_errors"), err)
+	}
+
+	for _, patterns := range [][]string{f.IncludeNamespaces, f.ExcludeNamespaces, f.IncludeResources, f.ExcludeResources} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("Invalid filter pattern %s: %s", pattern, err)
+			}
+		}
+	}
+
+	if f.empty() {
+		return nil, nil
+	}
+
+	return f, nil
+}
+
+// Return true if the event should be sent. A nil filter allows every event.
+func (f *Filter) Allows(event fluxevent.Event) bool {
+	if f == nil {
+		return true
+	}
+
+	if f.OnlyErrors && len(utils.GetErrors(event.Metadata)) == 0 {
+		return false
+	}
+
+	if !allowsValue(f.IncludeEventTypes, f.ExcludeEventTypes, event.Type) {
+		return false
+	}
+
+	return f.AllowsAlert(event)
+}
+
+// Return true if an alert described by event should be sent. Alerts are only
+// filtered by their log level, namespaces and resources: the event types and
+// OnlyErrors select events, and an alert that errors were resolved has none.
+// A nil filter allows every alert.
+func (f *Filter) AllowsAlert(event fluxevent.Event) bool {
+	if f == nil {
+		return true
+	}
+
+	if !allowsValue(f.IncludeLogLevels, f.ExcludeLogLevels, event.LogLevel) {
+		return false
+	}
+
+	namespace := func(id flux.ResourceID) string {
+		ns, _, _ := id.Components()
+		return ns
+	}
+
+	resource := func(id flux.ResourceID) string {
+		return id.String()
+	}
+
+	return allowsResources(f.IncludeNamespaces, f.ExcludeNamespaces, event, namespace) &&
+		allowsResources(f.IncludeResources, f.ExcludeResources, event, resource)
+}
+
+func allowsValue(include []string, exclude []string, value string) bool {
+	if len(include) > 0 && !contains(include, value) {
+		return false
+	}
+
+	return !contains(exclude, value)
+}
+
+func (f *Filter) empty() bool {
+	return !f.OnlyErrors && len(f.IncludeEventTypes) == 0 && len(f.ExcludeEventTypes) == 0 &&
+		len(f.IncludeNamespaces) == 0 && len(f.ExcludeNamespaces) == 0 &&
+		len(f.IncludeResources) == 0 && len(f.ExcludeResources) == 0 &&
+		len(f.IncludeLogLevels) == 0 && len(f.ExcludeLogLevels) == 0
+}
+
+func allowsResources(include []string, exclude []string, event fluxevent.Event, component func(flux.ResourceID) string) bool {
+	ids := utils.GetResourceIDs(event)
+
+	if len(include) > 0 {
+		included := false
+		for _, id := range ids {
+			if matchesAny(include, component(id)) {
+				included = true
+				break
+			}
+		}
+
+		if !included {
+			return false
+		}
+	}
+
+	if len(exclude) == 0 || len(ids) == 0 {
+		return true
+	}
+
+	for _, id := range ids {
+		if !matchesAny(exclude, component(id)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Read a comma separated list setting.
+func list(c config.Config, key string) []string {
+	values := []string{}
+	for _, value := range strings.Split(c.Optional(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,108 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewFilterEmpty(t *testing.T) {
+	f, err := NewFilter(config.NewFakeConfig(), "")
+	assert.Nil(t, err)
+	assert.Nil(t, f)
+	assert.True(t, f.Allows(test_utils.NewFluxSyncEvent()))
+}
+
+func TestNewFilterPrefix(t *testing.T) {
+	c := config.NewFakeConfig()
+	c.Set("exclude_event_types", "commit")
+	c.Set("slack_include_event_types", "sync, autorelease")
+
+	f, err := NewFilter(c, "slack_")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"sync", "autorelease"}, f.IncludeEventTypes)
+	assert.Equal(t, []string{}, f.ExcludeEventTypes)
+}
+
+func TestNewFilterInvalid(t *testing.T) {
+	c := config.NewFakeConfig()
+	c.Set("only_errors", "sometimes")
+	_, err := NewFilter(c, "")
+	assert.NotNil(t, err)
+
+	c = config.NewFakeConfig()
+	c.Set("include_namespaces", "[-")
+	_, err = NewFilter(c, "")
+	assert.NotNil(t, err)
+}
+
+func TestFilterEventTypes(t *testing.T) {
+	f := &Filter{ExcludeEventTypes: []string{"commit", "autorelease"}}
+	assert.True(t, f.Allows(test_utils.NewFluxSyncEvent()))
+	assert.False(t, f.Allows(test_utils.NewFluxCommitEvent()))
+	assert.False(t, f.Allows(test_utils.NewFluxAutoReleaseEvent()))
+
+	f = &Filter{IncludeEventTypes: []string{"commit"}}
+	assert.False(t, f.Allows(test_utils.NewFluxSyncEvent()))
+	assert.True(t, f.Allows(test_utils.NewFluxCommitEvent()))
+}
+
+func TestFilterLogLevels(t *testing.T) {
+	f := &Filter{IncludeLogLevels: []string{"error"}}
+	assert.False(t, f.Allows(test_utils.NewFluxSyncEvent()))
+
+	f = &Filter{ExcludeLogLevels: []string{"debug"}}
+	assert.True(t, f.Allows(test_utils.NewFluxSyncEvent()))
+}
+
+func TestFilterOnlyErrors(t *testing.T) {
+	f := &Filter{OnlyErrors: true}
+	assert.False(t, f.Allows(test_utils.NewFluxSyncEvent()))
+	assert.True(t, f.Allows(test_utils.NewFluxSyncErrorEvent()))
+}
+
+func TestFilterNamespaces(t *testing.T) {
+	f := &Filter{IncludeNamespaces: []string{"def*"}}
+	assert.True(t, f.Allows(test_utils.NewFluxSyncEvent()))
+
+	f = &Filter{IncludeNamespaces: []string{"team-*"}}
+	assert.False(t, f.Allows(test_utils.NewFluxSyncEvent()))
+
+	f = &Filter{ExcludeNamespaces: []string{"default"}}
+	assert.False(t, f.Allows(test_utils.NewFluxSyncEvent()))
+
+	f = &Filter{ExcludeNamespaces: []string{"kube-system"}}
+	assert.True(t, f.Allows(test_utils.NewFluxSyncEvent()))
+}
+
+func TestFilterResources(t *testing.T) {
+	f := &Filter{IncludeResources: []string{"default:deployment/*"}}
+	assert.True(t, f.Allows(test_utils.NewFluxSyncEvent()))
+	assert.False(t, f.Allows(test_utils.NewFluxSyncErrorEvent()))
+
+	// only dropped if every resource is excluded
+	f = &Filter{ExcludeResources: []string{"default:persistentvolumeclaim/test"}}
+	assert.True(t, f.Allows(test_utils.NewFluxSyncErrorEvent()))
+
+	f = &Filter{ExcludeResources: []string{"default:persistentvolumeclaim/*"}}
+	assert.False(t, f.Allows(test_utils.NewFluxSyncErrorEvent()))
+}
+
+func TestFilterAllowsAlert(t *testing.T) {
+	// alerts are not events, event types and ONLY_ERRORS do not apply to them
+	f := &Filter{ExcludeEventTypes: []string{"sync"}, OnlyErrors: true}
+	assert.False(t, f.Allows(test_utils.NewFluxSyncEvent()))
+	assert.True(t, f.AllowsAlert(test_utils.NewFluxSyncEvent()))
+
+	f = &Filter{ExcludeNamespaces: []string{"default"}}
+	assert.False(t, f.AllowsAlert(test_utils.NewFluxSyncErrorEvent()))
+
+	f = &Filter{IncludeLogLevels: []string{"error"}}
+	assert.False(t, f.AllowsAlert(test_utils.NewFluxSyncEvent()))
+
+	f = nil
+	assert.True(t, f.AllowsAlert(test_utils.NewFluxSyncEvent()))
+}
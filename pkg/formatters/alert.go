@@ -0,0 +1,57 @@
+package formatters
+
+import (
+	"github.com/justinbarrick/fluxcloud/pkg/alerts"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+)
+
+const (
+	alertTitleTemplate = `{{ if .Resolved }}Resolved: {{ len .Failures }} resource(s) synced successfully{{ else }}Flux failed to sync {{ len .Failures }} resource(s){{ end }}`
+	alertBodyTemplate  = `
+{{ range .Failures }}
+{{ if $.Resolved }}* {{ .ID }}, file: {{ .Path }}, failing since {{ .FirstSeen.Format "2006-01-02 15:04:05 MST" }}
+{{ else }}Resource {{ .ID }}, file: {{ .Path }}:
+
+> {{ .Error }}
+{{ end }}{{ end }}
+`
+)
+
+type alertValues struct {
+	alerts.Alert
+	VCSLink    string
+	FormatLink func(string, string) string
+}
+
+// Format an alert about resources that started failing to sync or were
+// resolved.
+func (d DefaultFormatter) FormatAlert(alert alerts.Alert, exporter exporters.Exporter) msg.Message {
+	values := &alertValues{
+		Alert:   alert,
+		VCSLink: d.vcsLink,
+		FormatLink: func(link, text string) string {
+			return exporter.FormatLink(link, text)
+		},
+	}
+
+	nl := exporter.NewLine()
+
+	message := msg.Message{
+		TitleLink: d.vcsLink,
+		Title:     execTemplate(d.alertTitleTemplate, values, nl),
+		Body:      execTemplate(d.alertBodyTemplate, values, nl),
+		Type:      "alert",
+		Event:     alert.Event(),
+	}
+
+	if alert.Resolved {
+		message.Type = "resolved"
+	}
+
+	if message.Title == "" || message.Body == "" {
+		return msg.Message{}
+	}
+
+	return message
+}
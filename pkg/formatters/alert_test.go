@@ -0,0 +1,70 @@
+package formatters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/alerts"
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/stretchr/testify/assert"
+	"github.com/weaveworks/flux"
+)
+
+var testAlert = alerts.Alert{
+	Failures: []alerts.Failure{
+		{
+			ID:        flux.MustParseResourceID("default:persistentvolumeclaim/test"),
+			Path:      "manifests/test.yaml",
+			Error:     "field is immutable",
+			Failures:  3,
+			FirstSeen: time.Date(2019, 4, 8, 9, 0, 0, 0, time.UTC),
+		},
+	},
+}
+
+func TestDefaultFormatterFormatAlert(t *testing.T) {
+	d := DefaultFormatter{
+		vcsLink:            "https://github.com",
+		alertBodyTemplate:  alertBodyTemplate,
+		alertTitleTemplate: alertTitleTemplate,
+	}
+
+	msg := d.FormatAlert(testAlert, &exporters.FakeExporter{})
+	assert.Equal(t, "Flux failed to sync 1 resource(s)", msg.Title)
+	assert.Equal(t, "alert", msg.Type)
+	assert.Equal(t, `Resource default:persistentvolumeclaim/test, file: manifests/test.yaml:
+
+> field is immutable`, msg.Body)
+	assert.Equal(t, testAlert.Failures[0].ID, msg.Event.ServiceIDs[0])
+}
+
+func TestDefaultFormatterFormatResolvedAlert(t *testing.T) {
+	d := DefaultFormatter{
+		vcsLink:            "https://github.com",
+		alertBodyTemplate:  alertBodyTemplate,
+		alertTitleTemplate: alertTitleTemplate,
+	}
+
+	resolved := testAlert
+	resolved.Resolved = true
+
+	msg := d.FormatAlert(resolved, &exporters.FakeExporter{})
+	assert.Equal(t, "Resolved: 1 resource(s) synced successfully", msg.Title)
+	assert.Equal(t, "resolved", msg.Type)
+	assert.Equal(t, "* default:persistentvolumeclaim/test, file: manifests/test.yaml, failing since 2019-04-08 09:00:00 UTC", msg.Body)
+}
+
+func TestDefaultFormatterAlertTemplates(t *testing.T) {
+	c := config.NewFakeConfig()
+	c.Set("github_url", "https://github.com")
+	c.Set("alert_title_template", "{{ if .Resolved }}OK{{ else }}FIRING{{ end }}")
+	c.Set("alert_body_template", "{{ range .Failures }}{{ .Failures }}{{ end }}")
+
+	formatter, err := NewDefaultFormatter(c)
+	assert.Nil(t, err)
+
+	msg := formatter.FormatAlert(testAlert, &exporters.FakeExporter{})
+	assert.Equal(t, "FIRING", msg.Title)
+	assert.Equal(t, "3", msg.Body)
+}
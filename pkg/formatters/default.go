@@ -2,17 +2,15 @@ package formatters
 
 import (
 	"bytes"
-	"log"
 	"strings"
-	"text/template"
-	"time"
 
 	"github.com/justinbarrick/fluxcloud/pkg/config"
 	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
 	"github.com/justinbarrick/fluxcloud/pkg/msg"
-	"github.com/weaveworks/flux"
+	"github.com/justinbarrick/fluxcloud/pkg/templates"
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
 	fluxevent "github.com/weaveworks/flux/event"
-	"github.com/weaveworks/flux/update"
 )
 
 const (
@@ -37,30 +35,34 @@ Resource {{ .ID }}, file: {{ .Path }}:
 	commitTemplate = `{{ .VCSLink }}/commit/{{ .Commit }}`
 )
 
-// The default formatter formats a message for a chat webhook
-type DefaultFormatter struct {
-	config         config.Config
-	vcsLink        string
-	bodyTemplate   string
-	titleTemplate  string
-	commitTemplate string
+// The Flux event types whose title and body can be set on their own, such as
+// with sync_title_template.
+var eventTypes = []string{
+	fluxevent.EventCommit,
+	fluxevent.EventSync,
+	fluxevent.EventRelease,
+	fluxevent.EventAutoRelease,
 }
 
-type tplValues struct {
-	VCSLink            string
-	EventID            fluxevent.EventID
-	EventServiceIDs    []flux.ResourceID
-	EventChangedImages []string
-	EventResult        update.Result
-	EventType          string
-	EventStartedAt     time.Time
-	EventEndedAt       time.Time
-	EventLogLevel      string
-	EventMessage       string
-	EventString        string
-	Commits            []fluxevent.Commit
-	Errors             []fluxevent.ResourceError
-	FormatLink         func(string, string) string
+// The default formatter formats a message for a chat webhook
+type DefaultFormatter struct {
+	config                  config.Config
+	vcsLink                 string
+	bodyTemplate            string
+	titleTemplate           string
+	commitTemplate          string
+	digestBodyTemplate      string
+	digestTitleTemplate     string
+	reportBodyTemplate      string
+	reportTitleTemplate     string
+	alertBodyTemplate       string
+	alertTitleTemplate      string
+	watchdogBodyTemplate    string
+	watchdogTitleTemplate   string
+	deploymentBodyTemplate  string
+	deploymentTitleTemplate string
+	eventBodyTemplates      map[string]string
+	eventTitleTemplates     map[string]string
 }
 
 type commitTemplateValues struct {
@@ -68,30 +70,6 @@ type commitTemplateValues struct {
 	Commit  string
 }
 
-var (
-	tplFuncMap = template.FuncMap{
-		"replace": func(input, from, to string) string {
-			return strings.Replace(input, from, to, -1)
-		},
-		"trim": func(input string) string {
-			return strings.TrimSpace(input)
-		},
-		"contains": func(input, substr string) bool {
-			return strings.Contains(input, substr)
-		},
-		"truncate": func(s string, max int) string {
-			var numRunes = 0
-			for i := range s {
-				numRunes++
-				if numRunes > max {
-					return s[:i]
-				}
-			}
-			return s
-		},
-	}
-)
-
 // Create a DefaultFormatter
 func NewDefaultFormatter(config config.Config) (*DefaultFormatter, error) {
 	vcsLink, err := config.Required("github_url")
@@ -99,31 +77,94 @@ func NewDefaultFormatter(config config.Config) (*DefaultFormatter, error) {
 		return nil, err
 	}
 
-	bodyTemplate := config.Optional("body_template", bodyTemplate)
-	titleTemplate := config.Optional("title_template", titleTemplate)
-	commitTemplate := config.Optional("commit_template", commitTemplate)
+	return newDefaultFormatter(config, &DefaultFormatter{
+		vcsLink:                 vcsLink,
+		bodyTemplate:            bodyTemplate,
+		titleTemplate:           titleTemplate,
+		commitTemplate:          commitTemplate,
+		digestBodyTemplate:      digestBodyTemplate,
+		digestTitleTemplate:     digestTitleTemplate,
+		reportBodyTemplate:      reportBodyTemplate,
+		reportTitleTemplate:     reportTitleTemplate,
+		alertBodyTemplate:       alertBodyTemplate,
+		alertTitleTemplate:      alertTitleTemplate,
+		watchdogBodyTemplate:    watchdogBodyTemplate,
+		watchdogTitleTemplate:   watchdogTitleTemplate,
+		deploymentBodyTemplate:  deploymentBodyTemplate,
+		deploymentTitleTemplate: deploymentTitleTemplate,
+	})
+}
 
-	if err := checkTemplate(bodyTemplate); err != nil {
-		log.Println(bodyTemplate)
-		return nil, err
+// Create a copy of the formatter with any settings overridden by config, used
+// to give each exporter instance its own templates.
+func (d *DefaultFormatter) ForConfig(config config.Config) (*DefaultFormatter, error) {
+	return newDefaultFormatter(config, d)
+}
+
+func newDefaultFormatter(config config.Config, defaults *DefaultFormatter) (*DefaultFormatter, error) {
+	vcsLink := config.Optional("github_url", defaults.vcsLink)
+	bodyTemplate := config.Optional("body_template", defaults.bodyTemplate)
+	titleTemplate := config.Optional("title_template", defaults.titleTemplate)
+	commitTemplate := config.Optional("commit_template", defaults.commitTemplate)
+	digestBodyTemplate := config.Optional("digest_body_template", defaults.digestBodyTemplate)
+	digestTitleTemplate := config.Optional("digest_title_template", defaults.digestTitleTemplate)
+	reportBodyTemplate := config.Optional("report_body_template", defaults.reportBodyTemplate)
+	reportTitleTemplate := config.Optional("report_title_template", defaults.reportTitleTemplate)
+	alertBodyTemplate := config.Optional("alert_body_template", defaults.alertBodyTemplate)
+	alertTitleTemplate := config.Optional("alert_title_template", defaults.alertTitleTemplate)
+	watchdogBodyTemplate := config.Optional("watchdog_body_template", defaults.watchdogBodyTemplate)
+	watchdogTitleTemplate := config.Optional("watchdog_title_template", defaults.watchdogTitleTemplate)
+	deploymentBodyTemplate := config.Optional("deployment_body_template", defaults.deploymentBodyTemplate)
+	deploymentTitleTemplate := config.Optional("deployment_title_template", defaults.deploymentTitleTemplate)
+
+	eventBodyTemplates := map[string]string{}
+	eventTitleTemplates := map[string]string{}
+	for _, eventType := range eventTypes {
+		if tpl := config.Optional(eventType+"_body_template", defaults.eventBodyTemplates[eventType]); tpl != "" {
+			eventBodyTemplates[eventType] = tpl
+		}
+		if tpl := config.Optional(eventType+"_title_template", defaults.eventTitleTemplates[eventType]); tpl != "" {
+			eventTitleTemplates[eventType] = tpl
+		}
 	}
 
-	if err := checkTemplate(titleTemplate); err != nil {
-		log.Println(titleTemplate)
-		return nil, err
+	templates := []string{
+		bodyTemplate, titleTemplate, commitTemplate,
+		digestBodyTemplate, digestTitleTemplate,
+		reportBodyTemplate, reportTitleTemplate,
+		alertBodyTemplate, alertTitleTemplate,
+		watchdogBodyTemplate, watchdogTitleTemplate,
+		deploymentBodyTemplate, deploymentTitleTemplate,
+	}
+	for _, eventType := range eventTypes {
+		templates = append(templates, eventBodyTemplates[eventType], eventTitleTemplates[eventType])
 	}
 
-	if err := checkTemplate(commitTemplate); err != nil {
-		log.Println(commitTemplate)
-		return nil, err
+	for _, tpl := range templates {
+		if err := checkTemplate(tpl); err != nil {
+			logging.Error("Invalid template", "template", tpl)
+			return nil, err
+		}
 	}
 
 	return &DefaultFormatter{
-		config:         config,
-		vcsLink:        vcsLink,
-		bodyTemplate:   bodyTemplate,
-		titleTemplate:  titleTemplate,
-		commitTemplate: commitTemplate,
+		config:                  config,
+		vcsLink:                 vcsLink,
+		bodyTemplate:            bodyTemplate,
+		titleTemplate:           titleTemplate,
+		commitTemplate:          commitTemplate,
+		digestBodyTemplate:      digestBodyTemplate,
+		digestTitleTemplate:     digestTitleTemplate,
+		reportBodyTemplate:      reportBodyTemplate,
+		reportTitleTemplate:     reportTitleTemplate,
+		alertBodyTemplate:       alertBodyTemplate,
+		alertTitleTemplate:      alertTitleTemplate,
+		watchdogBodyTemplate:    watchdogBodyTemplate,
+		watchdogTitleTemplate:   watchdogTitleTemplate,
+		deploymentBodyTemplate:  deploymentBodyTemplate,
+		deploymentTitleTemplate: deploymentTitleTemplate,
+		eventBodyTemplates:      eventBodyTemplates,
+		eventTitleTemplates:     eventTitleTemplates,
 	}, nil
 }
 
@@ -133,31 +174,16 @@ func (d DefaultFormatter) FormatEvent(event fluxevent.Event, exporter exporters.
 		return msg.Message{}
 	}
 
-	values := &tplValues{
-		VCSLink:            d.vcsLink,
-		EventID:            event.ID,
-		EventServiceIDs:    event.ServiceIDs,
-		EventChangedImages: getChangedImages(event.Metadata),
-		EventResult:        getResult(event.Metadata),
-		EventType:          event.Type,
-		EventStartedAt:     event.StartedAt,
-		EventEndedAt:       event.EndedAt,
-		EventLogLevel:      event.LogLevel,
-		EventMessage:       event.Message,
-		EventString:        event.String(),
-		Commits:            getCommits(event.Metadata),
-		Errors:             getErrors(event.Metadata),
-		FormatLink: func(link, text string) string {
-			return exporter.FormatLink(link, text)
-		},
-	}
+	values := templates.NewValues(d.vcsLink, event, func(link, text string) string {
+		return exporter.FormatLink(link, text)
+	})
 
 	nl := exporter.NewLine()
 
 	message := msg.Message{
 		TitleLink: d.vcsLink,
-		Title:     execTemplate(d.titleTemplate, values, nl),
-		Body:      execTemplate(d.bodyTemplate, values, nl),
+		Title:     execTemplate(eventTemplate(d.eventTitleTemplates, d.titleTemplate, event.Type), values, nl),
+		Body:      execTemplate(eventTemplate(d.eventBodyTemplates, d.bodyTemplate, event.Type), values, nl),
 		Type:      event.Type,
 		Event:     event,
 	}
@@ -166,7 +192,7 @@ func (d DefaultFormatter) FormatEvent(event fluxevent.Event, exporter exporters.
 		return msg.Message{}
 	}
 
-	commits := getCommits(event.Metadata)
+	commits := utils.GetCommits(event.Metadata)
 	if len(commits) > 0 {
 		message.TitleLink = execTemplate(d.commitTemplate, &commitTemplateValues{
 			VCSLink: d.vcsLink,
@@ -177,24 +203,29 @@ func (d DefaultFormatter) FormatEvent(event fluxevent.Event, exporter exporters.
 	return message
 }
 
+// Return the template set for the event type, or the template for all events.
+func eventTemplate(eventTemplates map[string]string, tpl string, eventType string) string {
+	if eventTpl, ok := eventTemplates[eventType]; ok {
+		return eventTpl
+	}
+	return tpl
+}
+
 func checkTemplate(tpl string) error {
-	bodyTpl := template.New("tpl").Funcs(tplFuncMap)
-	_, err := bodyTpl.Parse(tpl)
+	_, err := templates.Parse(tpl)
 	return err
 }
 
 func execTemplate(tpl string, values interface{}, nl string) string {
 	bodyBytes := &bytes.Buffer{}
 
-	var err error
-	bodyTpl := template.New("tpl").Funcs(tplFuncMap)
-	bodyTpl, err = bodyTpl.Parse(tpl)
+	bodyTpl, err := templates.Parse(tpl)
 	if err != nil {
-		log.Panicln("could not parse template")
+		panic("could not parse template")
 	}
 
 	if err := bodyTpl.Execute(bodyBytes, values); err != nil {
-		log.Println("could not execute template:", err)
+		logging.Error("Could not execute template", "err", err)
 		return ""
 	}
 
@@ -208,49 +239,3 @@ func execTemplate(tpl string, values interface{}, nl string) string {
 
 	return body
 }
-
-func getCommits(meta fluxevent.EventMetadata) []fluxevent.Commit {
-	switch v := meta.(type) {
-	case *fluxevent.CommitEventMetadata:
-		return []fluxevent.Commit{
-			fluxevent.Commit{
-				Revision: v.Revision,
-			},
-		}
-	case *fluxevent.SyncEventMetadata:
-		return v.Commits
-	default:
-		return []fluxevent.Commit{}
-	}
-}
-
-func getResult(meta fluxevent.EventMetadata) update.Result {
-	switch v := meta.(type) {
-	case *fluxevent.AutoReleaseEventMetadata:
-		return v.Result
-	case *fluxevent.ReleaseEventMetadata:
-		return v.Result
-	default:
-		return update.Result{}
-	}
-}
-
-func getChangedImages(meta fluxevent.EventMetadata) []string {
-	switch v := meta.(type) {
-	case *fluxevent.AutoReleaseEventMetadata:
-		return v.Result.ChangedImages()
-	case *fluxevent.ReleaseEventMetadata:
-		return v.Result.ChangedImages()
-	default:
-		return []string{}
-	}
-}
-
-func getErrors(meta fluxevent.EventMetadata) []fluxevent.ResourceError {
-	switch v := meta.(type) {
-	case *fluxevent.SyncEventMetadata:
-		return v.Errors
-	default:
-		return []fluxevent.ResourceError{}
-	}
-}
@@ -214,3 +214,62 @@ Resource default:persistentvolumeclaim/lol, file: manifests/lol.yaml:
 > running kubectl: The PersistentVolumeClaim "lol" is invalid: spec: Forbidden: field is immutable after creation`, msg.Body)
 	assert.Equal(t, event, msg.Event)
 }
+
+func TestDefaultFormatterForConfig(t *testing.T) {
+	sharedConfig := config.NewFakeConfig()
+	sharedConfig.Set("github_url", "https://github.com/")
+	sharedConfig.Set("title_template", "Shared title")
+
+	formatter, err := NewDefaultFormatter(sharedConfig)
+	assert.Nil(t, err)
+
+	instanceConfig := config.NewFakeConfig()
+	instanceConfig.Set("title_template", "Instance title")
+
+	instance, err := formatter.ForConfig(instanceConfig)
+	assert.Nil(t, err)
+	assert.Equal(t, "Instance title", instance.titleTemplate)
+	assert.Equal(t, bodyTemplate, instance.bodyTemplate)
+	assert.Equal(t, "https://github.com/", instance.vcsLink)
+	assert.Equal(t, "Shared title", formatter.titleTemplate)
+
+	instanceConfig.Set("body_template", "{{ .Broken ")
+	_, err = formatter.ForConfig(instanceConfig)
+	assert.NotNil(t, err)
+}
+
+func TestDefaultFormatterEventTypeTemplates(t *testing.T) {
+	sharedConfig := config.NewFakeConfig()
+	sharedConfig.Set("github_url", "https://github.com/")
+	sharedConfig.Set("sync_title_template", "Synced")
+
+	formatter, err := NewDefaultFormatter(sharedConfig)
+	assert.Nil(t, err)
+
+	instanceConfig := config.NewFakeConfig()
+	instanceConfig.Set("title_template", "Instance title")
+	instanceConfig.Set("commit_title_template", "Committed")
+	instanceConfig.Set("commit_body_template", "{{ .EventType }} body")
+
+	instance, err := formatter.ForConfig(instanceConfig)
+	assert.Nil(t, err)
+
+	message := instance.FormatEvent(test_utils.NewFluxCommitEvent(), &exporters.FakeExporter{})
+	assert.Equal(t, "Committed", message.Title)
+	assert.Equal(t, "commit body", message.Body)
+
+	// the shared template for an event type is kept by the instance
+	message = instance.FormatEvent(test_utils.NewFluxSyncEvent(), &exporters.FakeExporter{})
+	assert.Equal(t, "Synced", message.Title)
+
+	message = instance.FormatEvent(test_utils.NewFluxAutoReleaseEvent(), &exporters.FakeExporter{})
+	assert.Equal(t, "Instance title", message.Title)
+
+	// the shared formatter does not see the instance's templates
+	message = formatter.FormatEvent(test_utils.NewFluxCommitEvent(), &exporters.FakeExporter{})
+	assert.Equal(t, titleTemplate, message.Title)
+
+	instanceConfig.Set("release_body_template", "{{ .Broken ")
+	_, err = formatter.ForConfig(instanceConfig)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,76 @@
+package formatters
+
+import (
+	"github.com/justinbarrick/fluxcloud/pkg/deployments"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+)
+
+const (
+	deploymentTitleTemplate = `Deployment of {{ truncate .Revision 7 }}: {{ .State }}`
+	deploymentBodyTemplate  = `
+{{ range .Stages }}* {{ .State }} ({{ .EventType }}) at {{ .Time.Format "15:04:05 MST" }}
+{{ end }}
+{{ if gt (len .Commits) 0 }}Commits:
+{{ range .Commits }}
+* {{ call $.FormatLink (print $.VCSLink "/commit/" .Revision) (truncate .Revision 7) }}{{ if .Message }}: {{ .Message }}{{ end }}
+{{end}}{{end}}
+{{ if gt (len .Resources) 0 }}Resources:
+{{ range .Resources }}
+* {{ . }}
+{{ end }}{{ end }}
+{{ if gt (len .ChangedImages) 0 }}Images:
+{{ range .ChangedImages }}
+* {{ . }}
+{{ end }}{{ end }}
+{{ if gt (len .Errors) 0 }}Errors:
+{{ range .Errors }}
+Resource {{ .ID }}, file: {{ .Path }}:
+
+> {{ .Error }}
+{{ end }}{{ end }}
+`
+)
+
+type deploymentValues struct {
+	deployments.Deployment
+	VCSLink    string
+	FormatLink func(string, string) string
+}
+
+// Format the current state of a deployment. The message has a notification
+// key so that exporters can update the message about the deployment's earlier
+// states.
+func (d DefaultFormatter) FormatDeployment(deployment deployments.Deployment, exporter exporters.Exporter) msg.Message {
+	if len(deployment.Resources) == 0 {
+		return msg.Message{}
+	}
+
+	values := &deploymentValues{
+		Deployment: deployment,
+		VCSLink:    d.vcsLink,
+		FormatLink: func(link, text string) string {
+			return exporter.FormatLink(link, text)
+		},
+	}
+
+	nl := exporter.NewLine()
+
+	message := msg.Message{
+		TitleLink: execTemplate(d.commitTemplate, &commitTemplateValues{
+			VCSLink: d.vcsLink,
+			Commit:  deployment.Revision,
+		}, nl),
+		Title:           execTemplate(d.deploymentTitleTemplate, values, nl),
+		Body:            execTemplate(d.deploymentBodyTemplate, values, nl),
+		Type:            "deployment",
+		Event:           deployment.Event(),
+		NotificationKey: "deployment-" + deployment.Key(),
+	}
+
+	if message.Title == "" || message.Body == "" {
+		return msg.Message{}
+	}
+
+	return message
+}
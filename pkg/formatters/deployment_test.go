@@ -0,0 +1,86 @@
+package formatters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/deployments"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/stretchr/testify/assert"
+	"github.com/weaveworks/flux"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+var testDeployment = deployments.Deployment{
+	Revision:      "d644e1a05db6881abf0cdb78299917b95f442036",
+	State:         deployments.StateReleased,
+	Resources:     []flux.ResourceID{flux.MustParseResourceID("default:deployment/test")},
+	Commits:       []fluxevent.Commit{{Revision: "d644e1a05db6881abf0cdb78299917b95f442036", Message: "update image"}},
+	ChangedImages: []string{"justinbarrick/nginx:test3"},
+	Stages: []deployments.Stage{
+		{State: deployments.StateCommitted, EventType: "commit", Time: time.Date(2019, 4, 8, 9, 0, 0, 0, time.UTC)},
+		{State: deployments.StateSynced, EventType: "sync", Time: time.Date(2019, 4, 8, 9, 1, 0, 0, time.UTC)},
+		{State: deployments.StateReleased, EventType: "autorelease", Time: time.Date(2019, 4, 8, 9, 1, 5, 0, time.UTC)},
+	},
+}
+
+func TestDefaultFormatterFormatDeployment(t *testing.T) {
+	d := DefaultFormatter{
+		vcsLink:                 "https://github.com",
+		commitTemplate:          commitTemplate,
+		deploymentBodyTemplate:  deploymentBodyTemplate,
+		deploymentTitleTemplate: deploymentTitleTemplate,
+	}
+
+	msg := d.FormatDeployment(testDeployment, &exporters.FakeExporter{})
+	assert.Equal(t, "Deployment of d644e1a: released", msg.Title)
+	assert.Equal(t, "https://github.com/commit/d644e1a05db6881abf0cdb78299917b95f442036", msg.TitleLink)
+	assert.Equal(t, "deployment", msg.Type)
+	assert.Equal(t, "deployment-d644e1a05db6881abf0cdb78299917b95f442036", msg.NotificationKey)
+	assert.Equal(t, "autorelease", msg.Event.Type)
+	assert.Equal(t, `* committed (commit) at 09:00:00 UTC
+* synced (sync) at 09:01:00 UTC
+* released (autorelease) at 09:01:05 UTC
+
+Commits:
+
+* <https://github.com/commit/d644e1a05db6881abf0cdb78299917b95f442036|d644e1a>: update image
+
+Resources:
+
+* default:deployment/test
+
+Images:
+
+* justinbarrick/nginx:test3`, msg.Body)
+}
+
+func TestDefaultFormatterFormatDeploymentWithoutResources(t *testing.T) {
+	d := DefaultFormatter{
+		vcsLink:                 "https://github.com",
+		commitTemplate:          commitTemplate,
+		deploymentBodyTemplate:  deploymentBodyTemplate,
+		deploymentTitleTemplate: deploymentTitleTemplate,
+	}
+
+	deployment := testDeployment
+	deployment.Resources = nil
+
+	msg := d.FormatDeployment(deployment, &exporters.FakeExporter{})
+	assert.Equal(t, "", msg.Title)
+}
+
+func TestDefaultFormatterDeploymentTemplates(t *testing.T) {
+	c := config.NewFakeConfig()
+	c.Set("github_url", "https://github.com")
+	c.Set("deployment_title_template", "{{ .State }}")
+	c.Set("deployment_body_template", "{{ len .Stages }}")
+
+	formatter, err := NewDefaultFormatter(c)
+	assert.Nil(t, err)
+
+	msg := formatter.FormatDeployment(testDeployment, &exporters.FakeExporter{})
+	assert.Equal(t, "released", msg.Title)
+	assert.Equal(t, "3", msg.Body)
+}
@@ -0,0 +1,157 @@
+package formatters
+
+import (
+	"sort"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
+	"github.com/weaveworks/flux"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+const (
+	digestTitleTemplate = `Applied flux changes to cluster{{ if .Cluster }} {{ .Cluster }}{{ end }} ({{ len .Events }} events)`
+	digestBodyTemplate  = `
+{{ if gt (len .Commits) 0 }}Commits:
+{{ range .Commits }}
+* {{ call $.FormatLink (print $.VCSLink "/commit/" .Revision) (truncate .Revision 7) }}{{ if .Message }}: {{ .Message }}{{ end }}
+{{end}}{{end}}
+{{ if gt (len .Namespaces) 0 }}Resources updated:
+{{ range .Namespaces }}
+{{ .Namespace }}:
+{{ range .Resources }}
+* {{ . }}
+{{ end }}{{ end }}{{ end }}
+{{ if gt (len .Errors) 0 }}Errors:
+{{ range .Errors }}
+Resource {{ .ID }}, file: {{ .Path }}:
+
+> {{ .Error }}
+{{ end }}{{ end }}
+`
+)
+
+// The resources in a namespace that were updated.
+type NamespaceResources struct {
+	Namespace string
+	Resources []flux.ResourceID
+}
+
+type digestValues struct {
+	Cluster    string
+	VCSLink    string
+	Events     []fluxevent.Event
+	EventTypes []string
+	StartedAt  time.Time
+	EndedAt    time.Time
+	Commits    []fluxevent.Commit
+	Namespaces []NamespaceResources
+	Errors     []fluxevent.ResourceError
+	FormatLink func(string, string) string
+}
+
+// Format several Flux events from a cluster as one message, listing all of
+// their commits, the updated resources grouped by namespace and all errors.
+func (d DefaultFormatter) FormatDigest(cluster string, events []fluxevent.Event, exporter exporters.Exporter) msg.Message {
+	if len(events) == 0 {
+		return msg.Message{}
+	}
+
+	values := newDigestValues(events)
+	values.Cluster = cluster
+	values.VCSLink = d.vcsLink
+	values.FormatLink = func(link, text string) string {
+		return exporter.FormatLink(link, text)
+	}
+
+	nl := exporter.NewLine()
+
+	message := msg.Message{
+		TitleLink: d.vcsLink,
+		Title:     execTemplate(d.digestTitleTemplate, values, nl),
+		Body:      execTemplate(d.digestBodyTemplate, values, nl),
+		Type:      "digest",
+		Event:     events[len(events)-1],
+		Events:    events,
+		Cluster:   cluster,
+	}
+
+	if message.Title == "" || message.Body == "" {
+		return msg.Message{}
+	}
+
+	return message
+}
+
+func newDigestValues(events []fluxevent.Event) *digestValues {
+	values := &digestValues{
+		Events:     events,
+		EventTypes: []string{},
+		Commits:    []fluxevent.Commit{},
+		Namespaces: []NamespaceResources{},
+		Errors:     []fluxevent.ResourceError{},
+	}
+
+	commits := map[string]int{}
+	resources := map[string]map[flux.ResourceID]bool{}
+	types := map[string]bool{}
+
+	for _, event := range events {
+		if values.StartedAt.IsZero() || event.StartedAt.Before(values.StartedAt) {
+			values.StartedAt = event.StartedAt
+		}
+
+		if event.EndedAt.After(values.EndedAt) {
+			values.EndedAt = event.EndedAt
+		}
+
+		if !types[event.Type] {
+			types[event.Type] = true
+			values.EventTypes = append(values.EventTypes, event.Type)
+		}
+
+		for _, commit := range utils.GetCommits(event.Metadata) {
+			if i, ok := commits[commit.Revision]; ok {
+				// commit events only have the revision, prefer one with a message
+				if values.Commits[i].Message == "" {
+					values.Commits[i].Message = commit.Message
+				}
+				continue
+			}
+
+			commits[commit.Revision] = len(values.Commits)
+			values.Commits = append(values.Commits, commit)
+		}
+
+		for _, id := range event.ServiceIDs {
+			namespace, _, _ := id.Components()
+			if resources[namespace] == nil {
+				resources[namespace] = map[flux.ResourceID]bool{}
+			}
+			resources[namespace][id] = true
+		}
+
+		values.Errors = append(values.Errors, utils.GetErrors(event.Metadata)...)
+	}
+
+	for namespace, ids := range resources {
+		group := NamespaceResources{Namespace: namespace}
+		for id := range ids {
+			group.Resources = append(group.Resources, id)
+		}
+
+		sort.Slice(group.Resources, func(i, j int) bool {
+			return group.Resources[i].String() < group.Resources[j].String()
+		})
+
+		values.Namespaces = append(values.Namespaces, group)
+	}
+
+	sort.Slice(values.Namespaces, func(i, j int) bool {
+		return values.Namespaces[i].Namespace < values.Namespaces[j].Namespace
+	})
+
+	return values
+}
@@ -0,0 +1,87 @@
+package formatters
+
+import (
+	"testing"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+func TestDefaultFormatterFormatDigest(t *testing.T) {
+	d := DefaultFormatter{
+		vcsLink:             "https://github.com",
+		digestBodyTemplate:  digestBodyTemplate,
+		digestTitleTemplate: digestTitleTemplate,
+	}
+
+	events := []fluxevent.Event{
+		test_utils.NewFluxSyncEvent(),
+		test_utils.NewFluxSyncErrorEvent(),
+		test_utils.NewFluxSyncEvent(),
+	}
+
+	msg := d.FormatDigest("production", events, &exporters.FakeExporter{})
+	assert.Equal(t, "https://github.com", msg.TitleLink)
+	assert.Equal(t, "Applied flux changes to cluster production (3 events)", msg.Title)
+	assert.Equal(t, "digest", msg.Type)
+	assert.Equal(t, "production", msg.Cluster)
+	assert.Equal(t, events, msg.Events)
+	assert.Equal(t, `Commits:
+
+* <https://github.com/commit/810c2e6f22ac5ab7c831fe0dd697fe32997b098f|810c2e6>: change test image
+
+* <https://github.com/commit/4997efcd4ac6255604d0d44eeb7085c5b0eb9d48|4997efc>: create invalid resource
+
+Resources updated:
+
+default:
+
+* default:deployment/test
+
+* default:persistentvolumeclaim/test
+
+Errors:
+
+Resource default:persistentvolumeclaim/test, file: manifests/test.yaml:
+
+> running kubectl: The PersistentVolumeClaim "test" is invalid: spec: Forbidden: field is immutable after creation
+
+Resource default:persistentvolumeclaim/lol, file: manifests/lol.yaml:
+
+> running kubectl: The PersistentVolumeClaim "lol" is invalid: spec: Forbidden: field is immutable after creation`, msg.Body)
+}
+
+func TestDefaultFormatterFormatDigestEmpty(t *testing.T) {
+	d := DefaultFormatter{
+		digestBodyTemplate:  digestBodyTemplate,
+		digestTitleTemplate: digestTitleTemplate,
+	}
+
+	assert.Equal(t, "", d.FormatDigest("", nil, &exporters.FakeExporter{}).Title)
+}
+
+func TestDefaultFormatterDigestTemplates(t *testing.T) {
+	c := config.NewFakeConfig()
+	c.Set("github_url", "https://github.com")
+	c.Set("digest_title_template", "{{ len .Commits }} commits")
+	c.Set("digest_body_template", "{{ range .Namespaces }}{{ .Namespace }} {{ end }}")
+
+	formatter, err := NewDefaultFormatter(c)
+	assert.Nil(t, err)
+
+	events := []fluxevent.Event{
+		test_utils.NewFluxCommitEvent(),
+		test_utils.NewFluxSyncEvent(),
+	}
+
+	msg := formatter.FormatDigest("", events, &exporters.FakeExporter{})
+	assert.Equal(t, "2 commits", msg.Title)
+	assert.Equal(t, "default", msg.Body)
+
+	c.Set("digest_body_template", "{{ .Broken ")
+	_, err = NewDefaultFormatter(c)
+	assert.NotNil(t, err)
+}
@@ -1,12 +1,32 @@
 package formatters
 
 import (
+	"github.com/justinbarrick/fluxcloud/pkg/alerts"
+	"github.com/justinbarrick/fluxcloud/pkg/deployments"
 	"github.com/justinbarrick/fluxcloud/pkg/exporters"
 	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/report"
+	"github.com/justinbarrick/fluxcloud/pkg/watchdog"
 	fluxevent "github.com/weaveworks/flux/event"
 )
 
 // Formats a flux event for an exporter
 type Formatter interface {
 	FormatEvent(event fluxevent.Event, exporter exporters.Exporter) msg.Message
+
+	// Format several flux events from a cluster as one message.
+	FormatDigest(cluster string, events []fluxevent.Event, exporter exporters.Exporter) msg.Message
+
+	// Format a scheduled deployment report.
+	FormatReport(report report.Report, exporter exporters.Exporter) msg.Message
+
+	// Format an alert about resources that started or stopped failing to sync.
+	FormatAlert(alert alerts.Alert, exporter exporters.Exporter) msg.Message
+
+	// Format an alert about a cluster that went quiet or recovered.
+	FormatWatchdog(alert watchdog.Alert, exporter exporters.Exporter) msg.Message
+
+	// Format the current state of a deployment, which replaces the messages
+	// about its earlier states.
+	FormatDeployment(deployment deployments.Deployment, exporter exporters.Exporter) msg.Message
 }
@@ -0,0 +1,55 @@
+package formatters
+
+import (
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/report"
+)
+
+const (
+	reportTitleTemplate = `Flux deployment report for {{ .Start.Format "2006-01-02" }} to {{ .End.Format "2006-01-02" }}`
+	reportBodyTemplate  = `
+Syncs: {{ .Syncs }}
+Releases: {{ .Releases }}
+Changed images: {{ len .ChangedImages }}
+Failed resources: {{ len .FailedResources }}
+{{ range .Namespaces }}
+{{ .Namespace }}: {{ .Syncs }} syncs, {{ .Releases }} releases
+{{ range .ChangedImages }}* {{ . }}
+{{ end }}{{ range .FailedResources }}* failed: {{ . }}
+{{ end }}{{ end }}
+`
+)
+
+type reportValues struct {
+	report.Report
+	VCSLink    string
+	FormatLink func(string, string) string
+}
+
+// Format a deployment report for an exporter.
+func (d DefaultFormatter) FormatReport(summary report.Report, exporter exporters.Exporter) msg.Message {
+	values := &reportValues{
+		Report:  summary,
+		VCSLink: d.vcsLink,
+		FormatLink: func(link, text string) string {
+			return exporter.FormatLink(link, text)
+		},
+	}
+
+	nl := exporter.NewLine()
+
+	message := msg.Message{
+		TitleLink: d.vcsLink,
+		Title:     execTemplate(d.reportTitleTemplate, values, nl),
+		Body:      execTemplate(d.reportBodyTemplate, values, nl),
+		Type:      "report",
+		Report:    &summary,
+	}
+
+	if message.Title == "" || message.Body == "" {
+		return msg.Message{}
+	}
+
+	return message
+}
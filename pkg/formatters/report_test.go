@@ -0,0 +1,84 @@
+package formatters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/report"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+var (
+	reportStart = time.Date(2019, 4, 8, 9, 0, 0, 0, time.UTC)
+	reportEnd   = time.Date(2019, 4, 15, 9, 0, 0, 0, time.UTC)
+)
+
+func TestDefaultFormatterFormatReport(t *testing.T) {
+	d := DefaultFormatter{
+		vcsLink:             "https://github.com",
+		reportBodyTemplate:  reportBodyTemplate,
+		reportTitleTemplate: reportTitleTemplate,
+	}
+
+	events := []fluxevent.Event{
+		test_utils.NewFluxSyncErrorEvent(),
+		test_utils.NewFluxAutoReleaseEvent(),
+	}
+
+	msg := d.FormatReport(report.NewReport(events, reportStart, reportEnd), &exporters.FakeExporter{})
+	assert.Equal(t, "Flux deployment report for 2019-04-08 to 2019-04-15", msg.Title)
+	assert.Equal(t, "report", msg.Type)
+	assert.Equal(t, `Syncs: 1
+Releases: 1
+Changed images: 1
+Failed resources: 2
+
+default: 1 syncs, 1 releases
+* justinbarrick/nginx:test3
+* failed: default:persistentvolumeclaim/test
+* failed: default:persistentvolumeclaim/lol`, msg.Body)
+}
+
+func TestDefaultFormatterReportTemplates(t *testing.T) {
+	c := config.NewFakeConfig()
+	c.Set("github_url", "https://github.com")
+	c.Set("report_title_template", "Weekly report")
+	c.Set("report_body_template", "{{ .Events }} events")
+
+	formatter, err := NewDefaultFormatter(c)
+	assert.Nil(t, err)
+
+	msg := formatter.FormatReport(report.NewReport(nil, reportStart, reportEnd), &exporters.FakeExporter{})
+	assert.Equal(t, "Weekly report", msg.Title)
+	assert.Equal(t, "0 events", msg.Body)
+
+	c.Set("report_title_template", "{{ .Broken ")
+	_, err = NewDefaultFormatter(c)
+	assert.NotNil(t, err)
+}
+
+func TestDefaultFormatterReportSlack(t *testing.T) {
+	c := config.NewFakeConfig()
+	c.Set("github_url", "https://github.com")
+	c.Set("slack_url", "https://myslack/")
+	c.Set("slack_channel", "#reports=*,#team-b=team-b")
+
+	formatter, err := NewDefaultFormatter(c)
+	require.Nil(t, err)
+
+	slack, err := exporters.NewSlack(c)
+	require.Nil(t, err)
+
+	events := []fluxevent.Event{test_utils.NewFluxAutoReleaseEvent()}
+	msg := formatter.FormatReport(report.NewReport(events, reportStart, reportEnd), slack)
+
+	slackMessages := slack.NewSlackMessage(msg)
+	require.Len(t, slackMessages, 1)
+	assert.Equal(t, "#reports", slackMessages[0].Channel)
+	assert.Equal(t, msg.Title, slackMessages[0].Attachments[0].Title)
+}
@@ -0,0 +1,55 @@
+package formatters
+
+import (
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/watchdog"
+)
+
+const (
+	watchdogTitleTemplate = `{{ if .Stale }}{{ if .Recovered }}Recovered: Flux is syncing cluster {{ .Cluster.Name }} again{{ else }}Flux has not synced cluster {{ .Cluster.Name }} for {{ .Threshold }}{{ end }}{{ else }}{{ if .Recovered }}Recovered: Flux is running on cluster {{ .Cluster.Name }} again{{ else }}Flux has not synced or answered a heartbeat on cluster {{ .Cluster.Name }} for {{ .Threshold }}{{ end }}{{ end }}`
+	watchdogBodyTemplate  = `
+{{ if .Recovered }}{{ if .Stale }}Flux synced cluster {{ .Cluster.Name }} at {{ .Cluster.LastSync.Format "2006-01-02 15:04:05 MST" }}.{{ else }}Flux was last seen on cluster {{ .Cluster.Name }} at {{ .Cluster.LastSeen.Format "2006-01-02 15:04:05 MST" }}.{{ end }}
+{{ else }}{{ if .Cluster.LastSync.IsZero }}No sync seen since {{ .Cluster.FirstSeen.Format "2006-01-02 15:04:05 MST" }}.{{ else }}Last sync: {{ .Cluster.LastSync.Format "2006-01-02 15:04:05 MST" }}.{{ end }}
+{{ if .Cluster.Connected }}{{ if .Stale }}The daemon is connected and answering heartbeats, it may have lost access to git.{{ else }}The daemon is connected but stopped answering heartbeats, last heartbeat: {{ .Cluster.LastHeartbeat.Format "2006-01-02 15:04:05 MST" }}.{{ end }}{{ else }}The daemon is not connected to fluxcloud.{{ end }}
+{{ end }}
+`
+)
+
+type watchdogValues struct {
+	watchdog.Alert
+	VCSLink    string
+	FormatLink func(string, string) string
+}
+
+// Format an alert about a cluster that went quiet or stopped syncing, or
+// recovered.
+func (d DefaultFormatter) FormatWatchdog(alert watchdog.Alert, exporter exporters.Exporter) msg.Message {
+	values := &watchdogValues{
+		Alert:   alert,
+		VCSLink: d.vcsLink,
+		FormatLink: func(link, text string) string {
+			return exporter.FormatLink(link, text)
+		},
+	}
+
+	nl := exporter.NewLine()
+
+	message := msg.Message{
+		TitleLink: d.vcsLink,
+		Title:     execTemplate(d.watchdogTitleTemplate, values, nl),
+		Body:      execTemplate(d.watchdogBodyTemplate, values, nl),
+		Type:      "stalled",
+		Event:     alert.Event(),
+	}
+
+	if alert.Recovered {
+		message.Type = "recovered"
+	}
+
+	if message.Title == "" || message.Body == "" {
+		return msg.Message{}
+	}
+
+	return message
+}
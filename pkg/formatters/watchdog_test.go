@@ -0,0 +1,98 @@
+package formatters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/exporters"
+	"github.com/justinbarrick/fluxcloud/pkg/watchdog"
+	"github.com/stretchr/testify/assert"
+)
+
+var testWatchdogAlert = watchdog.Alert{
+	Cluster: watchdog.Cluster{
+		Name:          "10.0.0.1",
+		FirstSeen:     time.Date(2019, 4, 8, 8, 0, 0, 0, time.UTC),
+		LastSync:      time.Date(2019, 4, 8, 9, 0, 0, 0, time.UTC),
+		LastHeartbeat: time.Date(2019, 4, 8, 9, 30, 0, 0, time.UTC),
+		Connected:     true,
+	},
+	Threshold: 30 * time.Minute,
+}
+
+func TestDefaultFormatterFormatWatchdog(t *testing.T) {
+	d := DefaultFormatter{
+		vcsLink:               "https://github.com",
+		watchdogBodyTemplate:  watchdogBodyTemplate,
+		watchdogTitleTemplate: watchdogTitleTemplate,
+	}
+
+	msg := d.FormatWatchdog(testWatchdogAlert, &exporters.FakeExporter{})
+	assert.Equal(t, "Flux has not synced or answered a heartbeat on cluster 10.0.0.1 for 30m0s", msg.Title)
+	assert.Equal(t, "stalled", msg.Type)
+	assert.Equal(t, `Last sync: 2019-04-08 09:00:00 UTC.
+The daemon is connected but stopped answering heartbeats, last heartbeat: 2019-04-08 09:30:00 UTC.`, msg.Body)
+
+	neverSynced := testWatchdogAlert
+	neverSynced.Cluster.LastSync = time.Time{}
+	neverSynced.Cluster.Connected = false
+
+	msg = d.FormatWatchdog(neverSynced, &exporters.FakeExporter{})
+	assert.Equal(t, `No sync seen since 2019-04-08 08:00:00 UTC.
+The daemon is not connected to fluxcloud.`, msg.Body)
+}
+
+func TestDefaultFormatterFormatWatchdogRecovered(t *testing.T) {
+	d := DefaultFormatter{
+		vcsLink:               "https://github.com",
+		watchdogBodyTemplate:  watchdogBodyTemplate,
+		watchdogTitleTemplate: watchdogTitleTemplate,
+	}
+
+	recovered := testWatchdogAlert
+	recovered.Recovered = true
+
+	msg := d.FormatWatchdog(recovered, &exporters.FakeExporter{})
+	assert.Equal(t, "Recovered: Flux is running on cluster 10.0.0.1 again", msg.Title)
+	assert.Equal(t, "recovered", msg.Type)
+	assert.Equal(t, "Flux was last seen on cluster 10.0.0.1 at 2019-04-08 09:30:00 UTC.", msg.Body)
+}
+
+func TestDefaultFormatterFormatWatchdogStale(t *testing.T) {
+	d := DefaultFormatter{
+		vcsLink:               "https://github.com",
+		watchdogBodyTemplate:  watchdogBodyTemplate,
+		watchdogTitleTemplate: watchdogTitleTemplate,
+	}
+
+	stale := testWatchdogAlert
+	stale.Stale = true
+	stale.Threshold = 24 * time.Hour
+
+	msg := d.FormatWatchdog(stale, &exporters.FakeExporter{})
+	assert.Equal(t, "Flux has not synced cluster 10.0.0.1 for 24h0m0s", msg.Title)
+	assert.Equal(t, "stalled", msg.Type)
+	assert.Equal(t, `Last sync: 2019-04-08 09:00:00 UTC.
+The daemon is connected and answering heartbeats, it may have lost access to git.`, msg.Body)
+
+	stale.Recovered = true
+
+	msg = d.FormatWatchdog(stale, &exporters.FakeExporter{})
+	assert.Equal(t, "Recovered: Flux is syncing cluster 10.0.0.1 again", msg.Title)
+	assert.Equal(t, "Flux synced cluster 10.0.0.1 at 2019-04-08 09:00:00 UTC.", msg.Body)
+}
+
+func TestDefaultFormatterWatchdogTemplates(t *testing.T) {
+	c := config.NewFakeConfig()
+	c.Set("github_url", "https://github.com")
+	c.Set("watchdog_title_template", "{{ if .Recovered }}UP{{ else }}DOWN{{ end }}")
+	c.Set("watchdog_body_template", "{{ .Cluster.Name }}")
+
+	formatter, err := NewDefaultFormatter(c)
+	assert.Nil(t, err)
+
+	msg := formatter.FormatWatchdog(testWatchdogAlert, &exporters.FakeExporter{})
+	assert.Equal(t, "DOWN", msg.Title)
+	assert.Equal(t, "10.0.0.1", msg.Body)
+}
@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	stdlog "log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+)
+
+var (
+	lock   sync.RWMutex
+	logger log.Logger
+)
+
+func init() {
+	logger, _ = NewLogger(os.Stderr, "logfmt", "info")
+}
+
+// Create a logger that writes entries of level or above to w as logfmt or
+// JSON, with secrets redacted, see Redact.
+func NewLogger(w io.Writer, format string, lvl string) (log.Logger, error) {
+	var l log.Logger
+
+	switch strings.ToLower(format) {
+	case "logfmt", "":
+		l = log.NewLogfmtLogger(log.NewSyncWriter(w))
+	case "json":
+		l = log.NewJSONLogger(log.NewSyncWriter(w))
+	default:
+		return nil, fmt.Errorf("Invalid log format %s: must be logfmt or json", format)
+	}
+
+	var option level.Option
+	switch strings.ToLower(lvl) {
+	case "debug":
+		option = level.AllowDebug()
+	case "info", "":
+		option = level.AllowInfo()
+	case "warn":
+		option = level.AllowWarn()
+	case "error":
+		option = level.AllowError()
+	default:
+		return nil, fmt.Errorf("Invalid log level %s: must be debug, info, warn or error", lvl)
+	}
+
+	l = level.NewFilter(&redactor{next: l}, option)
+	return log.With(l, "ts", log.DefaultTimestampUTC), nil
+}
+
+// Configure the logger from the log_format and log_level settings, and send
+// the standard library's log output through it.
+func Configure(config config.Config) error {
+	l, err := NewLogger(os.Stderr, config.Optional("log_format", "logfmt"), config.Optional("log_level", "info"))
+	if err != nil {
+		return err
+	}
+
+	SetLogger(l)
+	return nil
+}
+
+// Replace the logger, and send the standard library's log output through it.
+func SetLogger(l log.Logger) {
+	lock.Lock()
+	logger = l
+	lock.Unlock()
+
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(log.NewStdlibAdapter(level.Info(l)))
+}
+
+// Return the logger.
+func Logger() log.Logger {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return logger
+}
+
+// Log a message for debugging with key value pairs.
+func Debug(msg string, keyvals ...interface{}) {
+	level.Debug(Logger()).Log(append([]interface{}{"msg", msg}, keyvals...)...)
+}
+
+// Log a message with key value pairs.
+func Info(msg string, keyvals ...interface{}) {
+	level.Info(Logger()).Log(append([]interface{}{"msg", msg}, keyvals...)...)
+}
+
+// Log a warning with key value pairs.
+func Warn(msg string, keyvals ...interface{}) {
+	level.Warn(Logger()).Log(append([]interface{}{"msg", msg}, keyvals...)...)
+}
+
+// Log an error with key value pairs.
+func Error(msg string, keyvals ...interface{}) {
+	level.Error(Logger()).Log(append([]interface{}{"msg", msg}, keyvals...)...)
+}
+
+// Log an error with key value pairs and exit.
+func Fatal(msg string, keyvals ...interface{}) {
+	Error(msg, keyvals...)
+	os.Exit(1)
+}
@@ -0,0 +1,174 @@
+package logging
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Replaces secrets in log entries.
+const Redacted = "REDACTED"
+
+// Secrets that are recognized by their shape.
+var patterns = []struct {
+	regexp      *regexp.Regexp
+	replacement string
+}{
+	// tokens in query strings and in Flux's Scope-Probe authorization header
+	{regexp.MustCompile(`(?i)\b(access_token|token|api_key|apikey|secret|password|signature|sig)=[^&\s"',;:]+`), "${1}=" + Redacted},
+	// authorization headers
+	{regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9\-._~+/]+=*`), "${1} " + Redacted},
+	// Slack tokens
+	{regexp.MustCompile(`\bxox[a-z]-[A-Za-z0-9\-]+`), Redacted},
+	// Slack, MS Teams and Discord webhook URLs
+	{regexp.MustCompile(`(hooks\.slack\.com/(services|workflows)/)[^\s"']+`), "${1}" + Redacted},
+	{regexp.MustCompile(`(\.office(365)?\.com/webhook[a-z0-9]*/)[^\s"']+`), "${1}" + Redacted},
+	{regexp.MustCompile(`(discord(app)?\.com/api/webhooks/)[^\s"']+`), "${1}" + Redacted},
+	// passwords in URLs
+	{regexp.MustCompile(`(://[^/\s:@]+:)[^/\s@]+@`), "${1}" + Redacted + "@"},
+}
+
+// Keys whose values are always redacted.
+var sensitiveKeys = []string{"token", "secret", "password", "authorization"}
+
+// Headers whose values are always redacted.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+var secrets = struct {
+	sync.RWMutex
+	replacements map[string]string
+}{replacements: map[string]string{}}
+
+// Redact values wherever they appear in log entries, such as configured
+// tokens and webhook URLs. The path and query of URLs are redacted, keeping
+// their host.
+func Secret(values ...string) {
+	secrets.Lock()
+	defer secrets.Unlock()
+
+	for _, value := range values {
+		// short values would redact too much
+		if len(value) < 6 {
+			continue
+		}
+
+		secrets.replacements[value] = RedactURL(value)
+	}
+}
+
+// Return value with the path and query redacted if it is a URL, keeping its
+// host, or redacted entirely if it is not.
+func RedactURL(value string) string {
+	if parsed, err := url.Parse(value); err == nil && parsed.Scheme != "" && parsed.Host != "" {
+		return fmt.Sprintf("%s://%s/%s", parsed.Scheme, parsed.Host, Redacted)
+	}
+
+	return Redacted
+}
+
+// Return s with registered secrets, tokens, webhook URLs and authorization
+// headers replaced.
+func Redact(s string) string {
+	secrets.RLock()
+	values := make([]string, 0, len(secrets.replacements))
+	for value := range secrets.replacements {
+		values = append(values, value)
+	}
+
+	// replace the longest secrets first, they may contain shorter ones
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+
+	for _, value := range values {
+		s = strings.Replace(s, value, secrets.replacements[value], -1)
+	}
+	secrets.RUnlock()
+
+	for _, pattern := range patterns {
+		s = pattern.regexp.ReplaceAllString(s, pattern.replacement)
+	}
+
+	return s
+}
+
+// Return a copy of header with the authorization headers and secrets
+// redacted.
+func RedactHeader(header http.Header) http.Header {
+	redacted := http.Header{}
+	for key, values := range header {
+		for _, value := range values {
+			redacted.Add(key, Redact(value))
+		}
+	}
+
+	for _, key := range sensitiveHeaders {
+		if _, ok := redacted[key]; ok {
+			redacted[key] = []string{Redacted}
+		}
+	}
+
+	return redacted
+}
+
+// A logger that redacts secrets from the entries logged to it.
+type redactor struct {
+	next log.Logger
+}
+
+func (r *redactor) Log(keyvals ...interface{}) error {
+	redacted := make([]interface{}, len(keyvals))
+	for i, value := range keyvals {
+		if i%2 == 1 && isSensitiveKey(keyvals[i-1]) {
+			redacted[i] = Redacted
+			continue
+		}
+
+		redacted[i] = redactValue(value)
+	}
+
+	return r.next.Log(redacted...)
+}
+
+func isSensitiveKey(key interface{}) bool {
+	name, ok := key.(string)
+	if !ok {
+		return false
+	}
+
+	name = strings.ToLower(name)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return Redact(v)
+	case []byte:
+		return Redact(string(v))
+	case time.Time, time.Duration:
+		return v
+	case http.Header:
+		// the logfmt encoder does not support maps
+		return fmt.Sprint(RedactHeader(v))
+	case error:
+		return Redact(v.Error())
+	case fmt.Stringer:
+		return Redact(v.String())
+	default:
+		return v
+	}
+}
@@ -1,6 +1,8 @@
 package msg
 
 import (
+	"github.com/justinbarrick/fluxcloud/pkg/report"
+	"github.com/weaveworks/flux"
 	fluxevent "github.com/weaveworks/flux/event"
 )
 
@@ -11,4 +13,52 @@ type Message struct {
 	Type      string
 	Title     string
 	Event     fluxevent.Event
+
+	// Where the exporter should send the message instead of its configured
+	// destination, as chosen by the routing rules: Slack channels, Matrix room
+	// IDs or webhook URLs.
+	Destinations []string `json:",omitempty"`
+
+	// Identifies a notification that changes over time, such as a deployment
+	// moving through its states. Exporters that can edit messages update the
+	// message they sent with the same key instead of sending a new one.
+	NotificationKey string `json:",omitempty"`
+
+	// The name of the cluster that the event came from, if it is known.
+	Cluster string `json:",omitempty"`
+
+	// The events that a digest combines, Event is the last of them.
+	Events []fluxevent.Event `json:",omitempty"`
+
+	// The summary that a scheduled report is about.
+	Report *report.Report `json:",omitempty"`
+}
+
+// Return the resources that the message is about: those of its event and,
+// for a digest, of every event it combines.
+func (m Message) ServiceIDs() []flux.ResourceID {
+	ids := append([]flux.ResourceID{}, m.Event.ServiceIDs...)
+
+	seen := map[flux.ResourceID]bool{}
+	for _, id := range ids {
+		seen[id] = true
+	}
+
+	for _, event := range m.Events {
+		for _, id := range event.ServiceIDs {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	return ids
+}
+
+// Return true if the message announces its event on its own rather than being
+// a digest, report, alert or deployment built from it, so that it can be
+// formatted again from the event.
+func (m Message) IsEvent() bool {
+	return m.Type != "" && m.Type == m.Event.Type
 }
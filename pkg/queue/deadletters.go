@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"sort"
+	"time"
+)
+
+// Deliveries that could not be sent before they reached the maximum age,
+// kept on disk so that they can be inspected and replayed.
+type DeadLetters struct {
+	*store
+}
+
+// Open the dead letters stored in dir.
+func NewDeadLetters(dir string) (*DeadLetters, error) {
+	store, err := newStore(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeadLetters{store}, nil
+}
+
+// Add a delivery that failed at now.
+func (d *DeadLetters) Add(delivery Delivery, now time.Time) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	delivery.FailedAt = now
+	if err := d.write(&delivery); err != nil {
+		return err
+	}
+
+	d.deliveries[delivery.ID] = &delivery
+	return nil
+}
+
+// Return all dead letters, oldest failure first.
+func (d *DeadLetters) List() []Delivery {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	deliveries := []Delivery{}
+	for _, delivery := range d.deliveries {
+		deliveries = append(deliveries, *delivery)
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].FailedAt.Before(deliveries[j].FailedAt)
+	})
+
+	return deliveries
+}
+
+// Delete a dead letter, returns false if it did not exist.
+func (d *DeadLetters) Remove(id string) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if _, ok := d.deliveries[id]; !ok {
+		return false, nil
+	}
+
+	return true, d.remove(id)
+}
+
+// Delete all dead letters, returning how many were deleted.
+func (d *DeadLetters) Purge() (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	count := 0
+	for id := range d.deliveries {
+		if err := d.remove(id); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
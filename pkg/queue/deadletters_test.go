@@ -0,0 +1,56 @@
+package queue
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fluxcloud-dead")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dead, err := NewDeadLetters(filepath.Join(dir, "dead"))
+	require.NoError(t, err)
+
+	now := time.Now()
+	message := msg.Message{Title: "title", Event: test_utils.NewFluxSyncEvent()}
+
+	assert.Nil(t, dead.Add(Delivery{ID: "2", Exporter: "Slack", Message: message, LastError: "boom"}, now.Add(time.Minute)))
+	assert.Nil(t, dead.Add(Delivery{ID: "1", Exporter: "Webhook", Message: message}, now))
+
+	list := dead.List()
+	require.Len(t, list, 2)
+	assert.Equal(t, "1", list[0].ID)
+	assert.Equal(t, "2", list[1].ID)
+	assert.Equal(t, "boom", list[1].LastError)
+
+	reloaded, err := NewDeadLetters(filepath.Join(dir, "dead"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, reloaded.Len())
+
+	removed, err := dead.Remove("1")
+	assert.Nil(t, err)
+	assert.True(t, removed)
+
+	removed, err = dead.Remove("1")
+	assert.Nil(t, err)
+	assert.False(t, removed)
+
+	count, err := dead.Purge()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 0, dead.Len())
+
+	reloaded, err = NewDeadLetters(filepath.Join(dir, "dead"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, reloaded.Len())
+}
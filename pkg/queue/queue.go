@@ -0,0 +1,263 @@
+package queue
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+)
+
+// A message waiting to be sent through an exporter.
+type Delivery struct {
+	ID          string      `json:"id"`
+	Exporter    string      `json:"exporter"`
+	Message     msg.Message `json:"message"`
+	Attempts    int         `json:"attempts"`
+	CreatedAt   time.Time   `json:"createdAt"`
+	NextAttempt time.Time   `json:"nextAttempt"`
+	LastError   string      `json:"lastError,omitempty"`
+
+	// When the delivery was given up on and moved to the dead letters.
+	FailedAt time.Time `json:"failedAt"`
+}
+
+// Implemented by errors that know when the upstream can be tried again.
+type retryAfter interface {
+	RetryAfter() time.Duration
+}
+
+// Implemented by errors that know that sending again cannot succeed.
+type permanent interface {
+	Permanent() bool
+}
+
+// Controls how failed deliveries are retried.
+type Options struct {
+	// How long to keep retrying a delivery before giving up on it.
+	MaxAge time.Duration
+
+	// The delay before the first retry, doubled after each failed attempt up
+	// to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// The number of deliveries sent through each exporter at once.
+	Concurrency int
+}
+
+// A persistent queue of deliveries, stored as one JSON file per delivery in a
+// directory so that pending deliveries survive a restart. Deliveries that are
+// still failing after the maximum age are moved to the dead letters.
+type Queue struct {
+	*store
+	options Options
+	counter int
+	wake    chan struct{}
+	dead    *DeadLetters
+}
+
+// Load the options for a queue from configuration.
+func NewOptions(config config.Config) (Options, error) {
+	var err error
+	options := Options{}
+
+	options.MaxAge, err = duration(config, "queue_max_age", "24h")
+	if err != nil {
+		return options, err
+	}
+
+	options.InitialBackoff, err = duration(config, "queue_initial_backoff", "1s")
+	if err != nil {
+		return options, err
+	}
+
+	options.MaxBackoff, err = duration(config, "queue_max_backoff", "5m")
+	if err != nil {
+		return options, err
+	}
+
+	value := config.Optional("queue_concurrency", "1")
+	options.Concurrency, err = strconv.Atoi(value)
+	if err != nil || options.Concurrency < 1 {
+		return options, fmt.Errorf("Invalid setting QUEUE_CONCURRENCY: %s", value)
+	}
+
+	return options, nil
+}
+
+func duration(config config.Config, key string, defaultValue string) (time.Duration, error) {
+	value, err := time.ParseDuration(config.Optional(key, defaultValue))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid setting %s: %s", strings.ToUpper(key), err)
+	}
+
+	return value, nil
+}
+
+// Open the queue stored in dir, creating the directory if needed and loading
+// any deliveries that were pending when fluxcloud last stopped. Dead letters
+// are stored in the dead subdirectory.
+func NewQueue(dir string, options Options) (*Queue, error) {
+	store, err := newStore(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	dead, err := NewDeadLetters(filepath.Join(dir, "dead"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Queue{
+		store:   store,
+		options: options,
+		wake:    make(chan struct{}, 1),
+		dead:    dead,
+	}, nil
+}
+
+// Add a message for an exporter to the queue, it will be due immediately.
+func (q *Queue) Enqueue(exporter string, message msg.Message) (Delivery, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := time.Now()
+	q.counter++
+
+	delivery := &Delivery{
+		ID:          fmt.Sprintf("%d-%d", now.UnixNano(), q.counter),
+		Exporter:    exporter,
+		Message:     message,
+		CreatedAt:   now,
+		NextAttempt: now,
+	}
+
+	if err := q.write(delivery); err != nil {
+		return Delivery{}, err
+	}
+
+	q.deliveries[delivery.ID] = delivery
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return *delivery, nil
+}
+
+// Return the deliveries that were given up on.
+func (q *Queue) DeadLetters() *DeadLetters {
+	return q.dead
+}
+
+// Check that deliveries and dead letters can be written to disk.
+func (q *Queue) Check() error {
+	if err := q.store.check(); err != nil {
+		return err
+	}
+
+	return q.dead.check()
+}
+
+// Return the number of deliveries to send through each exporter at once.
+func (q *Queue) Concurrency() int {
+	if q.options.Concurrency < 1 {
+		return 1
+	}
+
+	return q.options.Concurrency
+}
+
+// Returns a channel that receives when new deliveries are enqueued.
+func (q *Queue) Wake() <-chan struct{} {
+	return q.wake
+}
+
+// Return the deliveries that are due at now, oldest first.
+func (q *Queue) Due(now time.Time) []Delivery {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	due := []Delivery{}
+	for _, delivery := range q.deliveries {
+		if !delivery.NextAttempt.After(now) {
+			due = append(due, *delivery)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].CreatedAt.Before(due[j].CreatedAt)
+	})
+
+	return due
+}
+
+// Record a successful delivery, removing it from the queue.
+func (q *Queue) Done(id string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.remove(id)
+}
+
+// Record a failed delivery attempt and schedule the next attempt with
+// exponential backoff and jitter, or later if the error has a RetryAfter.
+// Returns false if the delivery is older than the maximum age or the error is
+// permanent, in which case it is moved to the dead letters.
+func (q *Queue) Retry(id string, sendErr error, now time.Time) (Delivery, bool, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	delivery, ok := q.deliveries[id]
+	if !ok {
+		return Delivery{}, false, fmt.Errorf("Unknown delivery %s", id)
+	}
+
+	delivery.Attempts++
+	delivery.LastError = logging.Redact(sendErr.Error())
+	delivery.NextAttempt = now.Add(q.options.Backoff(delivery.Attempts))
+
+	// respect upstreams that ask us to wait longer, such as with Retry-After
+	if r, ok := sendErr.(retryAfter); ok && now.Add(r.RetryAfter()).After(delivery.NextAttempt) {
+		delivery.NextAttempt = now.Add(r.RetryAfter())
+	}
+
+	p, ok := sendErr.(permanent)
+	if (ok && p.Permanent()) || now.Sub(delivery.CreatedAt) > q.options.MaxAge {
+		if err := q.dead.Add(*delivery, now); err != nil {
+			return *delivery, false, err
+		}
+		return *delivery, false, q.remove(id)
+	}
+
+	return *delivery, true, q.write(delivery)
+}
+
+// Return the delay before the next attempt after a number of failed attempts:
+// InitialBackoff doubled for each previous attempt, capped at MaxBackoff, with
+// up to half of the delay randomized so that retries spread out.
+func (o Options) Backoff(attempts int) time.Duration {
+	backoff := o.InitialBackoff
+	for i := 1; i < attempts && backoff < o.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > o.MaxBackoff {
+		backoff = o.MaxBackoff
+	}
+
+	if backoff <= 1 {
+		return backoff
+	}
+
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
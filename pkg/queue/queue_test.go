@@ -0,0 +1,261 @@
+package queue
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/config"
+	"github.com/justinbarrick/fluxcloud/pkg/msg"
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testOptions = Options{
+	MaxAge:         time.Hour,
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+}
+
+func newTestQueue(t *testing.T) (*Queue, string) {
+	dir, err := ioutil.TempDir("", "fluxcloud-queue")
+	require.NoError(t, err)
+
+	q, err := NewQueue(dir, testOptions)
+	require.NoError(t, err)
+
+	return q, dir
+}
+
+func TestNewOptions(t *testing.T) {
+	config := config.NewFakeConfig()
+
+	options, err := NewOptions(config)
+	assert.Nil(t, err)
+	assert.Equal(t, Options{
+		MaxAge:         24 * time.Hour,
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Minute,
+		Concurrency:    1,
+	}, options)
+
+	config.Set("queue_max_age", "1h")
+	options, err = NewOptions(config)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Hour, options.MaxAge)
+
+	config.Set("queue_concurrency", "4")
+	options, err = NewOptions(config)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, options.Concurrency)
+
+	config.Set("queue_concurrency", "0")
+	_, err = NewOptions(config)
+	assert.NotNil(t, err)
+
+	config.Set("queue_concurrency", "1")
+	config.Set("queue_max_backoff", "later")
+	_, err = NewOptions(config)
+	assert.NotNil(t, err)
+}
+
+func TestQueueEnqueue(t *testing.T) {
+	q, dir := newTestQueue(t)
+	defer os.RemoveAll(dir)
+
+	message := msg.Message{Title: "title", Event: test_utils.NewFluxSyncEvent()}
+
+	delivery, err := q.Enqueue("Slack", message)
+	assert.Nil(t, err)
+	assert.Equal(t, "Slack", delivery.Exporter)
+	assert.Equal(t, 1, q.Len())
+
+	due := q.Due(time.Now())
+	require.Len(t, due, 1)
+	assert.Equal(t, delivery.ID, due[0].ID)
+
+	select {
+	case <-q.Wake():
+	default:
+		t.Fatal("expected queue to wake")
+	}
+
+	reloaded, err := NewQueue(dir, testOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, reloaded.Len())
+
+	loaded, ok := reloaded.Get(delivery.ID)
+	assert.True(t, ok)
+	assert.Equal(t, message.Title, loaded.Message.Title)
+	assert.Equal(t, message.Event.ServiceIDs, loaded.Message.Event.ServiceIDs)
+
+	assert.Nil(t, q.Done(delivery.ID))
+	assert.Equal(t, 0, q.Len())
+
+	reloaded, err = NewQueue(dir, testOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, reloaded.Len())
+}
+
+func TestQueueRetry(t *testing.T) {
+	q, dir := newTestQueue(t)
+	defer os.RemoveAll(dir)
+
+	delivery, err := q.Enqueue("Slack", msg.Message{Title: "title", Event: test_utils.NewFluxSyncEvent()})
+	require.NoError(t, err)
+
+	now := time.Now()
+	retried, retry, err := q.Retry(delivery.ID, errors.New("boom"), now)
+	assert.Nil(t, err)
+	assert.True(t, retry)
+	assert.Equal(t, 1, retried.Attempts)
+	assert.Equal(t, "boom", retried.LastError)
+	assert.True(t, retried.NextAttempt.After(now))
+	assert.Len(t, q.Due(now), 0)
+	assert.Len(t, q.Due(now.Add(time.Second)), 1)
+
+	reloaded, err := NewQueue(dir, testOptions)
+	require.NoError(t, err)
+	loaded, _ := reloaded.Get(delivery.ID)
+	assert.Equal(t, 1, loaded.Attempts)
+
+	_, retry, err = q.Retry(delivery.ID, errors.New("boom"), now.Add(2*time.Hour))
+	assert.Nil(t, err)
+	assert.False(t, retry)
+	assert.Equal(t, 0, q.Len())
+
+	dead, ok := q.DeadLetters().Get(delivery.ID)
+	assert.True(t, ok)
+	assert.Equal(t, 2, dead.Attempts)
+	assert.Equal(t, now.Add(2*time.Hour), dead.FailedAt)
+	assert.Equal(t, "boom", dead.LastError)
+
+	_, _, err = q.Retry(delivery.ID, errors.New("boom"), now)
+	assert.NotNil(t, err)
+}
+
+func TestQueueRetryRedactsErrors(t *testing.T) {
+	q, dir := newTestQueue(t)
+	defer os.RemoveAll(dir)
+
+	delivery, err := q.Enqueue("Matrix", msg.Message{Title: "title", Event: test_utils.NewFluxSyncEvent()})
+	require.NoError(t, err)
+
+	// dead letters are served over HTTP, they must not include tokens
+	sendErr := errors.New(`Post "https://matrix.org/send/m.room.message?access_token=SECRETTOKEN123": dial tcp: timeout`)
+	retried, _, err := q.Retry(delivery.ID, sendErr, time.Now())
+	require.NoError(t, err)
+	assert.NotContains(t, retried.LastError, "SECRETTOKEN123")
+}
+
+type retryAfterError time.Duration
+
+func (r retryAfterError) Error() string {
+	return "rate limited"
+}
+
+func (r retryAfterError) RetryAfter() time.Duration {
+	return time.Duration(r)
+}
+
+func TestQueueRetryAfter(t *testing.T) {
+	q, dir := newTestQueue(t)
+	defer os.RemoveAll(dir)
+
+	delivery, err := q.Enqueue("Slack", msg.Message{Title: "title", Event: test_utils.NewFluxSyncEvent()})
+	require.NoError(t, err)
+
+	now := time.Now()
+	retried, retry, err := q.Retry(delivery.ID, retryAfterError(10*time.Minute), now)
+	assert.Nil(t, err)
+	assert.True(t, retry)
+	assert.Equal(t, now.Add(10*time.Minute), retried.NextAttempt)
+}
+
+type permanentError bool
+
+func (p permanentError) Error() string {
+	return "rejected"
+}
+
+func (p permanentError) Permanent() bool {
+	return bool(p)
+}
+
+func TestQueueRetryPermanent(t *testing.T) {
+	q, dir := newTestQueue(t)
+	defer os.RemoveAll(dir)
+
+	delivery, err := q.Enqueue("Slack", msg.Message{Title: "title", Event: test_utils.NewFluxSyncEvent()})
+	require.NoError(t, err)
+
+	_, retry, err := q.Retry(delivery.ID, permanentError(false), time.Now())
+	assert.Nil(t, err)
+	assert.True(t, retry)
+
+	// an upstream that rejects the message will never accept it
+	_, retry, err = q.Retry(delivery.ID, permanentError(true), time.Now())
+	assert.Nil(t, err)
+	assert.False(t, retry)
+	assert.Equal(t, 0, q.Len())
+
+	dead, ok := q.DeadLetters().Get(delivery.ID)
+	assert.True(t, ok)
+	assert.Equal(t, "rejected", dead.LastError)
+}
+
+func TestQueueSkipsCorruptDeliveries(t *testing.T) {
+	q, dir := newTestQueue(t)
+	defer os.RemoveAll(dir)
+
+	_, err := q.Enqueue("Slack", msg.Message{Title: "title"})
+	require.NoError(t, err)
+
+	reloaded, err := NewQueue(dir, testOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, reloaded.Len())
+}
+
+func TestQueueSkipsOtherFiles(t *testing.T) {
+	q, dir := newTestQueue(t)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "dedupe.json"), []byte(`{"sent": {}}`), 0600))
+
+	_, err := q.Enqueue("Slack", msg.Message{Title: "title", Event: test_utils.NewFluxSyncEvent()})
+	require.NoError(t, err)
+
+	reloaded, err := NewQueue(dir, testOptions)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reloaded.Len())
+}
+
+func TestOptionsBackoff(t *testing.T) {
+	for attempts, max := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		7:  time.Minute,
+		50: time.Minute,
+	} {
+		for i := 0; i < 20; i++ {
+			backoff := testOptions.Backoff(attempts)
+			assert.True(t, backoff <= max, "%d attempts: %s > %s", attempts, backoff, max)
+			assert.True(t, backoff >= max/2, "%d attempts: %s < %s", attempts, backoff, max/2)
+		}
+	}
+}
+
+func TestQueueCheck(t *testing.T) {
+	q, dir := newTestQueue(t)
+	defer os.RemoveAll(dir)
+
+	assert.Nil(t, q.Check())
+
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "dead")))
+	assert.NotNil(t, q.Check())
+}
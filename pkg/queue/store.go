@@ -0,0 +1,109 @@
+package queue
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
+)
+
+// Deliveries kept in memory and persisted as one JSON file each in a directory.
+type store struct {
+	dir        string
+	lock       sync.Mutex
+	deliveries map[string]*Delivery
+}
+
+// Open a store in dir, creating the directory if needed and loading the
+// deliveries that are already in it.
+func newStore(dir string) (*store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &store{
+		dir:        dir,
+		deliveries: map[string]*Delivery{},
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		delivery := &Delivery{}
+		if err := json.Unmarshal(data, delivery); err != nil {
+			logging.Warn("Skipping delivery that could not be loaded", "file", file, "err", err)
+			continue
+		}
+
+		if delivery.ID == "" || delivery.Exporter == "" {
+			logging.Warn("Skipping file that is not a delivery", "file", file)
+			continue
+		}
+
+		s.deliveries[delivery.ID] = delivery
+	}
+
+	return s, nil
+}
+
+// Return a delivery by ID.
+func (s *store) Get(id string) (Delivery, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delivery, ok := s.deliveries[id]
+	if !ok {
+		return Delivery{}, false
+	}
+
+	return *delivery, true
+}
+
+// Return the number of deliveries.
+func (s *store) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.deliveries)
+}
+
+func (s *store) remove(id string) error {
+	delete(s.deliveries, id)
+
+	err := os.Remove(s.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *store) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// Write a delivery to disk, replacing the file atomically.
+func (s *store) write(delivery *Delivery) error {
+	return utils.SaveJSON(s.path(delivery.ID), delivery)
+}
+
+// Check that deliveries can be written to the directory.
+func (s *store) check() error {
+	probe := filepath.Join(s.dir, ".healthcheck")
+	if err := ioutil.WriteFile(probe, []byte{}, 0600); err != nil {
+		return err
+	}
+
+	return os.Remove(probe)
+}
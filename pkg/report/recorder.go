@@ -0,0 +1,64 @@
+package report
+
+import (
+	"sync"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+// Summarizes the events of the current reporting period. If a file is set the
+// summary is saved to it so that a restart does not lose the period.
+type Recorder struct {
+	lock   sync.Mutex
+	file   string
+	report Report
+}
+
+// Create a recorder whose period starts at now, or continue the period saved
+// in file if it is set.
+func NewRecorder(file string, now time.Time) (*Recorder, error) {
+	r := &Recorder{
+		file:   file,
+		report: NewReport(nil, now, time.Time{}),
+	}
+
+	if err := utils.LoadJSON(file, &r.report, "report state"); err != nil {
+		return nil, err
+	}
+
+	// a state file holding null or missing fields leaves them unset
+	if r.report.Start.IsZero() {
+		r.report.Start = now
+	}
+	r.report.init()
+
+	return r, nil
+}
+
+// Add an event to the summary of the current period.
+func (r *Recorder) Add(event fluxevent.Event) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.report.Add(event)
+	return r.save()
+}
+
+// End the current period at now, returning its summary, and start a new
+// period.
+func (r *Recorder) Take(now time.Time) (Report, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	ended := r.report
+	ended.End = now
+	r.report = NewReport(nil, now, time.Time{})
+
+	return ended, r.save()
+}
+
+func (r *Recorder) save() error {
+	return utils.SaveJSON(r.file, r.report)
+}
@@ -0,0 +1,97 @@
+package report
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	start := time.Now()
+	r, err := NewRecorder("", start)
+	require.Nil(t, err)
+
+	require.Nil(t, r.Add(test_utils.NewFluxSyncEvent()))
+	require.Nil(t, r.Add(test_utils.NewFluxCommitEvent()))
+
+	end := start.Add(time.Hour)
+	summary, err := r.Take(end)
+	require.Nil(t, err)
+	assert.Equal(t, start, summary.Start)
+	assert.Equal(t, end, summary.End)
+	assert.Equal(t, 2, summary.Events)
+	assert.Equal(t, 1, summary.Syncs)
+
+	summary, err = r.Take(end.Add(time.Hour))
+	require.Nil(t, err)
+	assert.Equal(t, end, summary.Start)
+	assert.Equal(t, 0, summary.Events)
+}
+
+func TestRecorderPersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "report.json")
+	start := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+
+	r, err := NewRecorder(file, start)
+	require.Nil(t, err)
+	require.Nil(t, r.Add(test_utils.NewFluxSyncErrorEvent()))
+
+	r, err = NewRecorder(file, time.Now())
+	require.Nil(t, err)
+
+	summary, err := r.Take(time.Now())
+	require.Nil(t, err)
+	assert.True(t, start.Equal(summary.Start))
+	assert.Equal(t, 1, summary.Syncs)
+	require.Len(t, summary.FailedResources, 2)
+	assert.Equal(t, "default:persistentvolumeclaim/test", summary.FailedResources[0].String())
+	require.Len(t, summary.Namespaces, 1)
+	assert.Len(t, summary.Namespaces[0].FailedResources, 2)
+}
+
+func TestRecorderUnreadableFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "report.json")
+	require.Nil(t, ioutil.WriteFile(file, []byte("{"), 0600))
+
+	r, err := NewRecorder(file, time.Now())
+	require.Nil(t, err)
+
+	summary, err := r.Take(time.Now())
+	require.Nil(t, err)
+	assert.Equal(t, 0, summary.Events)
+}
+
+func TestRecorderEmptyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, state := range []string{"null", "{}"} {
+		file := filepath.Join(dir, "report.json")
+		require.Nil(t, ioutil.WriteFile(file, []byte(state), 0600))
+
+		start := time.Now()
+		r, err := NewRecorder(file, start)
+		require.Nil(t, err, state)
+
+		summary, err := r.Take(start.Add(time.Hour))
+		require.Nil(t, err, state)
+		assert.Equal(t, start, summary.Start, state)
+		assert.Equal(t, []string{}, summary.ChangedImages, state)
+		assert.Equal(t, []NamespaceReport{}, summary.Namespaces, state)
+	}
+}
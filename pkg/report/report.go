@@ -0,0 +1,211 @@
+package report
+
+import (
+	"sort"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
+	"github.com/weaveworks/flux"
+	fluxevent "github.com/weaveworks/flux/event"
+	"github.com/weaveworks/flux/update"
+)
+
+// A summary of what Flux applied during a period.
+type Report struct {
+	Start time.Time
+	End   time.Time
+
+	// The number of events, syncs and releases (manual or automated).
+	Events   int
+	Syncs    int
+	Releases int
+
+	ChangedImages   []string
+	FailedResources []flux.ResourceID
+
+	// The commits that were applied, the resources the events were about and
+	// the last error of each resource that failed to sync.
+	Commits   []fluxevent.Commit
+	Resources []flux.ResourceID
+	Errors    []fluxevent.ResourceError
+
+	// The same summary for each namespace.
+	Namespaces []NamespaceReport
+}
+
+// A summary of what Flux applied to a namespace during a period.
+type NamespaceReport struct {
+	Namespace       string
+	Syncs           int
+	Releases        int
+	ChangedImages   []string
+	FailedResources []flux.ResourceID
+}
+
+// Summarize the events Flux sent between start and end.
+func NewReport(events []fluxevent.Event, start time.Time, end time.Time) Report {
+	report := Report{
+		Start: start,
+		End:   end,
+	}
+	report.init()
+
+	for _, event := range events {
+		report.Add(event)
+	}
+
+	return report
+}
+
+// Set the lists that are missing, such as in a report loaded from JSON, to
+// empty lists.
+func (r *Report) init() {
+	if r.ChangedImages == nil {
+		r.ChangedImages = []string{}
+	}
+	if r.FailedResources == nil {
+		r.FailedResources = []flux.ResourceID{}
+	}
+	if r.Commits == nil {
+		r.Commits = []fluxevent.Commit{}
+	}
+	if r.Resources == nil {
+		r.Resources = []flux.ResourceID{}
+	}
+	if r.Errors == nil {
+		r.Errors = []fluxevent.ResourceError{}
+	}
+	if r.Namespaces == nil {
+		r.Namespaces = []NamespaceReport{}
+	}
+}
+
+// Add an event to the report.
+func (r *Report) Add(event fluxevent.Event) {
+	r.Events++
+
+	switch event.Type {
+	case fluxevent.EventSync:
+		r.Syncs++
+		for _, id := range onePerNamespace(event.ServiceIDs) {
+			r.namespace(id).Syncs++
+		}
+	case fluxevent.EventRelease, fluxevent.EventAutoRelease:
+		r.Releases++
+		for _, id := range onePerNamespace(event.ServiceIDs) {
+			r.namespace(id).Releases++
+		}
+	}
+
+	for _, commit := range utils.GetCommits(event.Metadata) {
+		r.addCommit(commit)
+	}
+
+	for _, id := range utils.GetResourceIDs(event) {
+		r.Resources = appendResourceIfMissing(r.Resources, id)
+	}
+
+	for id, result := range utils.GetResult(event.Metadata) {
+		if result.Status != update.ReleaseStatusSuccess {
+			continue
+		}
+
+		ns := r.namespace(id)
+		for _, container := range result.PerContainer {
+			r.ChangedImages = appendIfMissing(r.ChangedImages, container.Target.String())
+			ns.ChangedImages = appendIfMissing(ns.ChangedImages, container.Target.String())
+		}
+		sort.Strings(ns.ChangedImages)
+	}
+	sort.Strings(r.ChangedImages)
+
+	for _, resourceError := range utils.GetErrors(event.Metadata) {
+		r.FailedResources = appendResourceIfMissing(r.FailedResources, resourceError.ID)
+		r.addError(resourceError)
+		ns := r.namespace(resourceError.ID)
+		ns.FailedResources = appendResourceIfMissing(ns.FailedResources, resourceError.ID)
+	}
+}
+
+// Add a commit to the report, keeping its message if an earlier event only
+// had its revision.
+func (r *Report) addCommit(commit fluxevent.Commit) {
+	for i, existing := range r.Commits {
+		if existing.Revision == commit.Revision {
+			if existing.Message == "" {
+				r.Commits[i].Message = commit.Message
+			}
+			return
+		}
+	}
+
+	r.Commits = append(r.Commits, commit)
+}
+
+// Add a resource's error to the report, replacing its earlier error.
+func (r *Report) addError(resourceError fluxevent.ResourceError) {
+	for i, existing := range r.Errors {
+		if existing.ID == resourceError.ID {
+			r.Errors[i] = resourceError
+			return
+		}
+	}
+
+	r.Errors = append(r.Errors, resourceError)
+}
+
+// Return the first of ids in each namespace, so that an event that touches
+// several workloads in a namespace counts once for it.
+func onePerNamespace(ids []flux.ResourceID) []flux.ResourceID {
+	seen := map[string]bool{}
+	unique := []flux.ResourceID{}
+
+	for _, id := range ids {
+		namespace, _, _ := id.Components()
+		if !seen[namespace] {
+			seen[namespace] = true
+			unique = append(unique, id)
+		}
+	}
+
+	return unique
+}
+
+// Return the summary of a resource's namespace, adding it if it is missing.
+func (r *Report) namespace(id flux.ResourceID) *NamespaceReport {
+	name, _, _ := id.Components()
+
+	i := sort.Search(len(r.Namespaces), func(i int) bool {
+		return r.Namespaces[i].Namespace >= name
+	})
+
+	if i == len(r.Namespaces) || r.Namespaces[i].Namespace != name {
+		r.Namespaces = append(r.Namespaces, NamespaceReport{})
+		copy(r.Namespaces[i+1:], r.Namespaces[i:])
+		r.Namespaces[i] = NamespaceReport{
+			Namespace:       name,
+			ChangedImages:   []string{},
+			FailedResources: []flux.ResourceID{},
+		}
+	}
+
+	return &r.Namespaces[i]
+}
+
+func appendIfMissing(slice []string, s string) []string {
+	for _, v := range slice {
+		if v == s {
+			return slice
+		}
+	}
+	return append(slice, s)
+}
+
+func appendResourceIfMissing(slice []flux.ResourceID, id flux.ResourceID) []flux.ResourceID {
+	for _, v := range slice {
+		if v == id {
+			return slice
+		}
+	}
+	return append(slice, id)
+}
@@ -0,0 +1,79 @@
+package report
+
+import (
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/flux"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+var (
+	reportStart = time.Date(2019, 4, 8, 9, 0, 0, 0, time.UTC)
+	reportEnd   = time.Date(2019, 4, 15, 9, 0, 0, 0, time.UTC)
+)
+
+func TestNewReport(t *testing.T) {
+	events := []fluxevent.Event{
+		test_utils.NewFluxSyncEvent(),
+		test_utils.NewFluxSyncErrorEvent(),
+		test_utils.NewFluxAutoReleaseEvent(),
+		test_utils.NewFluxCommitEvent(),
+	}
+
+	report := NewReport(events, reportStart, reportEnd)
+	assert.Equal(t, 4, report.Events)
+	assert.Equal(t, 2, report.Syncs)
+	assert.Equal(t, 1, report.Releases)
+	assert.Equal(t, []string{"justinbarrick/nginx:test3"}, report.ChangedImages)
+
+	failed := []flux.ResourceID{
+		flux.MustParseResourceID("default:persistentvolumeclaim/test"),
+		flux.MustParseResourceID("default:persistentvolumeclaim/lol"),
+	}
+	assert.Equal(t, failed, report.FailedResources)
+
+	assert.Equal(t, []fluxevent.Commit{
+		{Revision: "810c2e6f22ac5ab7c831fe0dd697fe32997b098f", Message: "change test image"},
+		{Revision: "4997efcd4ac6255604d0d44eeb7085c5b0eb9d48", Message: "create invalid resource"},
+		{Revision: "d644e1a05db6881abf0cdb78299917b95f442036"},
+	}, report.Commits)
+	assert.Equal(t, []flux.ResourceID{
+		flux.MustParseResourceID("default:deployment/test"),
+		flux.MustParseResourceID("default:persistentvolumeclaim/test"),
+		flux.MustParseResourceID("default:persistentvolumeclaim/lol"),
+	}, report.Resources)
+	require.Len(t, report.Errors, 2)
+	assert.Equal(t, "manifests/test.yaml", report.Errors[0].Path)
+
+	assert.Equal(t, []NamespaceReport{
+		{
+			Namespace:       "default",
+			Syncs:           2,
+			Releases:        1,
+			ChangedImages:   []string{"justinbarrick/nginx:test3"},
+			FailedResources: failed,
+		},
+	}, report.Namespaces)
+}
+
+func TestReportCountsNamespacesOnce(t *testing.T) {
+	event := test_utils.NewFluxSyncEvent()
+	event.ServiceIDs = []flux.ResourceID{
+		flux.MustParseResourceID("default:deployment/api"),
+		flux.MustParseResourceID("default:deployment/worker"),
+		flux.MustParseResourceID("team-a:deployment/api"),
+	}
+
+	// one sync that touches two workloads in a namespace is one sync there
+	report := NewReport([]fluxevent.Event{event}, reportStart, reportEnd)
+	assert.Equal(t, 1, report.Syncs)
+	require.Len(t, report.Namespaces, 2)
+	assert.Equal(t, "default", report.Namespaces[0].Namespace)
+	assert.Equal(t, 1, report.Namespaces[0].Syncs)
+	assert.Equal(t, "team-a", report.Namespaces[1].Namespace)
+	assert.Equal(t, 1, report.Namespaces[1].Syncs)
+}
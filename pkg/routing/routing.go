@@ -0,0 +1,283 @@
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+// A set of routing rules loaded from a file, for example:
+//
+//	{
+//	  "routes": [
+//	    {
+//	      "match": {"namespaces": ["team-b"], "names": ["/^api-/"]},
+//	      "exporters": ["slack:ops"],
+//	      "destinations": ["#team-b"]
+//	    },
+//	    {
+//	      "match": {"errors": true},
+//	      "exporters": ["msteams:oncall"]
+//	    }
+//	  ]
+//	}
+type Routes struct {
+	Routes []Route `json:"routes"`
+}
+
+// A rule that sends matching events to some exporters, optionally overriding
+// where those exporters send them.
+type Route struct {
+	Match Match `json:"match"`
+
+	// The exporters to send matching events to, named as in EXPORTER_TYPE
+	// (such as "slack" or "slack:ops"). If empty, all exporters are used.
+	Exporters []string `json:"exporters"`
+
+	// Where the exporters should send matching events: Slack channels,
+	// Matrix room IDs or webhook URLs. If empty, exporters use their own
+	// configuration.
+	Destinations []string `json:"destinations"`
+}
+
+// The conditions an event must meet for a route to apply. Empty conditions
+// match everything. Patterns are globs, or regular expressions if they are
+// wrapped in slashes such as "/^api-.*$/".
+type Match struct {
+	Namespaces []string `json:"namespaces"`
+	Kinds      []string `json:"kinds"`
+	Names      []string `json:"names"`
+	EventTypes []string `json:"eventTypes"`
+	LogLevels  []string `json:"logLevels"`
+
+	// If set, only match events that do (true) or do not (false) have errors.
+	Errors *bool `json:"errors"`
+}
+
+// Decides which exporters receive an event, and where they send it.
+type Router struct {
+	routes   []Route
+	patterns map[string]*regexp.Regexp
+}
+
+// Load routes from a JSON file.
+func LoadRouter(filename string) (*Router, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	routes := Routes{}
+	if err := json.NewDecoder(file).Decode(&routes); err != nil {
+		return nil, fmt.Errorf("Could not parse routes file %s: %s", filename, err)
+	}
+
+	return NewRouter(routes.Routes)
+}
+
+// Create a router from routes, validating their patterns.
+func NewRouter(routes []Route) (*Router, error) {
+	r := &Router{
+		routes:   routes,
+		patterns: map[string]*regexp.Regexp{},
+	}
+
+	for i, route := range routes {
+		if err := validateExporters(i, route); err != nil {
+			return nil, err
+		}
+
+		m := route.Match
+		for _, patterns := range [][]string{m.Namespaces, m.Kinds, m.Names, m.EventTypes, m.LogLevels} {
+			for _, pattern := range patterns {
+				if err := r.compile(pattern); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		// destinations that are webhook URLs must not be logged
+		for _, destination := range route.Destinations {
+			if strings.Contains(destination, "://") {
+				logging.Secret(destination)
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// Destinations are Slack channels, Matrix rooms or webhook URLs depending on
+// the exporter, so a route that sets them must name exporters of one type.
+func validateExporters(i int, route Route) error {
+	if len(route.Destinations) == 0 {
+		return nil
+	}
+
+	if len(route.Exporters) == 0 {
+		return fmt.Errorf("Route %d sets destinations without exporters", i+1)
+	}
+
+	for _, exporter := range route.Exporters {
+		if exporterType(exporter) != exporterType(route.Exporters[0]) {
+			return fmt.Errorf("Route %d sets destinations for exporters of different types: %s and %s", i+1, route.Exporters[0], exporter)
+		}
+	}
+
+	return nil
+}
+
+// Check that the routes only name exporters that are configured, named as in
+// EXPORTER_TYPE, so that a misspelled exporter does not silently match
+// nothing.
+func (r *Router) CheckExporters(configured []string) error {
+	if r == nil {
+		return nil
+	}
+
+	known := map[string]bool{}
+	for _, exporter := range configured {
+		known[exporter] = true
+	}
+
+	for i, route := range r.routes {
+		for _, exporter := range route.Exporters {
+			if !known[exporter] {
+				return fmt.Errorf("Route %d names exporter %s, which is not in EXPORTER_TYPE", i+1, exporter)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Return the type of an exporter named as in EXPORTER_TYPE, such as "slack"
+// for "slack:ops".
+func exporterType(exporter string) string {
+	return strings.SplitN(exporter, ":", 2)[0]
+}
+
+// Decide whether an exporter should send an event, and to which destinations.
+// A nil router sends every event to every exporter. Otherwise the exporter
+// sends the event if any matching route includes it, and no destinations
+// means the exporter uses its own configuration.
+func (r *Router) Route(event fluxevent.Event, exporter string) (bool, []string) {
+	if r == nil {
+		return true, nil
+	}
+
+	matched := false
+	defaults := false
+	destinations := []string{}
+
+	for _, route := range r.routes {
+		if !includes(route.Exporters, exporter) || !r.matches(route.Match, event) {
+			continue
+		}
+
+		matched = true
+		if len(route.Destinations) == 0 {
+			defaults = true
+		}
+
+		for _, destination := range route.Destinations {
+			destinations = appendIfMissing(destinations, destination)
+		}
+	}
+
+	if !matched || defaults {
+		return matched, nil
+	}
+
+	return true, destinations
+}
+
+func (r *Router) matches(m Match, event fluxevent.Event) bool {
+	if m.Errors != nil && *m.Errors != (len(utils.GetErrors(event.Metadata)) > 0) {
+		return false
+	}
+
+	if !r.any(m.EventTypes, event.Type) || !r.any(m.LogLevels, event.LogLevel) {
+		return false
+	}
+
+	if len(m.Namespaces) == 0 && len(m.Kinds) == 0 && len(m.Names) == 0 {
+		return true
+	}
+
+	for _, id := range utils.GetResourceIDs(event) {
+		namespace, kind, name := id.Components()
+		if r.any(m.Namespaces, namespace) && r.any(m.Kinds, kind) && r.any(m.Names, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Return true if there are no patterns or any pattern matches value.
+func (r *Router) any(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if re, ok := r.patterns[pattern]; ok {
+			if re.MatchString(value) {
+				return true
+			}
+		} else if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Router) compile(pattern string) error {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return fmt.Errorf("Invalid route pattern %s: %s", pattern, err)
+		}
+		r.patterns[pattern] = re
+		return nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("Invalid route pattern %s: %s", pattern, err)
+	}
+
+	return nil
+}
+
+func includes(names []string, name string) bool {
+	if len(names) == 0 {
+		return true
+	}
+
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func appendIfMissing(slice []string, s string) []string {
+	for _, v := range slice {
+		if v == s {
+			return slice
+		}
+	}
+	return append(slice, s)
+}
@@ -0,0 +1,210 @@
+package routing
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNilRouterSendsEverything(t *testing.T) {
+	var router *Router
+
+	routed, destinations := router.Route(test_utils.NewFluxSyncEvent(), "slack")
+	assert.True(t, routed)
+	assert.Nil(t, destinations)
+}
+
+func TestRouteByNamespace(t *testing.T) {
+	router, err := NewRouter([]Route{
+		Route{
+			Match:        Match{Namespaces: []string{"default"}},
+			Exporters:    []string{"slack:ops"},
+			Destinations: []string{"#ops"},
+		},
+	})
+	require.Nil(t, err)
+
+	routed, destinations := router.Route(test_utils.NewFluxSyncEvent(), "slack:ops")
+	assert.True(t, routed)
+	assert.Equal(t, []string{"#ops"}, destinations)
+
+	routed, _ = router.Route(test_utils.NewFluxSyncEvent(), "slack")
+	assert.False(t, routed)
+}
+
+func TestRouteNoMatch(t *testing.T) {
+	router, err := NewRouter([]Route{
+		Route{Match: Match{Namespaces: []string{"team-*"}}},
+	})
+	require.Nil(t, err)
+
+	routed, _ := router.Route(test_utils.NewFluxSyncEvent(), "slack")
+	assert.False(t, routed)
+}
+
+func TestRouteGlobAndRegex(t *testing.T) {
+	router, err := NewRouter([]Route{
+		Route{Match: Match{Kinds: []string{"deploy*"}, Names: []string{"/^te.t$/"}}},
+	})
+	require.Nil(t, err)
+
+	routed, destinations := router.Route(test_utils.NewFluxSyncEvent(), "slack")
+	assert.True(t, routed)
+	assert.Nil(t, destinations)
+}
+
+func TestRouteComponentsMatchSameResource(t *testing.T) {
+	router, err := NewRouter([]Route{
+		Route{Match: Match{Namespaces: []string{"default"}, Kinds: []string{"service"}}},
+	})
+	require.Nil(t, err)
+
+	routed, _ := router.Route(test_utils.NewFluxSyncEvent(), "slack")
+	assert.False(t, routed)
+}
+
+func TestRouteEventTypeAndLogLevel(t *testing.T) {
+	router, err := NewRouter([]Route{
+		Route{Match: Match{EventTypes: []string{"commit"}}},
+		Route{Match: Match{LogLevels: []string{"error"}}},
+	})
+	require.Nil(t, err)
+
+	routed, _ := router.Route(test_utils.NewFluxCommitEvent(), "slack")
+	assert.True(t, routed)
+
+	routed, _ = router.Route(test_utils.NewFluxSyncEvent(), "slack")
+	assert.False(t, routed)
+}
+
+func TestRouteErrors(t *testing.T) {
+	hasErrors := true
+	router, err := NewRouter([]Route{
+		Route{
+			Match:        Match{Errors: &hasErrors, Names: []string{"lol"}},
+			Exporters:    []string{"matrix"},
+			Destinations: []string{"!oncall:matrix"},
+		},
+	})
+	require.Nil(t, err)
+
+	routed, destinations := router.Route(test_utils.NewFluxSyncErrorEvent(), "matrix")
+	assert.True(t, routed)
+	assert.Equal(t, []string{"!oncall:matrix"}, destinations)
+
+	routed, _ = router.Route(test_utils.NewFluxSyncEvent(), "matrix")
+	assert.False(t, routed)
+}
+
+func TestRouteMergesDestinations(t *testing.T) {
+	router, err := NewRouter([]Route{
+		Route{Exporters: []string{"slack"}, Destinations: []string{"#one", "#two"}},
+		Route{Exporters: []string{"slack"}, Destinations: []string{"#two", "#three"}},
+	})
+	require.Nil(t, err)
+
+	routed, destinations := router.Route(test_utils.NewFluxSyncEvent(), "slack")
+	assert.True(t, routed)
+	assert.Equal(t, []string{"#one", "#two", "#three"}, destinations)
+}
+
+func TestRouteDefaultDestinationWins(t *testing.T) {
+	router, err := NewRouter([]Route{
+		Route{Exporters: []string{"slack"}, Destinations: []string{"#one"}},
+		Route{},
+	})
+	require.Nil(t, err)
+
+	routed, destinations := router.Route(test_utils.NewFluxSyncEvent(), "slack")
+	assert.True(t, routed)
+	assert.Nil(t, destinations)
+}
+
+func TestNewRouterInvalidPattern(t *testing.T) {
+	_, err := NewRouter([]Route{
+		Route{Match: Match{Names: []string{"/[/"}}},
+	})
+	assert.NotNil(t, err)
+
+	_, err = NewRouter([]Route{
+		Route{Match: Match{Names: []string{"[-"}}},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestNewRouterDestinationsNeedExporters(t *testing.T) {
+	_, err := NewRouter([]Route{
+		Route{Destinations: []string{"#ops"}},
+	})
+	assert.NotNil(t, err)
+
+	// a Slack channel is not a Matrix room
+	_, err = NewRouter([]Route{
+		Route{Exporters: []string{"slack", "matrix"}, Destinations: []string{"#ops"}},
+	})
+	assert.NotNil(t, err)
+
+	_, err = NewRouter([]Route{
+		Route{Exporters: []string{"slack", "slack:ops"}, Destinations: []string{"#ops"}},
+	})
+	assert.Nil(t, err)
+}
+
+func TestRouterCheckExporters(t *testing.T) {
+	router, err := NewRouter([]Route{
+		Route{Exporters: []string{"slack:ops"}},
+		Route{Match: Match{Namespaces: []string{"default"}}},
+	})
+	require.Nil(t, err)
+
+	assert.Nil(t, router.CheckExporters([]string{"slack:ops", "msteams"}))
+	assert.EqualError(t, router.CheckExporters([]string{"slack:opps", "msteams"}), "Route 1 names exporter slack:ops, which is not in EXPORTER_TYPE")
+	assert.NotNil(t, router.CheckExporters(nil))
+
+	var nilRouter *Router
+	assert.Nil(t, nilRouter.CheckExporters(nil))
+}
+
+func TestLoadRouter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routes")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "routes.json")
+	require.Nil(t, ioutil.WriteFile(filename, []byte(`{
+  "routes": [
+    {
+      "match": {"namespaces": ["default"], "eventTypes": ["sync"]},
+      "exporters": ["slack"],
+      "destinations": ["#default"]
+    }
+  ]
+}`), 0644))
+
+	router, err := LoadRouter(filename)
+	require.Nil(t, err)
+
+	routed, destinations := router.Route(test_utils.NewFluxSyncEvent(), "slack")
+	assert.True(t, routed)
+	assert.Equal(t, []string{"#default"}, destinations)
+}
+
+func TestLoadRouterInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "routes")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "routes.json")
+	require.Nil(t, ioutil.WriteFile(filename, []byte(`{"routes": `), 0644))
+
+	_, err = LoadRouter(filename)
+	assert.NotNil(t, err)
+
+	_, err = LoadRouter(filepath.Join(dir, "missing.json"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,63 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func at(value string) time.Time {
+	t, _ := time.Parse("2006-01-02 15:04", value)
+	return t
+}
+
+func TestScheduleNext(t *testing.T) {
+	for _, test := range []struct {
+		expression string
+		from       string
+		next       string
+	}{
+		{"@daily", "2019-04-10 15:04", "2019-04-11 00:00"},
+		{"@hourly", "2019-04-10 15:04", "2019-04-10 16:00"},
+		{"@weekly", "2019-04-10 15:04", "2019-04-14 00:00"},
+		{"@monthly", "2019-12-10 15:04", "2020-01-01 00:00"},
+		{"0 9 * * 1", "2019-04-10 15:04", "2019-04-15 09:00"},
+		{"0 9 * * 1-5", "2019-04-12 09:00", "2019-04-15 09:00"},
+		{"*/15 * * * *", "2019-04-10 15:04", "2019-04-10 15:15"},
+		{"30 8,17 * * *", "2019-04-10 15:04", "2019-04-10 17:30"},
+		{"0 0 29 2 *", "2019-04-10 15:04", "2020-02-29 00:00"},
+		{"0 0 1 * 7", "2019-04-10 15:04", "2019-04-14 00:00"},
+		{"5 10/5 * * *", "2019-04-10 15:04", "2019-04-10 15:05"},
+	} {
+		s, err := Parse(test.expression)
+		require.Nil(t, err, test.expression)
+		assert.Equal(t, at(test.next), s.Next(at(test.from)), test.expression)
+	}
+}
+
+func TestScheduleNever(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	require.Nil(t, err)
+	assert.True(t, s.Next(at("2019-04-10 15:04")).IsZero())
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@fortnightly",
+	} {
+		_, err := Parse(expression)
+		assert.NotNil(t, err, expression)
+	}
+}
@@ -0,0 +1,84 @@
+package templates
+
+import (
+	"encoding/json"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
+	"github.com/weaveworks/flux"
+	fluxevent "github.com/weaveworks/flux/event"
+	"github.com/weaveworks/flux/update"
+)
+
+// The values that event templates are rendered with.
+type Values struct {
+	VCSLink            string
+	EventID            fluxevent.EventID
+	EventServiceIDs    []flux.ResourceID
+	EventChangedImages []string
+	EventResult        update.Result
+	EventType          string
+	EventStartedAt     time.Time
+	EventEndedAt       time.Time
+	EventLogLevel      string
+	EventMessage       string
+	EventString        string
+	Commits            []fluxevent.Commit
+	Errors             []fluxevent.ResourceError
+	FormatLink         func(string, string) string
+}
+
+// The functions available to templates.
+var FuncMap = template.FuncMap{
+	"replace": func(input, from, to string) string {
+		return strings.Replace(input, from, to, -1)
+	},
+	"trim": func(input string) string {
+		return strings.TrimSpace(input)
+	},
+	"contains": func(input, substr string) bool {
+		return strings.Contains(input, substr)
+	},
+	"truncate": func(s string, max int) string {
+		var numRunes = 0
+		for i := range s {
+			numRunes++
+			if numRunes > max {
+				return s[:i]
+			}
+		}
+		return s
+	},
+	"json": func(value interface{}) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+}
+
+// Return the values to render a template for event with, links are formatted
+// with formatLink.
+func NewValues(vcsLink string, event fluxevent.Event, formatLink func(string, string) string) *Values {
+	return &Values{
+		VCSLink:            vcsLink,
+		EventID:            event.ID,
+		EventServiceIDs:    event.ServiceIDs,
+		EventChangedImages: utils.GetChangedImages(event.Metadata),
+		EventResult:        utils.GetResult(event.Metadata),
+		EventType:          event.Type,
+		EventStartedAt:     event.StartedAt,
+		EventEndedAt:       event.EndedAt,
+		EventLogLevel:      event.LogLevel,
+		EventMessage:       event.Message,
+		EventString:        event.String(),
+		Commits:            utils.GetCommits(event.Metadata),
+		Errors:             utils.GetErrors(event.Metadata),
+		FormatLink:         formatLink,
+	}
+}
+
+// Parse a template with the template functions.
+func Parse(tpl string) (*template.Template, error) {
+	return template.New("tpl").Funcs(FuncMap).Parse(tpl)
+}
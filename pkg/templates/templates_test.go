@@ -0,0 +1,41 @@
+package templates
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func render(t *testing.T, tpl string, values interface{}) string {
+	parsed, err := Parse(tpl)
+	require.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	require.NoError(t, parsed.Execute(out, values))
+	return out.String()
+}
+
+func TestNewValues(t *testing.T) {
+	values := NewValues("https://github.com", test_utils.NewFluxAutoReleaseEvent(), func(link, text string) string {
+		return "<" + link + "|" + text + ">"
+	})
+
+	assert.Equal(t, "autorelease", values.EventType)
+	assert.Equal(t, []string{"justinbarrick/nginx:test3"}, values.EventChangedImages)
+	assert.Equal(t, "<https://github.com|repo>", render(t, `{{ call .FormatLink .VCSLink "repo" }}`, values))
+}
+
+func TestFuncMap(t *testing.T) {
+	assert.Equal(t, "4d030af", render(t, `{{ truncate . 7 }}`, "4d030af4f8e4af14ae35154483b1355bdfeefb73"))
+	assert.Equal(t, `"say \"hi\""`, render(t, `{{ json . }}`, `say "hi"`))
+	assert.Equal(t, "a-b", render(t, `{{ replace . "_" "-" }}`, "a_b"))
+	assert.Equal(t, "true", render(t, `{{ contains . "b" }}`, "abc"))
+}
+
+func TestParseInvalid(t *testing.T) {
+	_, err := Parse("{{ .EventType")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"github.com/weaveworks/flux"
+	fluxevent "github.com/weaveworks/flux/event"
+	"github.com/weaveworks/flux/update"
+)
+
+// Return the commits included in a Flux event.
+func GetCommits(meta fluxevent.EventMetadata) []fluxevent.Commit {
+	switch v := meta.(type) {
+	case *fluxevent.CommitEventMetadata:
+		return []fluxevent.Commit{
+			fluxevent.Commit{
+				Revision: v.Revision,
+			},
+		}
+	case *fluxevent.SyncEventMetadata:
+		return v.Commits
+	default:
+		return []fluxevent.Commit{}
+	}
+}
+
+// Return the result of a release or automated release event.
+func GetResult(meta fluxevent.EventMetadata) update.Result {
+	switch v := meta.(type) {
+	case *fluxevent.AutoReleaseEventMetadata:
+		return v.Result
+	case *fluxevent.ReleaseEventMetadata:
+		return v.Result
+	default:
+		return update.Result{}
+	}
+}
+
+// Return the images changed by a release or automated release event.
+func GetChangedImages(meta fluxevent.EventMetadata) []string {
+	switch v := meta.(type) {
+	case *fluxevent.AutoReleaseEventMetadata:
+		return v.Result.ChangedImages()
+	case *fluxevent.ReleaseEventMetadata:
+		return v.Result.ChangedImages()
+	default:
+		return []string{}
+	}
+}
+
+// Return the resources that failed to apply in a sync event.
+func GetErrors(meta fluxevent.EventMetadata) []fluxevent.ResourceError {
+	switch v := meta.(type) {
+	case *fluxevent.SyncEventMetadata:
+		return v.Errors
+	default:
+		return []fluxevent.ResourceError{}
+	}
+}
+
+// Return the resources a Flux event is about, including resources that failed
+// to sync.
+func GetResourceIDs(event fluxevent.Event) []flux.ResourceID {
+	ids := append([]flux.ResourceID{}, event.ServiceIDs...)
+	for _, resourceError := range GetErrors(event.Metadata) {
+		ids = append(ids, resourceError.ID)
+	}
+	return ids
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+
+	"github.com/justinbarrick/fluxcloud/pkg/logging"
+)
+
+// Load state saved by SaveJSON from file into v, which must be a pointer. If
+// file is empty, does not exist or holds null v is left as is. Unreadable state is logged
+// and ignored, so that a corrupt file does not stop fluxcloud from starting.
+func LoadJSON(file string, v interface{}, description string) error {
+	if file == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// a file holding null would leave v without the maps it was created with
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil
+	}
+
+	loaded := reflect.New(reflect.TypeOf(v).Elem())
+	if err := json.Unmarshal(data, loaded.Interface()); err != nil {
+		logging.Warn("Ignoring unreadable "+description, "file", file, "err", err)
+		return nil
+	}
+
+	reflect.ValueOf(v).Elem().Set(loaded.Elem())
+	return nil
+}
+
+// Save v to file as JSON, replacing the file atomically so that a crash does
+// not leave it half written. Nothing is saved if file is empty.
+func SaveJSON(file string, v interface{}) error {
+	if file == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveAndLoadJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "state.json")
+
+	state := map[string]int{}
+	require.Nil(t, LoadJSON(file, &state, "test state"))
+	assert.Equal(t, map[string]int{}, state)
+
+	require.Nil(t, SaveJSON(file, map[string]int{"a": 1}))
+	require.Nil(t, LoadJSON(file, &state, "test state"))
+	assert.Equal(t, map[string]int{"a": 1}, state)
+
+	_, err = os.Stat(file + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLoadJSONUnreadable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "state.json")
+	require.Nil(t, ioutil.WriteFile(file, []byte(`{"a": 1, "b": "two"`), 0600))
+
+	// partially decoded state does not replace the current one
+	state := map[string]int{"c": 3}
+	require.Nil(t, LoadJSON(file, &state, "test state"))
+	assert.Equal(t, map[string]int{"c": 3}, state)
+}
+
+func TestLoadJSONNull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "state.json")
+	require.Nil(t, ioutil.WriteFile(file, []byte("null\n"), 0600))
+
+	state := map[string]int{}
+	require.Nil(t, LoadJSON(file, &state, "test state"))
+	assert.NotNil(t, state)
+}
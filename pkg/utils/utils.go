@@ -12,3 +12,13 @@ func ParseFluxEvent(reader io.Reader) (event fluxevent.Event, err error) {
 	err = json.NewDecoder(reader).Decode(&event)
 	return
 }
+
+// Return key scoped to a cluster, so that state kept about one cluster does not
+// apply to another. Keys without a cluster are returned as is.
+func ClusterKey(cluster string, key string) string {
+	if cluster == "" {
+		return key
+	}
+
+	return cluster + "/" + key
+}
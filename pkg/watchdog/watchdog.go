@@ -0,0 +1,280 @@
+package watchdog
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+// What the watchdog knows about a cluster running Flux.
+type Cluster struct {
+	Name string `json:"name"`
+
+	// When the cluster was first seen, used in place of the last sync until
+	// it sends one.
+	FirstSeen     time.Time `json:"firstSeen"`
+	LastEvent     time.Time `json:"lastEvent,omitempty"`
+	LastSync      time.Time `json:"lastSync,omitempty"`
+	LastHeartbeat time.Time `json:"lastHeartbeat,omitempty"`
+
+	// Whether the cluster's daemon is connected over the websocket.
+	Connected bool `json:"connected"`
+
+	// Whether an alert was sent because the cluster went quiet.
+	Alerted bool `json:"alerted"`
+
+	// Whether an alert was sent because the cluster stopped syncing.
+	SyncAlerted bool `json:"syncAlerted"`
+}
+
+// An alert that a cluster went quiet or stopped syncing, or that it
+// recovered.
+type Alert struct {
+	Cluster   Cluster
+	Recovered bool
+
+	// Whether the alert is about the cluster not syncing while it may still
+	// answer heartbeats, rather than about it going quiet.
+	Stale bool
+
+	// How long the cluster has to go without syncing or answering
+	// heartbeats, or without syncing if the alert is stale, to be alerted.
+	Threshold time.Duration
+}
+
+// Tracks the last event and websocket heartbeat of each cluster, so that an
+// alert can be sent when a cluster goes quiet instead of looking like nothing
+// changed. If a file is set the clusters are saved to it so that they are
+// still watched after a restart.
+type Watchdog struct {
+	threshold     time.Duration
+	syncThreshold time.Duration
+	file          string
+	started       time.Time
+	lock          sync.Mutex
+	clusters      map[string]*Cluster
+}
+
+// Create a watchdog that alerts when a cluster has not synced or answered a
+// heartbeat for threshold, and, if syncThreshold is set, when it has not
+// synced for syncThreshold even though its daemon answers heartbeats. The
+// clusters saved in file are loaded if it is set, and are given until the
+// thresholds after now to be heard from since events sent while fluxcloud
+// was down are lost.
+func NewWatchdog(threshold time.Duration, syncThreshold time.Duration, file string, now time.Time) (*Watchdog, error) {
+	w := &Watchdog{
+		threshold:     threshold,
+		syncThreshold: syncThreshold,
+		file:          file,
+		started:       now,
+		clusters:      map[string]*Cluster{},
+	}
+
+	if err := utils.LoadJSON(file, &w.clusters, "watchdog state"); err != nil {
+		return nil, err
+	}
+
+	// daemons have to reconnect to the new process
+	for _, cluster := range w.clusters {
+		cluster.Connected = false
+	}
+
+	return w, nil
+}
+
+// Record an event from a cluster, returning recovery alerts if it is a sync
+// from a cluster that was alerted.
+func (w *Watchdog) Event(name string, event fluxevent.Event, now time.Time) ([]Alert, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	cluster := w.cluster(name, now)
+	cluster.LastEvent = now
+
+	if event.Type != fluxevent.EventSync {
+		return nil, w.save()
+	}
+
+	cluster.LastSync = now
+
+	alerts := []Alert{}
+	if cluster.Alerted {
+		cluster.Alerted = false
+		alerts = append(alerts, w.alert(*cluster, true, false))
+	}
+
+	if cluster.SyncAlerted {
+		cluster.SyncAlerted = false
+		alerts = append(alerts, w.alert(*cluster, true, true))
+	}
+
+	return alerts, w.save()
+}
+
+// Record a websocket heartbeat from a cluster, returning a recovery alert if
+// the cluster was alerted for going quiet. Flux only sends sync events when
+// there are new commits, so heartbeats are what show that a quiet cluster is
+// still running. They do not show that it can still sync, so they do not
+// recover a cluster that stopped syncing.
+func (w *Watchdog) Heartbeat(name string, now time.Time) ([]Alert, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	cluster := w.cluster(name, now)
+	cluster.LastHeartbeat = now
+	cluster.Connected = true
+
+	if !cluster.Alerted {
+		return nil, w.save()
+	}
+
+	cluster.Alerted = false
+	return []Alert{w.alert(*cluster, true, false)}, w.save()
+}
+
+// Record that a cluster's daemon disconnected from the websocket. The cluster
+// is still watched, since a daemon that crashed disconnects too.
+func (w *Watchdog) Disconnect(name string, now time.Time) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.cluster(name, now).Connected = false
+	return w.save()
+}
+
+// Return alerts for the clusters that were not alerted yet and have either
+// not synced or answered a heartbeat for the threshold, or not synced for the
+// sync threshold. A daemon that answers heartbeats is alive, but it may have
+// lost access to git, which only the sync threshold catches.
+func (w *Watchdog) Check(now time.Time) ([]Alert, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	alerts := []Alert{}
+	for _, cluster := range w.clusters {
+		if !cluster.Alerted && now.Sub(w.since(cluster.LastSeen())) >= w.threshold {
+			cluster.Alerted = true
+			alerts = append(alerts, w.alert(*cluster, false, false))
+		}
+
+		// a quiet cluster is not syncing either, it is only alerted once
+		if w.syncThreshold <= 0 || cluster.Alerted || cluster.SyncAlerted {
+			continue
+		}
+
+		if now.Sub(w.since(cluster.syncedAt())) >= w.syncThreshold {
+			cluster.SyncAlerted = true
+			alerts = append(alerts, w.alert(*cluster, false, true))
+		}
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].Cluster.Name < alerts[j].Cluster.Name
+	})
+
+	if len(alerts) == 0 {
+		return alerts, nil
+	}
+
+	return alerts, w.save()
+}
+
+// Return all of the watched clusters, sorted by name.
+func (w *Watchdog) Clusters() []Cluster {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	clusters := []Cluster{}
+	for _, cluster := range w.clusters {
+		clusters = append(clusters, *cluster)
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Name < clusters[j].Name
+	})
+
+	return clusters
+}
+
+// Return the last time that the cluster synced or answered a heartbeat, or
+// when it was first seen if it has done neither.
+func (c Cluster) LastSeen() time.Time {
+	lastSeen := c.FirstSeen
+	for _, t := range []time.Time{c.LastSync, c.LastHeartbeat} {
+		if t.After(lastSeen) {
+			lastSeen = t
+		}
+	}
+
+	return lastSeen
+}
+
+// Return the last time that the cluster synced, or when it was first seen if
+// it has not synced.
+func (c Cluster) syncedAt() time.Time {
+	if c.LastSync.IsZero() {
+		return c.FirstSeen
+	}
+
+	return c.LastSync
+}
+
+// Return an event describing an alert, used to filter and route it like a
+// sync event.
+func (a Alert) Event() fluxevent.Event {
+	event := fluxevent.Event{
+		Type:     fluxevent.EventSync,
+		LogLevel: fluxevent.LogLevelError,
+		Metadata: &fluxevent.SyncEventMetadata{},
+	}
+
+	if a.Recovered {
+		event.LogLevel = fluxevent.LogLevelInfo
+	}
+
+	return event
+}
+
+// Return t, or when the watchdog started if that is later, since nothing
+// could be heard from a cluster while fluxcloud was down.
+func (w *Watchdog) since(t time.Time) time.Time {
+	if w.started.After(t) {
+		return w.started
+	}
+
+	return t
+}
+
+func (w *Watchdog) cluster(name string, now time.Time) *Cluster {
+	cluster, ok := w.clusters[name]
+	if !ok {
+		cluster = &Cluster{
+			Name:      name,
+			FirstSeen: now,
+		}
+		w.clusters[name] = cluster
+	}
+
+	return cluster
+}
+
+func (w *Watchdog) alert(cluster Cluster, recovered bool, stale bool) Alert {
+	threshold := w.threshold
+	if stale {
+		threshold = w.syncThreshold
+	}
+
+	return Alert{
+		Cluster:   cluster,
+		Recovered: recovered,
+		Stale:     stale,
+		Threshold: threshold,
+	}
+}
+
+func (w *Watchdog) save() error {
+	return utils.SaveJSON(w.file, w.clusters)
+}
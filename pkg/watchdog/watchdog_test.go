@@ -0,0 +1,219 @@
+package watchdog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/justinbarrick/fluxcloud/pkg/utils/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	fluxevent "github.com/weaveworks/flux/event"
+)
+
+func newTestWatchdog(t *testing.T, threshold, syncThreshold time.Duration, file string, now time.Time) *Watchdog {
+	watchdog, err := NewWatchdog(threshold, syncThreshold, file, now)
+	require.NoError(t, err)
+	return watchdog
+}
+
+func check(t *testing.T, watchdog *Watchdog, now time.Time) []Alert {
+	alerts, err := watchdog.Check(now)
+	require.NoError(t, err)
+	return alerts
+}
+
+func event(t *testing.T, watchdog *Watchdog, name string, e fluxevent.Event, now time.Time) []Alert {
+	alerts, err := watchdog.Event(name, e, now)
+	require.NoError(t, err)
+	return alerts
+}
+
+func heartbeat(t *testing.T, watchdog *Watchdog, name string, now time.Time) []Alert {
+	alerts, err := watchdog.Heartbeat(name, now)
+	require.NoError(t, err)
+	return alerts
+}
+
+func disconnect(t *testing.T, watchdog *Watchdog, name string, now time.Time) {
+	require.NoError(t, watchdog.Disconnect(name, now))
+}
+
+func TestWatchdogAlertsOnce(t *testing.T) {
+	now := time.Now()
+	watchdog := newTestWatchdog(t, 10*time.Minute, 0, "", now)
+
+	assert.Len(t, event(t, watchdog, "cluster", test_utils.NewFluxSyncEvent(), now), 0)
+	assert.Len(t, check(t, watchdog, now.Add(5*time.Minute)), 0)
+
+	alerts := check(t, watchdog, now.Add(10*time.Minute))
+	require.Len(t, alerts, 1)
+	assert.False(t, alerts[0].Recovered)
+	assert.Equal(t, "cluster", alerts[0].Cluster.Name)
+	assert.Equal(t, now, alerts[0].Cluster.LastSync)
+	assert.Equal(t, 10*time.Minute, alerts[0].Threshold)
+	assert.Equal(t, fluxevent.LogLevelError, alerts[0].Event().LogLevel)
+
+	assert.Len(t, check(t, watchdog, now.Add(20*time.Minute)), 0)
+}
+
+func TestWatchdogRecovers(t *testing.T) {
+	now := time.Now()
+	watchdog := newTestWatchdog(t, 10*time.Minute, 0, "", now)
+
+	event(t, watchdog, "cluster", test_utils.NewFluxSyncEvent(), now)
+	require.Len(t, check(t, watchdog, now.Add(time.Hour)), 1)
+
+	// only syncs show that the cluster recovered
+	assert.Len(t, event(t, watchdog, "cluster", test_utils.NewFluxCommitEvent(), now.Add(time.Hour)), 0)
+
+	alerts := event(t, watchdog, "cluster", test_utils.NewFluxSyncEvent(), now.Add(2*time.Hour))
+	require.Len(t, alerts, 1)
+	assert.True(t, alerts[0].Recovered)
+	assert.Equal(t, fluxevent.LogLevelInfo, alerts[0].Event().LogLevel)
+
+	assert.Len(t, check(t, watchdog, now.Add(2*time.Hour+5*time.Minute)), 0)
+	assert.Len(t, check(t, watchdog, now.Add(2*time.Hour+10*time.Minute)), 1)
+}
+
+func TestWatchdogHeartbeatKeepsQuietClusterAlive(t *testing.T) {
+	now := time.Now()
+	watchdog := newTestWatchdog(t, 10*time.Minute, 0, "", now)
+
+	// flux only sends sync events for new commits, a connected daemon that
+	// answers heartbeats is not alerted
+	event(t, watchdog, "cluster", test_utils.NewFluxSyncEvent(), now)
+	for minute := 5; minute <= 60; minute += 5 {
+		heartbeat(t, watchdog, "cluster", now.Add(time.Duration(minute)*time.Minute))
+		assert.Len(t, check(t, watchdog, now.Add(time.Duration(minute)*time.Minute)), 0)
+	}
+
+	// once it disconnects it is alerted a threshold after its last heartbeat
+	disconnect(t, watchdog, "cluster", now.Add(61*time.Minute))
+	assert.Len(t, check(t, watchdog, now.Add(69*time.Minute)), 0)
+
+	alerts := check(t, watchdog, now.Add(70*time.Minute))
+	require.Len(t, alerts, 1)
+	assert.False(t, alerts[0].Cluster.Connected)
+	assert.Equal(t, now, alerts[0].Cluster.LastSync)
+	assert.Equal(t, now.Add(60*time.Minute), alerts[0].Cluster.LastHeartbeat)
+	assert.Equal(t, now.Add(60*time.Minute), alerts[0].Cluster.LastSeen())
+}
+
+func TestWatchdogStaleHeartbeat(t *testing.T) {
+	now := time.Now()
+	watchdog := newTestWatchdog(t, 10*time.Minute, 0, "", now)
+
+	// a daemon that stays connected but stops answering heartbeats is
+	// alerted
+	heartbeat(t, watchdog, "cluster", now)
+	assert.Len(t, check(t, watchdog, now.Add(9*time.Minute)), 0)
+
+	alerts := check(t, watchdog, now.Add(10*time.Minute))
+	require.Len(t, alerts, 1)
+	assert.True(t, alerts[0].Cluster.Connected)
+	assert.True(t, alerts[0].Cluster.LastSync.IsZero())
+
+	// and recovers once it answers again
+	alerts = heartbeat(t, watchdog, "cluster", now.Add(11*time.Minute))
+	require.Len(t, alerts, 1)
+	assert.True(t, alerts[0].Recovered)
+
+	clusters := watchdog.Clusters()
+	require.Len(t, clusters, 1)
+	assert.False(t, clusters[0].Alerted)
+}
+
+func TestWatchdogSyncThreshold(t *testing.T) {
+	now := time.Now()
+	watchdog := newTestWatchdog(t, 10*time.Minute, time.Hour, "", now)
+
+	// a daemon that answers heartbeats but lost access to git is alerted once
+	// it has not synced for the sync threshold
+	event(t, watchdog, "cluster", test_utils.NewFluxSyncEvent(), now)
+	for minute := 5; minute < 60; minute += 5 {
+		heartbeat(t, watchdog, "cluster", now.Add(time.Duration(minute)*time.Minute))
+		assert.Len(t, check(t, watchdog, now.Add(time.Duration(minute)*time.Minute)), 0)
+	}
+
+	heartbeat(t, watchdog, "cluster", now.Add(time.Hour))
+	alerts := check(t, watchdog, now.Add(time.Hour))
+	require.Len(t, alerts, 1)
+	assert.True(t, alerts[0].Stale)
+	assert.False(t, alerts[0].Recovered)
+	assert.True(t, alerts[0].Cluster.Connected)
+	assert.Equal(t, time.Hour, alerts[0].Threshold)
+
+	// heartbeats do not recover it, syncs do
+	assert.Len(t, heartbeat(t, watchdog, "cluster", now.Add(65*time.Minute)), 0)
+	assert.Len(t, check(t, watchdog, now.Add(65*time.Minute)), 0)
+
+	alerts = event(t, watchdog, "cluster", test_utils.NewFluxSyncEvent(), now.Add(70*time.Minute))
+	require.Len(t, alerts, 1)
+	assert.True(t, alerts[0].Stale)
+	assert.True(t, alerts[0].Recovered)
+}
+
+func TestWatchdogSyncThresholdQuietCluster(t *testing.T) {
+	now := time.Now()
+	watchdog := newTestWatchdog(t, 10*time.Minute, time.Hour, "", now)
+
+	// a cluster that went quiet is not also alerted for not syncing
+	event(t, watchdog, "cluster", test_utils.NewFluxSyncEvent(), now)
+	alerts := check(t, watchdog, now.Add(10*time.Minute))
+	require.Len(t, alerts, 1)
+	assert.False(t, alerts[0].Stale)
+
+	assert.Len(t, check(t, watchdog, now.Add(2*time.Hour)), 0)
+}
+
+func TestWatchdogPersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fluxcloud-watchdog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "watchdog.json")
+	now := time.Now()
+
+	watchdog := newTestWatchdog(t, 10*time.Minute, 0, file, now)
+	event(t, watchdog, "quiet", test_utils.NewFluxSyncEvent(), now)
+	event(t, watchdog, "connected", test_utils.NewFluxSyncEvent(), now)
+	heartbeat(t, watchdog, "connected", now.Add(5*time.Minute))
+	require.Len(t, check(t, watchdog, now.Add(10*time.Minute)), 1)
+
+	// clusters are still watched after a restart, without alerting twice
+	restarted := now.Add(time.Hour)
+	reloaded := newTestWatchdog(t, 10*time.Minute, 0, file, restarted)
+	clusters := reloaded.Clusters()
+	require.Len(t, clusters, 2)
+	assert.Equal(t, "connected", clusters[0].Name)
+	assert.False(t, clusters[0].Connected)
+	assert.True(t, now.Add(5*time.Minute).Equal(clusters[0].LastHeartbeat))
+	assert.True(t, clusters[1].Alerted)
+
+	// events sent while fluxcloud was down are lost, so clusters get the
+	// threshold after the restart to be heard from
+	assert.Len(t, check(t, reloaded, restarted.Add(5*time.Minute)), 0)
+
+	alerts := check(t, reloaded, restarted.Add(10*time.Minute))
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "connected", alerts[0].Cluster.Name)
+
+	alerts = event(t, reloaded, "quiet", test_utils.NewFluxSyncEvent(), restarted.Add(11*time.Minute))
+	require.Len(t, alerts, 1)
+	assert.True(t, alerts[0].Recovered)
+}
+
+func TestWatchdogIgnoresCorruptState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fluxcloud-watchdog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "watchdog.json")
+	require.NoError(t, ioutil.WriteFile(file, []byte("{"), 0600))
+
+	watchdog := newTestWatchdog(t, 10*time.Minute, 0, file, time.Now())
+	assert.Len(t, watchdog.Clusters(), 0)
+}